@@ -0,0 +1,239 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// admin 是给运维人员使用的http 接口，可以挂载到任意的mux 上面，比如：
+//
+//	mux.Handle("/admin/", http.StripPrefix("/admin", server.AdminHandler()))
+//
+// 接口列表：
+//
+//	GET  /online                          在线总人数以及每个bucket 的在线人数
+//	GET  /user?identification=            用户是否在线，在哪个bucket，有哪些设备
+//	POST /kick?identification=&reason=    踢掉用户的所有连接
+//	GET  /labels?limit=&page=             分页获取label 列表
+//	GET  /label?name=                     获取label 详情
+//	POST /send?identification=|label=     给用户或者label 发送测试消息，消息内容为body
+//
+// 这些接口没有做任何鉴权，建议只在内网端口上开放，或者由外层的mux 做鉴权
+
+// the max size of test message body
+const adminMaxBodySize = 1 << 16
+
+// BucketInfo is the online information of bucket
+type BucketInfo struct {
+	ID     string `json:"id"`
+	Online int    `json:"online"`
+}
+
+// UserInfo is the online information of user
+type UserInfo struct {
+	Identification string   `json:"identification"`
+	Online         bool     `json:"online"`
+	Bucket         string   `json:"bucket"`
+	Devices        []string `json:"devices"`
+	Tags           []string `json:"tags"`
+	// Connections is the attributes of each connection
+	Connections []map[string]interface{} `json:"connections"`
+}
+
+// Buckets return the online information of each bucket
+func (s *Server) Buckets() []BucketInfo {
+	res := make([]BucketInfo, 0, len(s.bs))
+	for _, bt := range s.bs {
+		res = append(res, BucketInfo{ID: bt.ID(), Online: bt.Count()})
+	}
+	return res
+}
+
+// UserInfo return the online information of user
+func (s *Server) UserInfo(identification string) *UserInfo {
+	bt := s.bucket(identification)
+	res := &UserInfo{Identification: identification, Bucket: bt.ID()}
+	clients, ok := bt.Get(identification)
+	res.Online = ok
+	tags := map[string]struct{}{}
+	for _, cli := range clients {
+		res.Devices = append(res.Devices, cli.Device())
+		res.Connections = append(res.Connections, cli.Attributes())
+		for _, tag := range cli.Tags() {
+			tags[tag] = struct{}{}
+		}
+	}
+	for tag := range tags {
+		res.Tags = append(res.Tags, tag)
+	}
+	return res
+}
+
+// Kick close all the connections of user with the reason , the Options.Offline will be
+// called with OfflineByLogic
+func (s *Server) Kick(identification, reason string) error {
+	if s.running.Load() != RunStatusRunning {
+		return errServerIsNotRunning
+	}
+	if !s.bucket(identification).Offline(identification, reason) {
+		return errUserIsNotOnline
+	}
+	logging.Log.Info("Kick", zap.String("ID", identification), zap.String("REASON", reason))
+	return nil
+}
+
+// AdminHandler return the http handler of admin api
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/online", s.adminOnline)
+	mux.HandleFunc("/user", s.adminUser)
+	mux.HandleFunc("/kick", s.adminKick)
+	mux.HandleFunc("/labels", s.adminLabels)
+	mux.HandleFunc("/label", s.adminLabel)
+	mux.HandleFunc("/send", s.adminSend)
+	return mux
+}
+
+type adminResponse struct {
+	Desc   string      `json:"desc"`
+	Status int         `json:"status"`
+	Data   interface{} `json:"data"`
+}
+
+func writeAdmin(w http.ResponseWriter, status int, desc string, data interface{}) {
+	resp, err := json.Marshal(&adminResponse{Desc: desc, Status: status, Data: data})
+	if err != nil {
+		logging.Log.Error("writeAdmin", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+func (s *Server) adminOnline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	buckets := s.Buckets()
+	var sum int
+	for _, bt := range buckets {
+		sum += bt.Online
+	}
+	writeAdmin(w, http.StatusOK, "ok", map[string]interface{}{
+		"online":  sum,
+		"buckets": buckets,
+	})
+}
+
+func (s *Server) adminUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	identification := r.URL.Query().Get("identification")
+	if identification == "" {
+		writeAdmin(w, http.StatusBadRequest, "identification is empty", nil)
+		return
+	}
+	writeAdmin(w, http.StatusOK, "ok", s.UserInfo(identification))
+}
+
+func (s *Server) adminKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	identification, reason := r.FormValue("identification"), r.FormValue("reason")
+	if identification == "" {
+		writeAdmin(w, http.StatusBadRequest, "identification is empty", nil)
+		return
+	}
+	if err := s.Kick(identification, reason); err != nil {
+		writeAdmin(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+	writeAdmin(w, http.StatusOK, "ok", nil)
+}
+
+func (s *Server) adminLabels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 20
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	writeAdmin(w, http.StatusOK, "ok", map[string]interface{}{
+		"limit":  limit,
+		"page":   page,
+		"labels": s.labels.List(limit, page),
+	})
+}
+
+func (s *Server) adminLabel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	info, err := s.labels.LabelInfo(r.URL.Query().Get("name"))
+	if err != nil {
+		writeAdmin(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+	writeAdmin(w, http.StatusOK, "ok", info)
+}
+
+func (s *Server) adminSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	message, err := io.ReadAll(io.LimitReader(r.Body, adminMaxBodySize))
+	if err != nil || len(message) == 0 {
+		writeAdmin(w, http.StatusBadRequest, "message is empty", nil)
+		return
+	}
+	query := r.URL.Query()
+	identification, tag := query.Get("identification"), query.Get("label")
+	switch {
+	case identification != "":
+		err = s.SendToDevice(message, identification, "")
+	case tag != "":
+		err = s.SendToLabel(tag, message)
+	default:
+		writeAdmin(w, http.StatusBadRequest, "identification and label are empty", nil)
+		return
+	}
+	if err != nil {
+		writeAdmin(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+	writeAdmin(w, http.StatusOK, "ok", nil)
+}
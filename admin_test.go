@@ -0,0 +1,77 @@
+package sim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_AdminHandler(t *testing.T) {
+	s, err := NewServer(&hook{}, WithServerBucketNumber(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	steven := &MockConn{id: "steven", device: "phone"}
+	if _, _, err := s.bucket("steven").Register(steven); err != nil {
+		t.Fatal(err)
+	}
+	s.AddTag("steven", "v1")
+	handler := http.StripPrefix("/admin", s.AdminHandler())
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+		check  func(data interface{}) bool
+	}{
+		{name: "online", method: http.MethodGet, url: "/admin/online", status: http.StatusOK, check: func(data interface{}) bool {
+			return data.(map[string]interface{})["online"].(float64) == 1
+		}},
+		{name: "user online", method: http.MethodGet, url: "/admin/user?identification=steven", status: http.StatusOK, check: func(data interface{}) bool {
+			return data.(map[string]interface{})["online"].(bool)
+		}},
+		{name: "user offline", method: http.MethodGet, url: "/admin/user?identification=mike", status: http.StatusOK, check: func(data interface{}) bool {
+			return !data.(map[string]interface{})["online"].(bool)
+		}},
+		{name: "labels", method: http.MethodGet, url: "/admin/labels?limit=10&page=1", status: http.StatusOK, check: func(data interface{}) bool {
+			return len(data.(map[string]interface{})["labels"].([]interface{})) == 1
+		}},
+		{name: "label not exist", method: http.MethodGet, url: "/admin/label?name=v2", status: http.StatusNotFound},
+		{name: "send to user", method: http.MethodPost, url: "/admin/send?identification=steven", body: "hello", status: http.StatusOK},
+		{name: "send to label", method: http.MethodPost, url: "/admin/send?label=v1", body: "hello v1", status: http.StatusOK},
+		{name: "send without target", method: http.MethodPost, url: "/admin/send", body: "hello", status: http.StatusBadRequest},
+		{name: "kick with get", method: http.MethodGet, url: "/admin/kick?identification=steven", status: http.StatusMethodNotAllowed},
+		{name: "kick", method: http.MethodPost, url: "/admin/kick?identification=steven&reason=test", status: http.StatusOK},
+		{name: "kick offline user", method: http.MethodPost, url: "/admin/kick?identification=steven", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+			if recorder.Code != tt.status {
+				t.Fatalf("status = %v , want %v , body %v", recorder.Code, tt.status, recorder.Body.String())
+			}
+			if tt.check == nil {
+				return
+			}
+			var resp adminResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(resp.Data) {
+				t.Fatalf("unexpected data %v", recorder.Body.String())
+			}
+		})
+	}
+	if len(steven.messages()) != 2 {
+		t.Fatalf("steven received %v messages , want 2", len(steven.messages()))
+	}
+}
@@ -15,25 +15,40 @@ package sim
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/mongofs/sim/pkg/ack"
+	"github.com/mongofs/sim/pkg/auth"
 	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/dispatch"
+	"github.com/mongofs/sim/pkg/label"
 	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/middleware"
+	"github.com/mongofs/sim/pkg/netpoll"
+	"github.com/mongofs/sim/pkg/ratelimit"
+	"github.com/mongofs/sim/pkg/resume"
+	"github.com/mongofs/sim/pkg/rpc"
+	"github.com/mongofs/sim/pkg/timewheel"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"sync"
-	"time"
 )
 
 const (
 	RunStatusRunning = 1 + iota
 	RunStatusStopped
+	RunStatusStopping
+	// RunStatusShutdown the server is shut down , it can't run again
+	RunStatusShutdown
 )
 
 type Hooker interface {
 	// when the client request to make a connection , you need to check the request is legal
 	// or not ,when the client is legal , we will call the ValidateSuccess , the other side will
-	// call the ValidateFailed
+	// call the ValidateFailed , the connection is closed after ValidateFailed returned . It runs
+	// after the upgrade , use Options.Authenticator to refuse the request before the upgrade
 	Validate(token string) error
 	ValidateFailed(err error, cli conn.Connect)
 	ValidateSuccess(cli conn.Connect)
@@ -46,7 +61,17 @@ type Hooker interface {
 	IdentificationHook(w http.ResponseWriter, r *http.Request) (string, error)
 }
 
-type sim struct {
+// LabelHooker is optional , if the Hooker implement it , the tags returned by LabelHook will
+// be attached to the connection after the connection registered to bucket , and the tags will
+// be removed automatically when the connection is offline
+type LabelHooker interface {
+	LabelHook(cli conn.Connect, r *http.Request) []string
+}
+
+// Server is an instance of sim , every Server owns its buckets , options ,hooker and
+// goroutines , so there can be more than one Server in the same process , for example
+// one for customer push and another one for internal operation feed
+type Server struct {
 	// this is the slice of bucket , the bucket implement you can see ./bucket.go
 	// or github/mongofs/sim/bucket.go . for avoid the big locker , the specific
 	// implement use hash crc13 , so you don't worry about the matter of performance
@@ -61,7 +86,10 @@ type sim struct {
 	ctx    context.Context
 
 	// this parameter is for judge sim status ( running or not )
-	running uint
+	running atomic.Uint32
+
+	// exit is closed when all the parallel task of server are finished
+	exit chan struct{}
 
 	// this is the option about sim ,you can see ./option.go or github.com/mongofs/sim/option.go
 	// you can use the function provided by option.go to set the parameters
@@ -69,11 +97,70 @@ type sim struct {
 
 	// this is hook your must to implement
 	hooker Hooker
+
+	// labels is the label manager of server , the connection can be added to label by tag ,
+	// so that you can broadcast message by tag
+	labels label.Manager
+
+	// tracker record the message waiting for ack of client , it is nil when the reliable
+	// mode is turned off
+	tracker *ack.Tracker
+
+	// router keep the methods can be called by client , calls keep the calls to client waiting
+	// for response , both of them work when the envelope protocol is turned on
+	router *rpc.Router
+	calls  *rpc.Pending
+
+	// proxies are the trusted proxies parsed from Options.Attribute
+	proxies []*net.IPNet
+
+	// inflight limit the requests of client served at the same time , and cancel them when the
+	// connection closed
+	inflight *rpc.Inflight
+
+	// receive is the handler of inbound message wrapped by the middlewares , it is the same for
+	// all the transports
+	receive middleware.Handler
+
+	// pool handle the inbound messages asynchronously , it is nil when the messages are handled
+	// in the goroutine of connection
+	pool *dispatch.Pool
+
+	// limits keep the rate limiters of connections and users , it is nil when the rate limit of
+	// inbound message is turned off
+	limits *ratelimit.Manager
+
+	// metrics of the Server , you can expose it by MetricsHandler
+	metrics *serverMetrics
+
+	// wheel run the timers of heartbeat and ack , it is shared by all the buckets , so the
+	// timeout is checked without scanning the users
+	wheel *timewheel.Wheel
+
+	// poller manage the websocket connections when the netpoll backend is used
+	poller *netpoll.Poller
+
+	// transports served by Serve , they are closed when the Server shutdown
+	transportLock sync.Mutex
+	transports    []Transport
+
+	// sessions of SSE and long-polling , the key is the session id
+	sessions sync.Map
+
+	// principals keep the principal of connections authenticated by Authenticator , the key is
+	// the connection
+	principals sync.Map
 }
 
 var (
-	stalk *sim
+	// stalk is the default Server used by the package level functions , it is created
+	// by NewSIMServer
+	stalk *Server
 	once  sync.Once
+
+	// loggerOnce make sure the global logger is initialized only once , the Servers in the
+	// same process share it
+	loggerOnce sync.Once
 )
 
 var (
@@ -84,124 +171,310 @@ var (
 	// the server is not Running
 	errServerIsNotRunning = errors.New("the server is not running ")
 	errServerIsRunning    = errors.New("the server is  running ")
+	errServerIsShutdown   = errors.New("the server is shut down , it can't run again ")
 
 	// hook is nil
 	errHookIsNil = errors.New("hook is nil ")
+
+	// the reliable mode is turned off
+	errAckIsDisabled = errors.New("the ack is disabled , use WithAck to turn on ")
 )
 
-func NewSIMServer(hooker Hooker, opts ...OptionFunc) error {
+// NewServer create a Server , different from NewSIMServer the Server is not stored in
+// package , the caller should hold it and call the method of Server
+func NewServer(hooker Hooker, opts ...OptionFunc) (*Server, error) {
 	if hooker == nil {
-		panic("hook is nil ")
+		return nil, errHookIsNil
 	}
-	if stalk != nil {
-		return errInstanceIsExist
+	options := LoadOptions(hooker, opts...)
+	options.Connection = options.connection()
+	if err := conn.ValidateOption(options.Connection); err != nil {
+		return nil, err
+	}
+	if options.Cluster != nil {
+		if err := options.Cluster.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	var proxies []*net.IPNet
+	if options.Attribute != nil {
+		var err error
+		if proxies, err = parseProxies(options.Attribute.TrustedProxies); err != nil {
+			return nil, err
+		}
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	options := LoadOptions(hooker, opts...)
-	b := &sim{
+	b := &Server{
 		hooker:  hooker,
 		num:     atomic.Int64{},
 		opt:     options,
-		running: RunStatusStopped,
 		ctx:     ctx,
 		cancel:  cancel,
+		exit:    make(chan struct{}),
+		labels:  label.NewManager(),
+		router:  rpc.NewRouter(),
+		calls:   rpc.NewPending(),
+		proxies: proxies,
 	}
-	// logger
-	{
+	b.inflight = rpc.NewInflight(options.MaxInflightRequests)
+	b.running.Store(RunStatusStopped)
+	// the connection option is copied by connection , so it is not shared by other Server
+	b.metrics = newServerMetrics(b)
+	options.Connection.Metrics = b.metrics.conn
+	options.Connection.Done = ctx.Done()
+	// the logger is global , so it is initialized by the first Server , the Servers created
+	// later share it
+	loggerOnce.Do(func() {
 		var loggingOps = []logging.OptionFunc{
 			logging.SetLevel(logging.InfoLevel),
 			logging.SetLogName("sim"),
 			logging.SetLogPath("./log"),
 		}
 		logging.InitZapLogger(b.opt.debug, loggingOps...)
-	}
+	})
 
+	if options.Netpoll != nil {
+		poller, err := netpoll.NewPoller(options.Netpoll)
+		if err != nil {
+			return nil, err
+		}
+		b.poller = poller
+	}
 	b.num.Store(0)
+	b.wheel = timewheel.New(timewheel.DefaultTick, timewheel.DefaultSlots)
 	b.initBucket() // init bucket plugin
+	if b.opt.Ack != nil {
+		// the ack option may be shared by other Server , so copy it before set wheel
+		ackOption := *b.opt.Ack
+		ackOption.Wheel = b.wheel
+		b.opt.Ack = &ackOption
+		b.tracker = ack.NewTracker(b.deliver, b.opt.Ack)
+	}
+	if b.opt.RateLimit != nil {
+		b.limits = ratelimit.NewManager(b.opt.RateLimit)
+	}
+	// the timing is the outermost , so the time spent by all the middlewares is counted
+	b.receive = middleware.Chain(b.dispatch, append([]middleware.Middleware{middleware.Timing(b.metrics.observeReceive)}, b.opt.Middlewares...)...)
+	if b.opt.Dispatch != nil {
+		b.pool = dispatch.NewPool(b.opt.Dispatch, dispatch.Handler(b.receive), b.metrics.observeOverflow(b.opt.Dispatch.Overflow))
+	}
+	return b, nil
+}
 
+// NewSIMServer create the default Server , the package level functions Run , SendMessage ,
+// Upgrade and Stop work on the default Server
+func NewSIMServer(hooker Hooker, opts ...OptionFunc) error {
+	if hooker == nil {
+		panic("hook is nil ")
+	}
+	if stalk != nil {
+		return errInstanceIsExist
+	}
+	b, err := NewServer(hooker, opts...)
+	if err != nil {
+		return err
+	}
 	stalk = b
 	return nil
 }
 
-// This function is
+// Run start the default Server
 func Run() error {
 	if stalk == nil {
 		return errInstanceIsNotExist
 	}
-	if stalk.running != RunStatusStopped {
-		// that is mean the sim not run
-		return errServerIsRunning
-	}
-	return stalk.run()
+	return stalk.Run()
 }
 
+// SendMessage send message by the default Server
 func SendMessage(msg []byte, Users []string) error {
 	if stalk == nil {
 		return errInstanceIsNotExist
 	}
-	if stalk.running != RunStatusRunning {
-		// that is mean the sim not run
-		return errServerIsNotRunning
-	}
-	stalk.sendMessage(msg, Users)
-	return nil
+	return stalk.SendMessage(msg, Users)
 }
 
+// Upgrade upgrade the connection by the default Server
 func Upgrade(w http.ResponseWriter, r *http.Request) error {
 	if stalk == nil {
 		return errInstanceIsNotExist
 	}
-	if stalk.running != RunStatusRunning {
-		// that is mean the sim not run
-		return errServerIsNotRunning
-	}
-	return stalk.upgrade(w, r)
+	return stalk.Upgrade(w, r)
 }
 
+// Stop stop the default Server
 func Stop() error {
 	if stalk == nil {
 		return errInstanceIsNotExist
 	}
-	if stalk.running != RunStatusRunning {
+	return stalk.Stop()
+}
+
+// Run start the goroutines of Server , it will return immediately
+func (s *Server) Run() error {
+	if !s.running.CAS(RunStatusStopped, RunStatusRunning) {
+		if s.running.Load() == RunStatusShutdown {
+			return errServerIsShutdown
+		}
+		// that is mean the sim is running
+		return errServerIsRunning
+	}
+	return s.run()
+}
+
+// SendMessage send message to users , if the users is empty , the message will be sent
+// to all online users of the Server , the message will be sent to all the devices of user .
+// In cluster mode the message is forwarded to the nodes that users are online on , so it
+// works the same from any node
+func (s *Server) SendMessage(msg []byte, Users []string) error {
+	return s.SendMessageWithPriority(msg, Users, conn.PriorityNormal)
+}
+
+// SendMessageWithPriority is the SendMessage with priority , the message of conn.PriorityHigh
+// is written before the normal messages waiting in the queue of connection , and it is never
+// dropped when the connection is weak , so it should only be used by the critical messages
+func (s *Server) SendMessageWithPriority(msg []byte, Users []string, priority conn.Priority) error {
+	if s.running.Load() != RunStatusRunning {
 		// that is mean the sim not run
 		return errServerIsNotRunning
 	}
-	stalk.close()
-	time.Sleep(200 * time.Millisecond)
+	if s.opt.Cluster != nil {
+		return s.route(msg, Users, priority)
+	}
+	s.sendMessage(msg, Users, priority)
 	return nil
 }
 
-type HandleUpgrade func(w http.ResponseWriter, r *http.Request) error
+// SendWithAck send message to user in reliable mode , the message will be resent until the
+// client ack it or the retry limit exceeded , you can use the Future to get the result
+func (s *Server) SendWithAck(msg []byte, user string) (*ack.Future, error) {
+	if s.running.Load() != RunStatusRunning {
+		// that is mean the sim not run
+		return nil, errServerIsNotRunning
+	}
+	if s.tracker == nil {
+		return nil, errAckIsDisabled
+	}
+	return s.tracker.Send(user, msg), nil
+}
 
-func (s *sim) pprof() error {
-	if s.opt.debug {
-		if stalk == nil {
-			return errInstanceIsNotExist
+// SendToDevice send message to the connection of user on the device , it returns error when
+// the user is not online on the device
+func (s *Server) SendToDevice(msg []byte, user, device string) error {
+	if s.running.Load() != RunStatusRunning {
+		// that is mean the sim not run
+		return errServerIsNotRunning
+	}
+	return s.bucket(user).Deliver(msg, user, device)
+}
+
+// Upgrade upgrade the http request to connection and register it to the Server
+func (s *Server) Upgrade(w http.ResponseWriter, r *http.Request) error {
+	if s.running.Load() != RunStatusRunning {
+		// that is mean the sim not run
+		return errServerIsNotRunning
+	}
+	return s.upgrade(w, r)
+}
+
+// Stop stop the Server gracefully , the connections will be drained in the time limit
+// of Options.ShutdownTimeout
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opt.ShutdownTimeout)
+	defer cancel()
+	_, err := s.Shutdown(ctx)
+	return err
+}
+
+// ShutdownReport is the result of Shutdown
+type ShutdownReport struct {
+	// Drained is the number of connections which flushed all messages and received the close frame
+	Drained int
+	// Forced is the number of connections closed before drained , because the ctx is done or the
+	// connection is broken , the messages left in them are lost
+	Forced int
+}
+
+// Shutdown stop the Server gracefully , the Server stop accepting upgrade first , then flush
+// the message queue of each bucket and the buffer of each connection , send the close frame
+// to every client and wait for all goroutines exit . If the ctx is done before that , the
+// remaining connections will be force closed and the error of ctx is returned
+func (s *Server) Shutdown(ctx context.Context) (*ShutdownReport, error) {
+	if !s.running.CAS(RunStatusRunning, RunStatusStopping) {
+		// that is mean the sim not run
+		return nil, errServerIsNotRunning
+	}
+	if s.opt.ServerDiscover != nil {
+		s.opt.ServerDiscover.Deregister()
+	}
+	s.leaveCluster()
+	s.closeTransports()
+	var (
+		report = &ShutdownReport{}
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+	)
+	for _, bt := range s.bs {
+		wg.Add(1)
+		go func(bt bucketInterface) {
+			defer wg.Done()
+			drained, forced := bt.Shutdown(ctx, s.opt.ShutdownCloseCode, s.opt.ShutdownCloseReason)
+			mu.Lock()
+			report.Drained += drained
+			report.Forced += forced
+			mu.Unlock()
+		}(bt)
+	}
+	wg.Wait()
+	if s.poller != nil {
+		s.poller.Close()
+	}
+	if s.pool != nil {
+		if err := s.pool.Close(ctx); err != nil {
+			logging.Log.Warn("Shutdown", zap.Int64("DISPATCH_PENDING", s.pool.Pending()), zap.Error(err))
 		}
-		if stalk.running != RunStatusRunning {
-			// that is mean the sim not run
-			return errServerIsNotRunning
+	}
+	if s.tracker != nil {
+		s.tracker.Close()
+	}
+	if s.opt.Store != nil {
+		if err := s.opt.Store.Close(); err != nil {
+			logging.Log.Error("Shutdown", zap.Error(err))
 		}
-		go func() {
-			http.ListenAndServe(s.opt.PProfPort, nil)
-		}()
 	}
-	return nil
+	s.close()
+
+	var err error
+	select {
+	case <-s.exit:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	logging.Log.Info("Shutdown", zap.Int("DRAINED", report.Drained), zap.Int("FORCED", report.Forced), zap.Error(err))
+	return report, err
+}
+
+// Online return the number of online user , the number is refreshed by monitorBucket
+func (s *Server) Online() int {
+	return s.online()
 }
 
-func (s *sim) run() error {
+type HandleUpgrade func(w http.ResponseWriter, r *http.Request) error
+
+func (s *Server) run() error {
 	if s.opt.ServerDiscover != nil {
+		// Deregister is called in Shutdown
 		s.opt.ServerDiscover.Register()
-		defer func() {
-			s.opt.ServerDiscover.Deregister()
-		}()
 	}
+	s.joinCluster()
 	parallelTask, finishChannel := s.Parallel()
-	s.running = RunStatusRunning
 	go func() {
 		defer func() {
 			if err := recover(); err != nil {
-
+				logging.Log.Error("run", zap.Any("PANIC", err))
 			}
 		}()
 		// monitor the channel and log the out information
@@ -209,79 +482,180 @@ func (s *sim) run() error {
 			select {
 			case <-finishChannel:
 				logging.Log.Info("sim : exit -1 ")
+				close(s.exit)
 				return
 			case finishMark := <-parallelTask:
 				logging.Log.Info("task finish", zap.String("FINISH_TASK", finishMark))
 			}
 		}
 	}()
-	if err := s.pprof(); err != nil {
-		// todo
-		panic(err)
-	}
 	return nil
 }
 
-func (s *sim) online() int {
+func (s *Server) online() int {
 	return int(s.num.Load())
 }
 
 // because there is no parallel problem in slice when you read the data
 // and there is no any operate action on bucket slice ,so not use locker
-func (s *sim) sendMessage(message []byte, users []string) {
+func (s *Server) sendMessage(message []byte, users []string, priority conn.Priority) {
 	if len(users) != 0 {
 		for _, user := range users {
 			bs := s.bucket(user)
-			bs.SendMessage(message, user)
+			bs.SendPriority(message, priority, user)
 		}
 		return
 	}
 	// because there is no parallel problem in slice when you read the data
 	// and there is no any operate action on bucket slice ,so not use locker
 	for _, bt := range s.bs {
-		bt.SendMessage(message)
+		bt.SendPriority(message, priority)
 	}
 	return
 }
-func (s *sim) upgrade(w http.ResponseWriter, r *http.Request) error {
+func (s *Server) upgrade(w http.ResponseWriter, r *http.Request) error {
 	// this is plugin need the coder to implement it
-	identification, err := s.hooker.IdentificationHook(w, r)
+	identification, device, principal, err := s.authenticate(w, r)
 	if err != nil {
+		s.metrics.upgrades.With(TransportWebSocket, upgradeFailure).Inc()
+		s.metrics.observeUnauthorized(TransportWebSocket, err)
+		if s.opt.Authenticator != nil {
+			// the request is refused before the upgrade
+			http.Error(w, err.Error(), auth.StatusCode(err))
+		}
 		return err
 	}
-	bs := s.bucket(identification)
-
-	// try to close the same identification device
-	bs.Offline(identification)
-	sig := bs.SignalChannel()
-	cli, err := conn.NewConn(identification, sig, w, r, s.hooker.HandleReceive)
+	// the old connections of the same identification will be squeezed out by the session
+	// policy when register
+	sig := s.bucket(identification).SignalChannel()
+	var cli conn.Connect
+	if s.poller != nil {
+		cli, err = s.poller.Upgrade(identification, device, sig, w, r, s.handleReceive, s.opt.Connection)
+	} else {
+		cli, err = conn.NewConn(identification, device, sig, w, r, s.handleReceive, s.opt.Connection)
+	}
 	if err != nil {
+		s.metrics.upgrades.With(TransportWebSocket, upgradeFailure).Inc()
+		s.metrics.observeUpgradeError(TransportWebSocket, err)
 		return err
 	}
+	s.attachAttributes(TransportWebSocket, cli, r)
+	if ok, err := s.register(TransportWebSocket, cli, resumeCursor(r), principal); !ok {
+		return err
+	}
+	if hooker, ok := s.hooker.(LabelHooker); ok {
+		s.addTags(cli, hooker.LabelHook(cli, r)...)
+	}
+	return nil
+}
+
+// register validate the connection and register it to bucket , it is shared by all the
+// transports , it returns false when the connection is not registered , the error is nil
+// when the validation is failed , because the connection is handed to ValidateFailed , and it
+// is closed after that . The cursor is presented by the reconnecting client to resume the
+// session , the principal is nil when the connection is not authenticated by Authenticator
+func (s *Server) register(transport string, cli conn.Connect, cursor resume.Cursor, principal *auth.Principal) (bool, error) {
+	identification := cli.Identification()
 	if err := s.hooker.Validate(identification); err != nil {
+		s.metrics.upgrades.With(transport, upgradeFailure).Inc()
 		s.hooker.ValidateFailed(err, cli)
-		return nil
+		cli.Close("validate failed ")
+		return false, nil
 	} else {
 		s.hooker.ValidateSuccess(cli)
 	}
-	if bucketId, userNum, err := bs.Register(cli); err != nil {
+	// report the presence and attach the limiters before register , so the offline reported and
+	// the limiters detached by the callback of bucket are always after them
+	s.presence(identification, true)
+	s.attachLimit(cli)
+	s.attachPrincipal(cli, principal)
+	if bucketId, userNum, err := s.bucket(identification).Resume(cli, cursor); err != nil {
+		s.presence(identification, false)
+		s.detachLimit(cli)
+		s.detachPrincipal(cli)
+		s.metrics.upgrades.With(transport, upgradeFailure).Inc()
 		cli.Close("register to bucket error ")
-		return err
+		return false, err
 	} else {
-		logging.Log.Info("upgrade", zap.String("ID", cli.Identification()), zap.String("BUCKET_ID", bucketId), zap.Int64("BUCKET_ONLINE", userNum))
-		return nil
+		s.metrics.upgrades.With(transport, upgradeSuccess).Inc()
+		logging.Log.Info("register", zap.String("ID", identification), zap.String("TRANSPORT", transport),
+			zap.String("BUCKET_ID", bucketId), zap.Int64("BUCKET_ONLINE", userNum))
 	}
+	return true, nil
 }
 
-func (s *sim) close() error {
+// identification get the identification and device of the request , the device is empty
+// when the hooker not implement DeviceIdentificationHooker
+func (s *Server) identification(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	if hooker, ok := s.hooker.(DeviceIdentificationHooker); ok {
+		return hooker.DeviceIdentificationHook(w, r)
+	}
+	identification, err := s.hooker.IdentificationHook(w, r)
+	return identification, "", err
+}
+
+// handleReceive is the receiver of all the transports , the message is handed to the middlewares
+// and then dispatched , if the pool is set , they are done in the worker of pool . The message
+// exceeds the rate limit is refused before all of them , so it never takes the room of pool
+func (s *Server) handleReceive(cli conn.Connect, data []byte) {
+	if !s.allow(cli, data) {
+		return
+	}
+	if s.pool != nil {
+		// the message refused is reported by the metrics
+		s.pool.Submit(cli, data)
+		return
+	}
+	s.receive(cli, data)
+}
+
+// dispatch filter the ack frame of reliable mode and the refresh frame of token , other message
+// will be handled by hooker , when the envelope protocol is turned on , the control message is
+// handled by server
+func (s *Server) dispatch(cli conn.Connect, data []byte) {
+	if s.opt.Envelope != nil {
+		s.handleEnvelope(cli, data)
+		return
+	}
+	if s.tracker != nil && s.tracker.Handle(cli.Identification(), data) {
+		return
+	}
+	if s.opt.Authenticator != nil {
+		if token, ok := auth.ParseRefresh(data); ok {
+			if err := s.refreshPrincipal(cli, token); err != nil {
+				logging.Log.Warn("refreshPrincipal", zap.String("ID", cli.Identification()), zap.Error(err))
+			}
+			return
+		}
+	}
+	s.hooker.HandleReceive(cli, data)
+}
+
+// deliver send message to user directly , it is the sender of reliable mode
+func (s *Server) deliver(identification string, data []byte) error {
+	return s.bucket(identification).Deliver(data, identification, "")
+}
+
+func (s *Server) close() error {
 	s.cancel()
-	s.running = RunStatusStopped
+	s.running.Store(RunStatusShutdown)
 	return nil
 }
 
-func (s *sim) Parallel() (chan string, chan string) {
+func (s *Server) Parallel() (chan string, chan string) {
 	var prepareParallelFunc = []func(ctx context.Context) (string, error){
 		s.monitorBucket,
+		s.runWheel,
+	}
+	if s.pprofEnabled() {
+		prepareParallelFunc = append(prepareParallelFunc, s.servePprof)
+	}
+	// the monitor of label manager , it will expand , shrink and balance the labels
+	for i, task := range s.labels.Run() {
+		mark, task := fmt.Sprintf("labelManager_%d", i), task
+		prepareParallelFunc = append(prepareParallelFunc, func(ctx context.Context) (string, error) {
+			return mark, task(ctx)
+		})
 	}
 	monitor, closeCn := make(chan string), make(chan string)
 	go func() {
@@ -293,7 +667,9 @@ func (s *sim) Parallel() (chan string, chan string) {
 		wg := sync.WaitGroup{}
 		for _, v := range prepareParallelFunc {
 			wg.Add(1)
+			v := v
 			go func() {
+				defer wg.Done()
 				/*defer func() {
 					if err := recover(); err != nil {
 						logging.Log.Error("Parallel", zap.Any("PANIC", err))
@@ -305,7 +681,6 @@ func (s *sim) Parallel() (chan string, chan string) {
 					return
 				}
 				monitor <- mark
-				wg.Add(-1)
 				return
 			}()
 		}
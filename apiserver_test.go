@@ -1,9 +1,17 @@
 package sim
 
 import (
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
 	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/middleware"
 	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 type hook struct{}
@@ -85,11 +93,9 @@ func TestNewSIMServer(t *testing.T) {
 //
 func TestSendMessage(t *testing.T) {
 	NewSIMServer(&hook{})
-	go func() {
-		if err := Run(); err != nil {
-			panic(err)
-		}
-	}()
+	if err := Run(); err != nil && err != errServerIsRunning {
+		t.Fatal(err)
+	}
 	type args struct {
 		msg   []byte
 		Users []string
@@ -141,3 +147,203 @@ func TestSendMessage(t *testing.T) {
 
 	}
 }
+
+// each Server owns its buckets and goroutines , so more than one Server can run in
+// the same process at the same time
+func TestNewServer(t *testing.T) {
+	if _, err := NewServer(nil); err != errHookIsNil {
+		t.Fatalf("NewServer() error = '%v', wantErr '%v'", err, errHookIsNil)
+	}
+	first, err := NewServer(&hook{}, WithServerBucketNumber(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewServer(&hook{}, WithServerBucketNumber(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.bs) != 2 || len(second.bs) != 4 {
+		t.Fatalf("the buckets of server is shared , first %v ,second %v", len(first.bs), len(second.bs))
+	}
+	if err := first.SendMessage([]byte("hello"), nil); err != errServerIsNotRunning {
+		t.Fatalf("SendMessage() error = '%v', wantErr '%v'", err, errServerIsNotRunning)
+	}
+	for _, s := range []*Server{first, second} {
+		if err := s.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := first.Run(); err != errServerIsRunning {
+		t.Fatalf("Run() error = '%v', wantErr '%v'", err, errServerIsRunning)
+	}
+	if err := first.SendMessage([]byte("hello"), []string{"steven"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Run(); err != errServerIsShutdown {
+		t.Fatalf("Run() error = '%v', wantErr '%v'", err, errServerIsShutdown)
+	}
+	if err := second.SendMessage([]byte("hello"), nil); err != nil {
+		t.Fatalf("stop the first server should not affect the second , err : %v", err)
+	}
+	if err := second.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_SendToLabel(t *testing.T) {
+	s, err := NewServer(&hook{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	var (
+		steven = &MockConn{id: "steven"}
+		mike   = &MockConn{id: "mike"}
+	)
+	for _, cli := range []*MockConn{steven, mike} {
+		if _, _, err := s.bucket(cli.id).Register(cli); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddTag("steven", "v1", "room_2018"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTag("mike", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTag("mikal", "v1"); err != errUserIsNotOnline {
+		t.Fatalf("AddTag() error = '%v', wantErr '%v'", err, errUserIsNotOnline)
+	}
+
+	if err := s.SendToLabel("v1", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendToLabels([]byte("v1 and room_2018"), []string{"v1", "room_2018"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(steven.messages()) != 2 || len(mike.messages()) != 1 {
+		t.Fatalf("steven received %v , mike received %v , want 2 and 1", len(steven.messages()), len(mike.messages()))
+	}
+
+	// the tags should be removed when the user is offline
+	s.bucket("steven").Offline("steven", "")
+	if len(steven.Tags()) != 0 {
+		t.Fatalf("the tags of offline user is not removed : %v", steven.Tags())
+	}
+	if info, err := s.labels.LabelInfo("room_2018"); err != nil || info.Online != 0 {
+		t.Fatalf("the label room_2018 should be empty , info %v ,err %v", info, err)
+	}
+}
+
+func TestServer_Middleware(t *testing.T) {
+	var handled []string
+	s, err := NewServer(&echoHook{}, WithMiddleware(middleware.Recovery(false), func(next middleware.Handler) middleware.Handler {
+		return func(cli conn.Connect, data []byte) {
+			handled = append(handled, string(data))
+			if string(data) == "panic" {
+				panic("middleware panic")
+			}
+			next(cli, data)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &MockConn{id: "steven"}
+	s.handleReceive(cli, []byte("panic"))
+	s.handleReceive(cli, []byte("hello"))
+	if len(handled) != 2 || len(cli.messages()) != 1 {
+		t.Fatalf("handled = %v , echo = %q", handled, cli.messages())
+	}
+	recorder := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if want := "sim_receive_duration_seconds_count 2"; !strings.Contains(recorder.Body.String(), want) {
+		t.Fatalf("metrics should contain %q , got \n%v", want, recorder.Body.String())
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Upgrade(w, r)
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	before := runtime.NumGoroutine()
+
+	var clients []*websocket.Conn
+	for i := 0; i < 50; i++ {
+		identification := fmt.Sprintf("user_%d", i)
+		client, _, err := websocket.DefaultDialer.Dial(url+"?id="+identification, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+		waitOnline(t, s, identification)
+	}
+	report, err := s.Shutdown(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Drained != 50 || report.Forced != 0 {
+		t.Fatalf("Shutdown() = %+v , want 50 drained", report)
+	}
+	for _, client := range clients {
+		_, _, err := client.ReadMessage()
+		if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != DefaultShutdownCloseCode {
+			t.Fatalf("client should receive the close frame , got %v", err)
+		}
+		client.Close()
+	}
+	// the connections closed are removed from the bucket , so the resources of them are released
+	for _, bt := range s.bs {
+		if bt.Count() != 0 {
+			t.Fatalf("the bucket still has %v connections", bt.Count())
+		}
+	}
+	var after int
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if after = runtime.NumGoroutine(); after <= before {
+			return
+		}
+	}
+	t.Fatalf("the goroutines are leaked , before %v after %v", before, after)
+}
+
+func TestNewServer_ConnectionOption(t *testing.T) {
+	backpressure := &conn.Backpressure{Policy: conn.BackpressureDropOldest}
+	shared := conn.DefaultOption()
+	// the options of connection work in any order with WithConnectionOption
+	handshake := &conn.Handshake{AllowedOrigins: []string{"*"}}
+	s, err := NewServer(&hook{}, WithBackpressure(backpressure), WithPingInterval(time.Second), WithHandshake(handshake), WithConnectionOption(shared))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.opt.Connection.Handshake != handshake {
+		t.Fatalf("the handshake is discarded by WithConnectionOption , got %+v", s.opt.Connection)
+	}
+	if s.opt.Connection.PingInterval != time.Second {
+		t.Fatalf("the ping interval is discarded by WithConnectionOption , got %+v", s.opt.Connection)
+	}
+	if s.opt.Connection.Backpressure != backpressure {
+		t.Fatalf("the backpressure is discarded by WithConnectionOption , got %+v", s.opt.Connection)
+	}
+	if shared.Backpressure != nil || shared.Handshake != nil {
+		t.Fatal("the option of connection shared should not be modified")
+	}
+	if _, err := NewServer(&hook{}, WithConnectionOption(nil), WithBackpressure(backpressure)); err != conn.ErrOptionIsNil {
+		t.Fatalf("NewServer() error = '%v', wantErr '%v'", err, conn.ErrOptionIsNil)
+	}
+}
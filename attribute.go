@@ -0,0 +1,150 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// AttributeOption decide the attributes collected from the http request at upgrade , the
+// transport , remote address , user agent and device are always collected
+type AttributeOption struct {
+	// Headers map the header to the attribute , for example {"X-App-Version": "app_version"} ,
+	// the attribute is not set when the header is empty
+	Headers map[string]string
+
+	// Queries map the query parameter to the attribute , for example {"tenant": "tenant"}
+	Queries map[string]string
+
+	// TrustForwarded use the address in X-Forwarded-For or the X-Real-IP as the remote address ,
+	// turn it on only when the server is behind the trusted proxy . The proxy appends its peer to
+	// X-Forwarded-For , and the entries on the left can be forged by client , so the address is
+	// the rightmost entry not in TrustedProxies
+	TrustForwarded bool
+
+	// TrustedProxies are the addresses or CIDRs of the proxies in front of the server , such as
+	// "10.0.0.0/8" , the forwarded headers of the request not from them are ignored . If it is
+	// empty , the server is treated as behind one proxy , and the rightmost entry is used
+	TrustedProxies []string
+}
+
+// parseProxies parse the addresses and CIDRs of proxies
+func parseProxies(proxies []string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("sim : the trusted proxy %q is not an ip or cidr", proxy)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, network)
+	}
+	return res, nil
+}
+
+// trusted judge the address is one of the proxies
+func trusted(addr string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AttributeHooker is optional , if the Hooker implement it , the attributes returned by
+// AttributeHook are attached to the connection before it registered , for example the tenant
+// resolved when identifying the user . It is called by the transports upgraded from http only
+type AttributeHooker interface {
+	AttributeHook(cli conn.Connect, r *http.Request) map[string]interface{}
+}
+
+// attachAttributes set the attributes of the connection upgraded from the request , it must be
+// called before the connection registered , so the hooker can read them
+func (s *Server) attachAttributes(transport string, cli conn.Connect, r *http.Request) {
+	option := s.opt.Attribute
+	if option == nil {
+		option = &AttributeOption{}
+	}
+	cli.SetAttribute(conn.AttrTransport, transport)
+	cli.SetAttribute(conn.AttrRemoteAddr, remoteAddr(r, option.TrustForwarded, s.proxies))
+	if agent := r.UserAgent(); agent != "" {
+		cli.SetAttribute(conn.AttrUserAgent, agent)
+	}
+	if device := cli.Device(); device != "" {
+		cli.SetAttribute(conn.AttrDevice, device)
+	}
+	for header, key := range option.Headers {
+		if value := r.Header.Get(header); value != "" {
+			cli.SetAttribute(key, value)
+		}
+	}
+	query := r.URL.Query()
+	for name, key := range option.Queries {
+		if value := query.Get(name); value != "" {
+			cli.SetAttribute(key, value)
+		}
+	}
+	if hooker, ok := s.hooker.(AttributeHooker); ok {
+		for key, value := range hooker.AttributeHook(cli, r) {
+			cli.SetAttribute(key, value)
+		}
+	}
+}
+
+// remoteAddr return the ip of client , the forwarded headers are honored when trusted and the
+// request is from the proxies
+func remoteAddr(r *http.Request, trustForwarded bool, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustForwarded || (len(proxies) > 0 && !trusted(host, proxies)) {
+		return host
+	}
+	// the header may be sent in several lines , each proxy appends the address of its peer , so
+	// walk from the right and skip the proxies
+	entries := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(entries[i])
+		if addr == "" || (len(proxies) > 0 && trusted(addr, proxies) && i > 0) {
+			continue
+		}
+		return addr
+	}
+	if addr := strings.TrimSpace(r.Header.Get("X-Real-IP")); addr != "" {
+		return addr
+	}
+	return host
+}
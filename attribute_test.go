@@ -0,0 +1,93 @@
+package sim
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// tenantHook resolve the tenant of user
+type tenantHook struct {
+	echoHook
+}
+
+func (h *tenantHook) AttributeHook(cli conn.Connect, r *http.Request) map[string]interface{} {
+	return map[string]interface{}{"tenant": "tenant_" + cli.Identification()}
+}
+
+func TestServer_AttachAttributes(t *testing.T) {
+	s, err := NewServer(&tenantHook{}, WithAttribute(&AttributeOption{
+		Headers: map[string]string{"X-App-Version": "app_version"},
+		Queries: map[string]string{"channel": "channel"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "/conn?channel=appstore", nil)
+	r.RemoteAddr = "10.0.0.1:5678"
+	r.Header.Set("User-Agent", "sim-client")
+	r.Header.Set("X-App-Version", "1.2.0")
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 10.0.0.2")
+	cli := &MockConn{id: "steven", device: "ios"}
+	s.attachAttributes(TransportWebSocket, cli, r)
+	want := map[string]string{
+		conn.AttrTransport:  TransportWebSocket,
+		conn.AttrRemoteAddr: "10.0.0.1", // the forwarded header is not trusted by default
+		conn.AttrUserAgent:  "sim-client",
+		conn.AttrDevice:     "ios",
+		"app_version":       "1.2.0",
+		"channel":           "appstore",
+		"tenant":            "tenant_steven",
+	}
+	for key, value := range want {
+		if got := conn.StringAttribute(cli, key); got != value {
+			t.Fatalf("attribute %s = %q , want %q", key, got, value)
+		}
+	}
+	if len(cli.Attributes()) != len(want) {
+		t.Fatalf("Attributes() = %v , want %v", cli.Attributes(), want)
+	}
+}
+
+func TestRemoteAddr(t *testing.T) {
+	proxies, err := parseProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseProxies([]string{"proxy"}); err == nil {
+		t.Fatal("parseProxies() should refuse the address which is not ip or cidr")
+	}
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		realIP    string
+		trust     bool
+		proxies   []*net.IPNet
+		want      string
+	}{
+		{name: "not trusted", remote: "10.0.0.1:5678", forwarded: []string{"1.1.1.1"}, want: "10.0.0.1"},
+		{name: "one proxy", remote: "10.0.0.1:5678", forwarded: []string{"6.6.6.6, 1.1.1.1"}, trust: true, want: "1.1.1.1"},
+		{name: "skip proxies", remote: "10.0.0.1:5678", forwarded: []string{"6.6.6.6, 1.1.1.1", "192.168.1.1, 10.0.0.2"}, trust: true, proxies: proxies, want: "1.1.1.1"},
+		{name: "not from proxy", remote: "3.3.3.3:5678", forwarded: []string{"1.1.1.1"}, trust: true, proxies: proxies, want: "3.3.3.3"},
+		{name: "real ip", remote: "10.0.0.1:5678", realIP: "2.2.2.2", trust: true, proxies: proxies, want: "2.2.2.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/conn", nil)
+			r.RemoteAddr = tt.remote
+			for _, forwarded := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if addr := remoteAddr(r, tt.trust, tt.proxies); addr != tt.want {
+				t.Fatalf("remoteAddr() = %v , want %v", addr, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mongofs/sim/pkg/auth"
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/timewheel"
+	"go.uber.org/zap"
+)
+
+var (
+	errRefreshNotSupported = errors.New("the authenticator not implement auth.Validator , the token can't be refreshed ")
+	errPrincipalNotExist   = errors.New("the connection is not authenticated ")
+)
+
+// credential is the principal attached to connection , timer close the connection when the
+// principal is expired
+type credential struct {
+	mu        sync.Mutex
+	principal *auth.Principal
+	timer     *timewheel.Timer
+}
+
+// authenticate the request before the upgrade , the identification and device is the principal
+// of Authenticator , when the Authenticator is not set , they are returned by the hooker
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (string, string, *auth.Principal, error) {
+	if s.opt.Authenticator == nil {
+		identification, device, err := s.identification(w, r)
+		return identification, device, nil, err
+	}
+	principal, err := s.opt.Authenticator.Authenticate(r)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return principal.Subject, principal.Device, principal, nil
+}
+
+// Principal return the principal of connection authenticated by Authenticator
+func (s *Server) Principal(cli conn.Connect) (*auth.Principal, bool) {
+	v, ok := s.principals.Load(cli)
+	if !ok {
+		return nil, false
+	}
+	c := v.(*credential)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.principal, true
+}
+
+// attachPrincipal attach the principal to the connection registered , the connection is closed
+// when the principal expired
+func (s *Server) attachPrincipal(cli conn.Connect, principal *auth.Principal) {
+	if principal == nil {
+		return
+	}
+	c := &credential{principal: principal}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.principals.Store(cli, c)
+	if !principal.ExpiresAt.IsZero() {
+		c.timer = s.wheel.AfterFunc(time.Until(principal.ExpiresAt), func() { s.expirePrincipal(cli) })
+	}
+}
+
+func (s *Server) detachPrincipal(cli conn.Connect) {
+	v, ok := s.principals.LoadAndDelete(cli)
+	if !ok {
+		return
+	}
+	c := v.(*credential)
+	c.mu.Lock()
+	c.timer.Stop()
+	c.mu.Unlock()
+}
+
+// refreshPrincipal replace the principal of connection by the token sent in band , the timer is
+// reset by the new expiration
+func (s *Server) refreshPrincipal(cli conn.Connect, token string) error {
+	validator, ok := s.opt.Authenticator.(auth.Validator)
+	if !ok {
+		return errRefreshNotSupported
+	}
+	v, ok := s.principals.Load(cli)
+	if !ok {
+		return errPrincipalNotExist
+	}
+	c := v.(*credential)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	principal, err := auth.Refresh(validator, c.principal, token)
+	if err != nil {
+		return err
+	}
+	c.principal = principal
+	switch {
+	case principal.ExpiresAt.IsZero():
+		c.timer.Stop()
+		c.timer = nil
+	case c.timer != nil:
+		c.timer.Reset(time.Until(principal.ExpiresAt))
+	default:
+		c.timer = s.wheel.AfterFunc(time.Until(principal.ExpiresAt), func() { s.expirePrincipal(cli) })
+	}
+	return nil
+}
+
+// expirePrincipal close the connection whose principal is expired , it is called by the wheel
+func (s *Server) expirePrincipal(cli conn.Connect) {
+	principal, ok := s.Principal(cli)
+	// the principal may be refreshed before the timer run
+	if !ok || !principal.Expired(time.Now()) {
+		return
+	}
+	logging.Log.Info("expirePrincipal", zap.String("ID", cli.Identification()), zap.Time("EXPIRES_AT", principal.ExpiresAt))
+	// the wheel should not be blocked by Shutdown
+	go func() {
+		ctx, cancel := context.WithTimeout(s.ctx, s.opt.ShutdownTimeout)
+		defer cancel()
+		cli.Shutdown(ctx, auth.ExpiredCloseCode, auth.ExpiredCloseReason)
+	}()
+}
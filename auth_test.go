@@ -0,0 +1,216 @@
+package sim
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mongofs/sim/pkg/auth"
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/resume"
+)
+
+var testSecret = []byte("steven")
+
+// signHS256 create the token of claims signed by testSecret
+func signHS256(t *testing.T, claims map[string]interface{}) string {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, testSecret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestServer_Authenticate(t *testing.T) {
+	option := auth.DefaultOption()
+	option.Secret = testSecret
+	option.Authorize = func(principal *auth.Principal) error {
+		if principal.Subject == "banned" {
+			return errors.New("the user is banned")
+		}
+		return nil
+	}
+	authenticator, err := auth.NewJWT(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2), WithAuthenticator(authenticator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Upgrade(w, r)
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	expires := time.Now().Add(time.Minute).Unix()
+	for query, status := range map[string]int{
+		"":                     http.StatusUnauthorized,
+		"?access_token=steven": http.StatusUnauthorized,
+		"?access_token=" + signHS256(t, map[string]interface{}{"sub": "banned"}): http.StatusForbidden,
+	} {
+		_, resp, err := websocket.DefaultDialer.Dial(url+query, nil)
+		if err == nil || resp == nil || resp.StatusCode != status {
+			t.Fatalf("Dial(%q) = %v , want status %v", query, err, status)
+		}
+	}
+	client, _, err := websocket.DefaultDialer.Dial(url+"?access_token="+signHS256(t, map[string]interface{}{"sub": "steven", "exp": expires}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	waitOnline(t, s, "steven")
+	clients, _ := s.bucket("steven").Get("steven")
+	principal, ok := s.Principal(clients[0])
+	if !ok || principal.Subject != "steven" || principal.ExpiresAt.Unix() != expires {
+		t.Fatalf("Principal() = %+v , %v", principal, ok)
+	}
+
+	// the token of other user is refused , and the refresh frame is not handed to the hooker
+	refresh := func(claims map[string]interface{}) {
+		if err := client.WriteMessage(websocket.TextMessage, []byte("#auth:"+signHS256(t, claims))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	refresh(map[string]interface{}{"sub": "mike", "exp": expires + 60})
+	refresh(map[string]interface{}{"sub": "steven", "exp": expires + 60})
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if principal, _ := s.Principal(clients[0]); principal.ExpiresAt.Unix() == expires+60 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the token is not refreshed")
+		}
+	}
+	if err := client.WriteMessage(websocket.TextMessage, []byte("echo")); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, data, err := client.ReadMessage(); err != nil || string(data) != "echo" {
+		t.Fatalf("client received %q , %v , want echo", data, err)
+	}
+}
+
+// shutdownConn record the close frame and the times of Shutdown
+type shutdownConn struct {
+	*MockConn
+	mu    sync.Mutex
+	code  int
+	times int
+}
+
+func (c *shutdownConn) Shutdown(ctx context.Context, code int, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.code = code
+	c.times++
+	return nil
+}
+
+func (c *shutdownConn) shutdownTimes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.times
+}
+
+func (c *shutdownConn) closeCode() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.code
+}
+
+func TestServer_PrincipalExpired(t *testing.T) {
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	cli := &shutdownConn{MockConn: &MockConn{id: "steven"}}
+	principal := &auth.Principal{Subject: "steven", ExpiresAt: time.Now().Add(200 * time.Millisecond)}
+	if ok, err := s.register(TransportWebSocket, cli, resume.Cursor{}, principal); !ok {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(2 * time.Second); cli.closeCode() != auth.ExpiredCloseCode; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the connection should be closed when the token expired")
+		}
+	}
+}
+
+func TestServer_PrincipalLeeway(t *testing.T) {
+	authenticator, err := auth.NewJWT(&auth.Option{Secret: testSecret, Leeway: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2), WithAuthenticator(authenticator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	// the token is expired , but it is still in the leeway
+	principal, err := authenticator.Validate(signHS256(t, map[string]interface{}{"sub": "steven", "exp": time.Now().Add(-time.Second).Unix()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &shutdownConn{MockConn: &MockConn{id: "steven"}}
+	if ok, err := s.register(TransportWebSocket, cli, resume.Cursor{}, principal); !ok {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if cli.closeCode() != 0 {
+		t.Fatal("the connection accepted in leeway should not be closed at once")
+	}
+}
+
+// rejectHook refuse all the connections
+type rejectHook struct {
+	echoHook
+	failed []conn.Connect
+}
+
+func (h *rejectHook) Validate(token string) error {
+	return errors.New("validate failed")
+}
+
+func (h *rejectHook) ValidateFailed(err error, cli conn.Connect) {
+	h.failed = append(h.failed, cli)
+}
+
+func TestServer_ValidateFailed(t *testing.T) {
+	hooker := &rejectHook{}
+	s, err := NewServer(hooker, WithServerBucketNumber(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli := &MockConn{id: "steven"}
+	if ok, err := s.register(TransportWebSocket, cli, resume.Cursor{}, nil); ok || err != nil {
+		t.Fatalf("register() = %v , %v , want false and nil", ok, err)
+	}
+	if len(hooker.failed) != 1 || cli.closedReason() == "" {
+		t.Fatalf("the connection should be handed to ValidateFailed and closed , closed %q", cli.closedReason())
+	}
+}
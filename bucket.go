@@ -23,6 +23,10 @@ import (
 
 	"github.com/mongofs/sim/pkg/conn"
 	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/metrics"
+	"github.com/mongofs/sim/pkg/resume"
+	"github.com/mongofs/sim/pkg/store"
+	"github.com/mongofs/sim/pkg/timewheel"
 	"go.uber.org/atomic"
 )
 
@@ -30,23 +34,50 @@ type bucketInterface interface {
 	// you can register the user to the bucket set
 	Register(client conn.Connect) (string, int64, error)
 
-	// you can offline the user in anytime
-	Offline(identification string)
+	// Resume register the user and resume the session from the cursor presented by client , the
+	// messages missed are resent , it is the same as Register when the resumption is turned off
+	Resume(client conn.Connect, cursor resume.Cursor) (string, int64, error)
+
+	// you can offline the user in anytime , all the connections of the user will be closed
+	// with the reason , it returns false when the user is not online
+	Offline(identification, reason string) bool
+
+	// return the id of bucket
+	ID() string
 
 	// send message to users , if empty of users set ,will send message to all users
 	SendMessage(message []byte, users ...string /* if no param , it will use broadcast */)
 
+	// SendPriority is the SendMessage with priority , the message of high priority is put in
+	// the high lane of connection , it is written first and never dropped by the backpressure
+	SendPriority(message []byte, priority conn.Priority, users ...string)
+
 	// return the signal channel , you can use the channel to notify the bucket
 	// uses is offline , and delete the users' identification
-	SignalChannel() chan<- string
+	SignalChannel() chan<- conn.Connect
 
-	// get the number of online user
+	// get the number of online connection
 	Count() int
+
+	// get the connections of user , the second result is false when user is not online
+	Get(identification string) ([]conn.Connect, bool)
+
+	// Deliver send message to the user directly without the bucket channel , if the device
+	// is empty , the message will be sent to all the devices of user . the error is returned
+	// when the user is not online or all the connections refuse the message . It waits for the
+	// room of connection when the policy is conn.BackpressureBlock , except the resumption is
+	// turned on , the message is recorded under the lock of bucket then
+	Deliver(message []byte, identification, device string) error
+
+	// Shutdown stop accepting new user and message , flush the message queue and drain
+	// all the connections , it returns the number of connections drained and force closed
+	Shutdown(ctx context.Context, code int, reason string) (drained, forced int)
 }
 
 type bucketMessage struct {
-	origin *[]byte
-	users  *[]string
+	origin   *[]byte
+	users    *[]string
+	priority conn.Priority
 }
 
 type bucket struct {
@@ -61,46 +92,100 @@ type bucket struct {
 	rw sync.RWMutex
 	// Element Number
 	np atomic.Int64
-	// users set
-	users map[string]conn.Connect
+	// users set , a user may have more than one connection when the session policy is not
+	// squeeze out , the connections are in the order of register
+	users map[string][]conn.Connect
 	// Here is  different point you need pay attention
 	// the close signal received by component that is connection
-	// so we need use channel to inform bucket that user is out of line
-	closeSig chan string
+	// so we need use channel to inform bucket that user is out of line , the connection
+	// itself is sent , so the new connection of the same user will not be deleted
+	closeSig chan conn.Connect
 	ctx      context.Context
-	callback func()
+	cancel   context.CancelFunc
 	opts     *Options
+
+	// callback is called after the user is removed from the bucket , for example , the server
+	// use it to remove the tags of the connection from label manager
+	callback func(cli conn.Connect)
+
+	// offlineCounter count the connections removed from bucket by reason , it can be nil
+	offlineCounter *metrics.CounterVec
+
+	// closing is set when the bucket is shutting down , the register and message will be
+	// refused , stopConsume notify the consumers to flush the bucketChannel and exit
+	closing     atomic.Bool
+	stopConsume chan struct{}
+	consumers   sync.WaitGroup
+	// wg is the counter of goroutines of the bucket
+	wg sync.WaitGroup
+
+	// store keep the message of offline user , when the user register again , the message
+	// will be replayed in order . During replaying , the new message of the user is saved
+	// to store too , so the message replayed will arrive before the live message , replaying
+	// count the messages saved during replaying , so the replay knows the store is drained
+	store     store.MessageStore
+	replaying map[string]*atomic.Uint64
+
+	// sessions keep the outbound sequence and recent messages of users , it is nil when the
+	// resumption is turned off . The connection in resuming receive the messages from session
+	// only , so the messages resent will arrive before the live message
+	sessions *resume.Manager
+	resuming map[conn.Connect]bool
+
+	// wheel evict the connections whose heartbeat is timeout , it is shared by all the buckets
+	// of server and set by server . Each connection has a timer in timers , the timer is reset
+	// when the heartbeat refreshed if the connection implements conn.HeartbeatWatcher , otherwise
+	// it is checked and reset by the last heartbeat when it is expired . The timers is nil when
+	// the heartbeat is turned off
+	wheel  *timewheel.Wheel
+	timers map[conn.Connect]*timewheel.Timer
 }
 
+// the time limit of replay a message when the connection is weak
+const replayMessageTimeout = 3 * time.Second
+
+// the interval of sweeping the expired sessions
+const sweepInterval = 10 * time.Second
+
+var errBucketIsClosing = errors.New("sim : the bucket is closing ")
+
 
 func NewBucket(option *Options, id int ,ctx context.Context) *bucket {
 	res := &bucket{
-		id:       "bucket_" + strconv.Itoa(id),
-		rw:       sync.RWMutex{},
-		np:       atomic.Int64{},
-		closeSig: make(chan string),
-		opts:     option,
-		ctx:      ctx,
-	}
-	res.users = make(map[string]conn.Connect, res.opts.BucketSize)
-	if option.BucketBuffer <= 0 {
-		go res.monitorDelChannel()
-		go res.keepAlive()
-		return res
-	} else {
+		id:          "bucket_" + strconv.Itoa(id),
+		rw:          sync.RWMutex{},
+		np:          atomic.Int64{},
+		closeSig:    make(chan conn.Connect),
+		opts:        option,
+		stopConsume: make(chan struct{}),
+		store:       option.Store,
+		replaying:   map[string]*atomic.Uint64{},
+		resuming:    map[conn.Connect]bool{},
+	}
+	if option.Resume != nil {
+		res.sessions = resume.NewManager(option.Resume)
+	}
+	if option.ClientHeartBeatInterval > 0 {
+		res.timers = map[conn.Connect]*timewheel.Timer{}
+	}
+	res.ctx, res.cancel = context.WithCancel(ctx)
+	res.users = make(map[string][]conn.Connect, res.opts.BucketSize)
+	if option.BucketBuffer > 0 {
 		res.bucketChannel = make(chan *bucketMessage, option.BucketBuffer)
 		res.consumer(1 << 2)
-		go res.monitorDelChannel()
-		go res.keepAlive()
-		return res
 	}
+	res.wg.Add(2)
+	go res.monitorDelChannel()
+	go res.keepAlive()
 	return res
 }
 
 // consumer is a goroutine pool to send message parallelly
 func (h *bucket) consumer(counter int) {
 	for i := 0; i < counter; i++ {
+		h.consumers.Add(1)
 		go func() {
+			defer h.consumers.Done()
 			defer func() {
 				if err := recover(); err != nil {
 					logging.Log.Error("consumer", zap.Any("PANIC", err))
@@ -109,12 +194,15 @@ func (h *bucket) consumer(counter int) {
 			for {
 				select {
 				case message := <-h.bucketChannel:
-					BoardCast := len(*message.users)-1 >= 0
-					if !BoardCast {
-						h.broadCast(*message.origin, false)
-					} else {
-						for _, user := range *message.users {
-							h.send(*message.origin, user, false)
+					h.consume(message)
+				case <-h.stopConsume:
+					// flush the message left in the bucketChannel
+					for {
+						select {
+						case message := <-h.bucketChannel:
+							h.consume(message)
+						default:
+							return
 						}
 					}
 				case <-h.ctx.Done():
@@ -125,159 +213,627 @@ func (h *bucket) consumer(counter int) {
 	}
 }
 
-func (h *bucket) Offline(identification string) {
+func (h *bucket) consume(message *bucketMessage) {
+	BoardCast := len(*message.users)-1 >= 0
+	if !BoardCast {
+		h.broadCast(*message.origin, message.priority)
+	} else {
+		for _, user := range *message.users {
+			h.send(*message.origin, user, message.priority)
+		}
+	}
+}
+
+func (h *bucket) Offline(identification, reason string) bool {
 	h.rw.Lock()
-	cli, ok := h.users[identification]
-	delete(h.users, identification)
-	h.rw.Unlock()
+	clients, ok := h.users[identification]
 	if ok {
-		h.opts.Offline(cli, OfflineBySqueezeOut)
-		time.Sleep(50 * time.Millisecond)
-		cli.Close("Use the Bucket API : Offline ")
+		delete(h.users, identification)
+		h.np.Add(-int64(len(clients)))
+		for _, cli := range clients {
+			h.detach(cli)
+			h.unwatch(cli)
+		}
+	}
+	h.rw.Unlock()
+	if reason == "" {
+		reason = "Use the Bucket API : Offline "
+	}
+	h.offline(clients, OfflineByLogic, reason)
+	return ok
+}
+
+func (h *bucket) ID() string {
+	return h.id
+}
+
+// offline notify the hook and close the connections which are removed from users
+func (h *bucket) offline(clients []conn.Connect, ty int, reason string) {
+	if len(clients) == 0 {
+		return
+	}
+	h.offlineCounter.With(offlineReason(ty)).Add(float64(len(clients)))
+	for _, cli := range clients {
+		if h.callback != nil {
+			h.callback(cli)
+		}
+		if h.opts.Offline != nil {
+			h.opts.Offline(cli, ty)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, cli := range clients {
+		cli.Close(reason)
 	}
 }
 
 func (h *bucket) Register(cli conn.Connect) (string, int64, error) {
+	return h.Resume(cli, resume.Cursor{})
+}
+
+func (h *bucket) Resume(cli conn.Connect, cursor resume.Cursor) (string, int64, error) {
 	if cli == nil {
 		return "", 0, errors.New("sim : the obj of cli is nil ")
 	}
+	if h.closing.Load() {
+		return "", 0, errBucketIsClosing
+	}
+	identification := cli.Identification()
 	h.rw.Lock()
-	defer h.rw.Unlock()
-	h.users[cli.Identification()] = cli
-	h.np.Add(1)
-	return h.id, h.np.Load(), nil
+	kept, squeezed := h.opts.SessionPolicy.apply(h.users[identification], cli, h.opts.MaxSessionConnections)
+	h.users[identification] = append(kept, cli)
+	h.np.Add(1 - int64(len(squeezed)))
+	h.watch(cli)
+	for _, c := range squeezed {
+		h.unwatch(c)
+	}
+	if h.sessions != nil {
+		// attach the new connection first , so the session is not expired by the squeezed
+		h.attach(cli, cursor)
+		for _, c := range squeezed {
+			h.detach(c)
+		}
+	} else if _, replaying := h.replaying[identification]; h.store != nil && !replaying {
+		h.replaying[identification] = atomic.NewUint64(0)
+		h.wg.Add(1)
+		go h.replay(identification)
+	}
+	online := h.np.Load()
+	h.rw.Unlock()
+	h.offline(squeezed, OfflineBySqueezeOut, "squeezed out by the new connection ")
+	return h.id, online, nil
 }
 
 func (h *bucket) SendMessage(message []byte, users ...string /* if no param , it will use broadcast */) {
+	h.SendPriority(message, conn.PriorityNormal, users...)
+}
+
+func (h *bucket) SendPriority(message []byte, priority conn.Priority, users ...string) {
+	if h.closing.Load() {
+		return
+	}
 	if h.bucketChannel != nil {
-		if len(users)-1 >= 0 {
-			h.bucketChannel <- &bucketMessage{
-				origin: &message,
-				users:  &users,
-			}
-		} else {
-			h.bucketChannel <- &bucketMessage{
-				origin: &message,
-				users:  &users,
-			}
+		select {
+		case h.bucketChannel <- &bucketMessage{
+			origin:   &message,
+			users:    &users,
+			priority: priority,
+		}:
+		case <-h.ctx.Done():
 		}
 		return
 	}
 	if len(users)-1 >= 0 {
 		for _, user := range users {
-			h.send(message, user, false)
+			h.send(message, user, priority)
 		}
 		return
 	}
-	h.broadCast(message, false)
+	h.broadCast(message, priority)
 }
 
-func (h *bucket) SignalChannel() chan<- string {
+func (h *bucket) SignalChannel() chan<- conn.Connect {
 	return h.closeSig
 }
 
 func (h *bucket) Count() int {
+	return int(h.np.Load())
+}
+
+func (h *bucket) Get(identification string) ([]conn.Connect, bool) {
 	h.rw.RLock()
 	defer h.rw.RUnlock()
-	return len(h.users)
+	clients, ok := h.users[identification]
+	return append([]conn.Connect(nil), clients...), ok
 }
 
-// this function need a lot of  logs
-func (h *bucket) send(data []byte, token string, Ack bool) {
+func (h *bucket) Shutdown(ctx context.Context, code int, reason string) (drained, forced int) {
+	if !h.closing.CAS(false, true) {
+		return
+	}
+	// flush the message queue first , the message in queue should arrive the client before
+	// the close frame
+	close(h.stopConsume)
+	if !wait(ctx, &h.consumers) {
+		logging.Log.Warn("bucket Shutdown", zap.String("BUCKET_ID", h.id), zap.Error(ctx.Err()))
+	}
+
 	h.rw.RLock()
-	cli, ok := h.users[token]
+	clients := make([]conn.Connect, 0, h.np.Load())
+	for _, session := range h.users {
+		clients = append(clients, session...)
+	}
 	h.rw.RUnlock()
-	if !ok { // user is not online
+
+	var (
+		counter = atomic.Int64{}
+		group   sync.WaitGroup
+	)
+	for _, cli := range clients {
+		group.Add(1)
+		go func(cli conn.Connect) {
+			defer group.Done()
+			if err := cli.Shutdown(ctx, code, reason); err != nil {
+				counter.Inc()
+			}
+		}(cli)
+	}
+	group.Wait()
+	forced = int(counter.Load())
+	drained = len(clients) - forced
+
+	// the signal channel is not received after cancel , so remove the connections here , the
+	// connection removed by monitorDelChannel already is skipped
+	for _, cli := range clients {
+		h.delUser(cli)
+	}
+	// all the connection is closed , the goroutines of bucket can exit now
+	h.cancel()
+	if !wait(ctx, &h.wg) {
+		logging.Log.Warn("bucket Shutdown", zap.String("BUCKET_ID", h.id), zap.Error(ctx.Err()))
+	}
+	logging.Log.Info("bucket Shutdown", zap.String("BUCKET_ID", h.id), zap.Int("DRAINED", drained), zap.Int("FORCED", forced))
+	return
+}
+
+// wait the wait group done or the ctx is done , it returns false when ctx is done first
+func wait(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (h *bucket) Deliver(message []byte, identification, device string) error {
+	if h.sessions != nil && device == "" {
+		return h.deliverSession(message, identification)
+	}
+	clients, ok := h.Get(identification)
+	if !ok {
+		return errUserIsNotOnline
+	}
+	var (
+		err     error
+		success bool
+	)
+	for _, cli := range clients {
+		if device != "" && cli.Device() != device {
+			continue
+		}
+		if err = conn.SendWithBlock(cli, message); err == nil {
+			success = true
+		}
+	}
+	if success {
+		return nil
+	}
+	if err == nil {
+		// there is no connection of the device
+		return errUserIsNotOnline
+	}
+	return err
+}
+
+// this function need a lot of  logs
+func (h *bucket) send(data []byte, token string, priority conn.Priority) {
+	if h.sessions != nil {
+		h.sendSession(data, token, priority)
 		return
-	} else {
-		err := cli.Send(data)
-		logging.Log.Error("bucket send", zap.String("ID",cli.Identification()),zap.Error(err))
+	}
+	h.rw.RLock()
+	clients, ok := h.users[token]
+	saved, replaying := h.replaying[token]
+	if !ok || replaying {
+		// user is not online or the offline message is replaying , keep the message in store ,
+		// the store is written under the read lock , so the replay can't finish at the same time
+		if h.store != nil {
+			if err := h.store.Save(token, data); err != nil {
+				logging.Log.Error("bucket send", zap.String("ID", token), zap.Error(err))
+			} else if replaying {
+				saved.Inc()
+			}
+		}
+		h.rw.RUnlock()
+		return
+	}
+	clients = append([]conn.Connect(nil), clients...)
+	h.rw.RUnlock()
+	for _, cli := range clients {
+		// the message dropped by backpressure is reported by the hook of backpressure
+		if err := conn.SendWithPriority(cli, data, priority); err != nil && !errors.Is(err, conn.ErrConnectionIsClosed) {
+			logging.Log.Warn("bucket send", zap.String("ID", cli.Identification()), zap.Error(err))
+		}
 	}
 	return
 }
 
-func (h *bucket) broadCast(data []byte, Ack bool) {
+func (h *bucket) broadCast(data []byte, priority conn.Priority) {
 	h.rw.RLock()
-	for _, cli := range h.users {
-		err := cli.Send(data)
-		if err != nil {
-			if !errors.Is(err,conn.ErrConnectionIsClosed) {
-				// if err == errConnectionIsClosed  ,there is no need to record
-				logging.Log.Error("bucket broadCast", zap.String("ID",cli.Identification()),zap.Error(err))
+	if h.sessions != nil {
+		for identification, session := range h.users {
+			h.sessions.Record(identification, data, h.sendFrame(session, priority, "bucket broadCast"))
+		}
+		h.rw.RUnlock()
+		return
+	}
+	for _, session := range h.users {
+		for _, cli := range session {
+			err := conn.SendWithPriority(cli, data, priority)
+			if err != nil {
+				if !errors.Is(err,conn.ErrConnectionIsClosed) {
+					// if err == errConnectionIsClosed  ,there is no need to record
+					logging.Log.Error("bucket broadCast", zap.String("ID",cli.Identification()),zap.Error(err))
+				}
+				continue
 			}
-			continue
 		}
 	}
 	h.rw.RUnlock()
 }
 
-func (h *bucket) delUser(identification string) {
+// delUser remove the connection from the session of user , the connection which is not in
+// the session will be ignored , for example the connection squeezed out
+func (h *bucket) delUser(cli conn.Connect) {
+	identification := cli.Identification()
 	h.rw.Lock()
-	defer h.rw.Unlock()
-	_, ok := h.users[identification]
-	if !ok {
+	session := h.users[identification]
+	idx := -1
+	for i, c := range session {
+		if c == cli {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		h.rw.Unlock()
 		return
 	}
-	delete(h.users, identification)
+	session = append(session[:idx:idx], session[idx+1:]...)
+	if len(session) == 0 {
+		delete(h.users, identification)
+	} else {
+		h.users[identification] = session
+	}
 	//更新在线用户数量
 	h.np.Add(-1)
+	h.detach(cli)
+	h.unwatch(cli)
+	h.rw.Unlock()
+	h.offlineCounter.With(offlineByClosed).Inc()
 	if h.callback != nil {
-		h.callback()
+		h.callback(cli)
 	}
 }
 
-// To monitor the whole bucket
-// run in a goroutine
-func (h *bucket) monitorDelChannel() {
+// attach attach the connection to the session of user , the messages kept in store are moved to
+// the session , then the messages missed are resent in a goroutine , it must be called with lock
+func (h *bucket) attach(cli conn.Connect, cursor resume.Cursor) {
+	identification := cli.Identification()
+	control, from := h.sessions.Attach(identification, cursor)
+	if h.store != nil {
+		messages, err := h.store.Pop(identification)
+		if err != nil {
+			logging.Log.Error("bucket attach", zap.String("ID", identification), zap.Error(err))
+		}
+		for _, message := range messages {
+			h.sessions.Record(identification, message, nil)
+		}
+	}
+	// the control frame is the first frame of connection , the buffer of new connection is empty
+	if err := cli.Send(control); err != nil {
+		logging.Log.Warn("bucket attach", zap.String("ID", identification), zap.Error(err))
+	}
+	h.resuming[cli] = true
+	h.wg.Add(1)
+	go h.resume(cli, from)
+}
+
+// detach the connection from the session of user , it must be called with lock
+func (h *bucket) detach(cli conn.Connect) {
+	if h.sessions == nil {
+		return
+	}
+	delete(h.resuming, cli)
+	h.sessions.Detach(cli.Identification())
+}
+
+// resume send the messages after last to the connection , the resumption is finished when
+// there is no message left under the write lock , then the live message can be sent to it
+func (h *bucket) resume(cli conn.Connect, last uint64) {
+	defer h.wg.Done()
+	identification := cli.Identification()
+	for counter := 0; ; {
+		h.rw.Lock()
+		frames, seq, ok := h.sessions.Since(identification, last)
+		if !ok || len(frames) == 0 {
+			delete(h.resuming, cli)
+			h.rw.Unlock()
+			if counter != 0 {
+				logging.Log.Info("bucket resume", zap.String("ID", identification), zap.Int("COUNT", counter))
+			}
+			return
+		}
+		h.rw.Unlock()
+		for _, frame := range frames {
+			if err := h.replayMessage([]conn.Connect{cli}, frame); err != nil {
+				// the connection is closed or too weak , the client can resume again
+				h.rw.Lock()
+				delete(h.resuming, cli)
+				h.rw.Unlock()
+				logging.Log.Warn("bucket resume", zap.String("ID", identification), zap.Uint64("LAST", last), zap.Error(err))
+				return
+			}
+			counter++
+		}
+		last = seq
+	}
+}
+
+// sendSession record the message in the session of user and send it to the connections , if the
+// user has no session , the message is kept in store
+func (h *bucket) sendSession(data []byte, token string, priority conn.Priority) {
+	h.rw.RLock()
+	defer h.rw.RUnlock()
+	if !h.sessions.Record(token, data, h.sendFrame(h.users[token], priority, "bucket send")) && h.store != nil {
+		if err := h.store.Save(token, data); err != nil {
+			logging.Log.Error("bucket send", zap.String("ID", token), zap.Error(err))
+		}
+	}
+}
+
+// deliverSession is the Deliver when the resumption is turned on , the connection in resuming
+// is treated as success , because the message will be resent to it by resume
+func (h *bucket) deliverSession(data []byte, identification string) error {
+	h.rw.RLock()
+	defer h.rw.RUnlock()
+	clients, ok := h.users[identification]
+	if !ok {
+		return errUserIsNotOnline
+	}
+	var (
+		err     error
+		success bool
+	)
+	h.sessions.Record(identification, data, func(frame []byte) {
+		for _, cli := range clients {
+			if h.resuming[cli] {
+				success = true
+				continue
+			}
+			if err = cli.Send(frame); err == nil {
+				success = true
+			}
+		}
+	})
+	if success {
+		return nil
+	}
+	return err
+}
+
+// sendFrame return the function send the frame to the connections not in resuming , it must be
+// called with lock
+func (h *bucket) sendFrame(clients []conn.Connect, priority conn.Priority, caller string) func(frame []byte) {
+	return func(frame []byte) {
+		for _, cli := range clients {
+			if h.resuming[cli] {
+				continue
+			}
+			if err := conn.SendWithPriority(cli, frame, priority); err != nil && !errors.Is(err, conn.ErrConnectionIsClosed) {
+				logging.Log.Warn(caller, zap.String("ID", cli.Identification()), zap.Error(err))
+			}
+		}
+	}
+}
+
+// replay send the message kept in store to the connections of user , the message delivered is
+// acked , so the messages left are still at the head of store . The store is read without the
+// lock of bucket , the replay is finished when the store is empty and no message is saved
+// after that under the write lock , then the live message can be sent to the user
+func (h *bucket) replay(identification string) {
+	defer h.wg.Done()
+	counter := 0
 	defer func() {
-		if err := recover(); err != nil {
-			logging.Log.Fatal("monitorDelChannel", zap.Any("PANIC", err))
+		h.rw.Lock()
+		delete(h.replaying, identification)
+		h.rw.Unlock()
+		if counter != 0 {
+			logging.Log.Info("bucket replay", zap.String("ID", identification), zap.Int("COUNT", counter))
 		}
 	}()
-	if h.ctx != nil {
+	for {
+		h.rw.RLock()
+		saved := h.replaying[identification].Load()
+		clients := append([]conn.Connect(nil), h.users[identification]...)
+		h.rw.RUnlock()
+		// the messages saved before the load of saved are returned by Peek
+		messages, err := h.store.Peek(identification)
+		if err != nil {
+			logging.Log.Error("bucket replay", zap.String("ID", identification), zap.Error(err))
+			return
+		}
+		if len(messages) == 0 {
+			h.rw.Lock()
+			if h.replaying[identification].Load() == saved {
+				delete(h.replaying, identification)
+				h.rw.Unlock()
+				return
+			}
+			h.rw.Unlock()
+			continue
+		}
+		for i, message := range messages {
+			if err := h.replayMessage(clients, message.Data); err != nil {
+				// the connections are closed or too weak , keep the messages left for next time
+				logging.Log.Warn("bucket replay", zap.String("ID", identification), zap.Int("LEFT", len(messages)-i), zap.Error(err))
+				if i > 0 {
+					h.ack(identification, messages[i-1].Offset)
+				}
+				return
+			}
+			counter++
+		}
+		if !h.ack(identification, messages[len(messages)-1].Offset) {
+			// the messages will be replayed again , stop here to avoid sending them repeatedly
+			return
+		}
+	}
+}
+
+// ack remove the messages replayed from store , it returns false when failed
+func (h *bucket) ack(identification string, offset uint64) bool {
+	if err := h.store.Ack(identification, offset); err != nil {
+		logging.Log.Error("bucket replay", zap.String("ID", identification), zap.Uint64("OFFSET", offset), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// replayMessage send the message to all the connections , it waits the buffer of connection
+// available when the connection is weak , the error is returned when no connection received
+func (h *bucket) replayMessage(clients []conn.Connect, message []byte) error {
+	var (
+		err      error
+		success  bool
+		deadline = time.Now().Add(replayMessageTimeout)
+	)
+	for _, cli := range clients {
 		for {
+			err = cli.Send(message)
+			if err == nil {
+				success = true
+			}
+			if err == nil || !errors.Is(err, conn.ErrConnectionIsWeak) || time.Now().After(deadline) {
+				break
+			}
 			select {
-			case token := <-h.closeSig:
-				h.delUser(token)
+			case <-time.After(10 * time.Millisecond):
 			case <-h.ctx.Done():
-				return
+				return h.ctx.Err()
 			}
 		}
 	}
+	if success {
+		return nil
+	}
+	if err == nil {
+		err = errUserIsNotOnline
+	}
+	return err
+}
+
+// To monitor the whole bucket
+// run in a goroutine
+func (h *bucket) monitorDelChannel() {
+	defer h.wg.Done()
+	defer func() {
+		if err := recover(); err != nil {
+			logging.Log.Fatal("monitorDelChannel", zap.Any("PANIC", err))
+		}
+	}()
 	for {
 		select {
-		case token := <-h.closeSig:
-			h.delUser(token)
+		case cli := <-h.closeSig:
+			h.delUser(cli)
+		case <-h.ctx.Done():
+			return
 		}
 	}
 }
 
-// To keepAlive the whole bucket
+// To keepAlive the whole bucket , the expired sessions are swept , the connections whose heartbeat
+// is timeout are evicted by the wheel , so the users is not scanned
 // run in a goroutine
 func (h *bucket) keepAlive() {
+	defer h.wg.Done()
 	defer func() {
 		if err := recover(); err != nil {
 			logging.Log.Error("keepAlive", zap.Any("PANIC", err))
 		}
 	}()
-	if h.opts.ClientHeartBeatInterval == 0 {
+	if h.sessions == nil {
 		return
 	}
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
 	for {
-		var cancelCli []conn.Connect
-		now := time.Now().Unix()
-		h.rw.Lock()
-		for _, cli := range h.users {
-			inter := now - cli.GetLastHeartBeatTime()
-			if inter < 2*int64(h.opts.ClientHeartBeatInterval) {
-				continue
-			}
-			cancelCli = append(cancelCli, cli)
+		select {
+		case <-ticker.C:
+			h.sessions.Sweep()
+		case <-h.ctx.Done():
+			return
 		}
-		h.rw.Unlock()
-		for _, cancel := range cancelCli {
-			cancel.Close("heartbeat is not arrive ")
+	}
+}
+
+// heartbeatTimeout is the max time between two heartbeats of connection
+func (h *bucket) heartbeatTimeout() time.Duration {
+	return 2 * time.Duration(h.opts.ClientHeartBeatInterval) * time.Second
+}
+
+// watch add the timer of connection to wheel , the timer is reset when the heartbeat refreshed ,
+// it must be called with lock
+func (h *bucket) watch(cli conn.Connect) {
+	if h.wheel == nil || h.timers == nil {
+		return
+	}
+	timeout := h.heartbeatTimeout()
+	timer := h.wheel.AfterFunc(timeout, h.expire(cli))
+	if watcher, ok := cli.(conn.HeartbeatWatcher); ok {
+		watcher.WatchHeartbeat(func() { timer.Reset(timeout) })
+	}
+	h.timers[cli] = timer
+}
+
+// unwatch stop the timer of connection , it must be called with lock
+func (h *bucket) unwatch(cli conn.Connect) {
+	if h.timers == nil {
+		return
+	}
+	h.timers[cli].Stop()
+	delete(h.timers, cli)
+}
+
+// expire return the function called when the timer of connection expired , the connection is
+// closed if there is no heartbeat during the timeout , otherwise the timer is reset by the last
+// heartbeat , it happens when the connection don't implement conn.HeartbeatWatcher
+func (h *bucket) expire(cli conn.Connect) func() {
+	return func() {
+		idle := time.Since(time.Unix(cli.GetLastHeartBeatTime(), 0))
+		if timeout := h.heartbeatTimeout(); idle < timeout {
+			h.rw.RLock()
+			// the connection may be removed before the lock , and the timer stopped can't be reset
+			h.timers[cli].Reset(timeout - idle)
+			h.rw.RUnlock()
+			return
 		}
-		time.Sleep(10 * time.Second)
+		cli.Close("heartbeat is not arrive ")
 	}
 }
@@ -1,46 +1,506 @@
 package sim
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/label"
+	"github.com/mongofs/sim/pkg/resume"
+	"github.com/mongofs/sim/pkg/store"
+	"github.com/mongofs/sim/pkg/timewheel"
+	"go.uber.org/atomic"
 )
 
 type MockConn struct {
-	id string
+	id        string
+	device    string
 	heartTime int64
+	// slow connection can't be drained until the ctx is done
+	slow bool
+
+	mu       sync.Mutex
+	received [][]byte
+	closed   string
+	tags     map[string]label.ForClient
+
+	conn.AttributeSet
 }
 
-func (m MockConn) Identification() string {
+func (m *MockConn) Identification() string {
 	return m.id
 }
 
-func (m MockConn) Send(data []byte) error {
-	fmt.Printf("%v received message : %v\n",m.id ,string(data))
+func (m *MockConn) Device() string {
+	return m.device
+}
+
+func (m *MockConn) Send(data []byte) error {
+	fmt.Printf("%v received message : %v\n", m.id, string(data))
+	m.mu.Lock()
+	m.received = append(m.received, data)
+	m.mu.Unlock()
 	return nil
 }
 
-func (m MockConn) Close() {
-	fmt.Printf("%v Close the connection \n",m.id )
+// messages return the messages received by the connection
+func (m *MockConn) messages() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.received...)
+}
+
+func (m *MockConn) Close(reason string) {
+	fmt.Printf("%v Close the connection , reason : %v \n", m.id, reason)
+	m.mu.Lock()
+	m.closed = reason
+	m.mu.Unlock()
 	return
 }
 
-func (m MockConn) SetMessageType(messageType conn.MessageType) {
+// closedReason return the reason of Close , it is empty when the connection is not closed
+func (m *MockConn) closedReason() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closed
+}
+
+func (m *MockConn) SetMessageType(messageType conn.MessageType) {
 	return
 }
 
-func (m MockConn) ReFlushHeartBeatTime() {
+func (m *MockConn) ReFlushHeartBeatTime() {
 	m.heartTime = time.Now().Unix()
 }
 
-func (m MockConn) GetLastHeartBeatTime() int64 {
+func (m *MockConn) GetLastHeartBeatTime() int64 {
 	return m.heartTime
 }
 
+func (m *MockConn) Shutdown(ctx context.Context, code int, reason string) error {
+	if m.slow {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (m *MockConn) HaveTags(tags []string) bool {
+	for _, tag := range tags {
+		if _, ok := m.tags[tag]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MockConn) SetTag(tag string, fc label.ForClient) {
+	if m.tags == nil {
+		m.tags = map[string]label.ForClient{}
+	}
+	m.tags[tag] = fc
+}
+
+func (m *MockConn) DelTag(tag string) (label.ForClient, bool) {
+	fc, ok := m.tags[tag]
+	delete(m.tags, tag)
+	return fc, ok
+}
+
+func (m *MockConn) Tags() []string {
+	var res []string
+	for tag := range m.tags {
+		res = append(res, tag)
+	}
+	return res
+}
 
 // send message to a person
 func TestBucket_SendMessage(t *testing.T) {
 
 }
+
+// priorityConn record the priority of messages
+type priorityConn struct {
+	*MockConn
+	priorities []conn.Priority
+}
+
+func (p *priorityConn) SendPriority(data []byte, priority conn.Priority) error {
+	p.mu.Lock()
+	p.priorities = append(p.priorities, priority)
+	p.mu.Unlock()
+	return p.Send(data)
+}
+
+func TestBucket_SendPriority(t *testing.T) {
+	opt := DefaultOption()
+	opt.BucketBuffer = 0
+	bt := NewBucket(opt, 0, context.Background())
+	cli := &priorityConn{MockConn: &MockConn{id: "steven"}}
+	if _, _, err := bt.Register(cli); err != nil {
+		t.Fatal(err)
+	}
+	bt.SendMessage([]byte("normal"), "steven")
+	bt.SendPriority([]byte("kick"), conn.PriorityHigh, "steven")
+	bt.SendPriority([]byte("notice"), conn.PriorityHigh)
+	want := []conn.Priority{conn.PriorityNormal, conn.PriorityHigh, conn.PriorityHigh}
+	if fmt.Sprint(cli.priorities) != fmt.Sprint(want) {
+		t.Fatalf("priorities = %v , want %v", cli.priorities, want)
+	}
+}
+
+// watchedConn report the refresh of heartbeat , checked counts the heartbeat checked by expire
+type watchedConn struct {
+	*MockConn
+	conn.Heartbeat
+	checked atomic.Int32
+}
+
+func (w *watchedConn) ReFlushHeartBeatTime() {
+	w.Heartbeat.ReFlushHeartBeatTime()
+}
+
+func (w *watchedConn) GetLastHeartBeatTime() int64 {
+	w.checked.Inc()
+	return w.Heartbeat.GetLastHeartBeatTime()
+}
+
+func TestBucket_KeepAlive(t *testing.T) {
+	opt := DefaultOption()
+	opt.ClientHeartBeatInterval = 1
+	bt := NewBucket(opt, 0, context.Background())
+	// advance the wheel by the test only
+	bt.wheel = timewheel.New(time.Second, 4)
+	dead, alive := &MockConn{id: "steven"}, &MockConn{id: "mike"}
+	watched := &watchedConn{MockConn: &MockConn{id: "mikal"}}
+	for _, cli := range []conn.Connect{dead, alive, watched} {
+		if _, _, err := bt.Register(cli); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the timeout is 2 seconds , the alive connection is rearmed by its heartbeat when expired ,
+	// and the timer of watched connection is rescheduled by the heartbeat
+	bt.wheel.Advance()
+	alive.ReFlushHeartBeatTime()
+	watched.ReFlushHeartBeatTime()
+	bt.wheel.Advance()
+	if dead.closedReason() == "" || alive.closedReason() != "" {
+		t.Fatalf("closed dead = %q , alive = %q", dead.closedReason(), alive.closedReason())
+	}
+	if watched.checked.Load() != 0 {
+		t.Fatal("the timer of watched connection should be rescheduled by the heartbeat")
+	}
+	bt.wheel.Advance()
+	if watched.checked.Load() != 1 || watched.closedReason() != "" {
+		t.Fatalf("checked = %v , closed = %q", watched.checked.Load(), watched.closedReason())
+	}
+	for _, cli := range []conn.Connect{dead, alive, watched} {
+		bt.delUser(cli)
+	}
+	if bt.wheel.Len() != 0 || len(bt.timers) != 0 {
+		t.Fatalf("the timers should be stopped , got %v", bt.wheel.Len())
+	}
+}
+
+func TestBucket_Shutdown(t *testing.T) {
+	bt := NewBucket(DefaultOption(), 0, context.Background())
+	for _, cli := range []*MockConn{{id: "steven"}, {id: "mike"}, {id: "mikal", slow: true}} {
+		if _, _, err := bt.Register(cli); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bt.SendMessage([]byte("before shutdown"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	drained, forced := bt.Shutdown(ctx, DefaultShutdownCloseCode, DefaultShutdownCloseReason)
+	if drained != 2 || forced != 1 {
+		t.Fatalf("Shutdown() drained = %v , forced = %v , want 2 and 1", drained, forced)
+	}
+	if _, _, err := bt.Register(&MockConn{id: "after"}); err != errBucketIsClosing {
+		t.Fatalf("Register() error = '%v', wantErr '%v'", err, errBucketIsClosing)
+	}
+}
+
+func TestBucket_Replay(t *testing.T) {
+	opt := DefaultOption()
+	opt.BucketBuffer = 0
+	opt.Store = store.NewMemoryStore(10, time.Minute)
+	bt := NewBucket(opt, 0, context.Background())
+	defer bt.Shutdown(context.Background(), DefaultShutdownCloseCode, DefaultShutdownCloseReason)
+
+	bt.SendMessage([]byte("offline_1"), "steven")
+	bt.SendMessage([]byte("offline_2"), "steven")
+	cli := &MockConn{id: "steven"}
+	if _, _, err := bt.Register(cli); err != nil {
+		t.Fatal(err)
+	}
+	// wait the replay finished
+	for i := 0; i < 100; i++ {
+		bt.rw.RLock()
+		_, replaying := bt.replaying["steven"]
+		bt.rw.RUnlock()
+		if !replaying {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	bt.SendMessage([]byte("online"), "steven")
+	if len(cli.messages()) != 3 || string(cli.messages()[0]) != "offline_1" || string(cli.messages()[2]) != "online" {
+		t.Fatalf("received %q", cli.messages())
+	}
+}
+
+// brokenConn is closed after received limit messages
+type brokenConn struct {
+	*MockConn
+	limit int
+}
+
+func (b *brokenConn) Send(data []byte) error {
+	if len(b.messages()) >= b.limit {
+		return conn.ErrConnectionIsClosed
+	}
+	return b.MockConn.Send(data)
+}
+
+func TestBucket_ReplayFailed(t *testing.T) {
+	opt := DefaultOption()
+	opt.BucketBuffer = 0
+	opt.Store = store.NewMemoryStore(10, time.Minute)
+	bt := NewBucket(opt, 0, context.Background())
+	defer bt.Shutdown(context.Background(), DefaultShutdownCloseCode, DefaultShutdownCloseReason)
+
+	for _, message := range []string{"offline_1", "offline_2", "offline_3"} {
+		bt.SendMessage([]byte(message), "steven")
+	}
+	if _, _, err := bt.Register(&brokenConn{MockConn: &MockConn{id: "steven"}, limit: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		bt.rw.RLock()
+		_, replaying := bt.replaying["steven"]
+		bt.rw.RUnlock()
+		if !replaying {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// the messages not replayed are kept at the head of store in order
+	messages, err := opt.Store.Pop("steven")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || string(messages[0]) != "offline_2" || string(messages[1]) != "offline_3" {
+		t.Fatalf("the messages left in store = %q", messages)
+	}
+}
+
+func TestBucket_SessionPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   SessionPolicy
+		max      int
+		devices  []string
+		want     int // the number of connections online
+		squeezed []int
+	}{
+		{name: "squeeze out", policy: SessionPolicySqueezeOut, devices: []string{"phone", "desktop"}, want: 1, squeezed: []int{0}},
+		{name: "multiple", policy: SessionPolicyMultiple, max: 2, devices: []string{"phone", "desktop", "pad"}, want: 2, squeezed: []int{0}},
+		{name: "device exclusive", policy: SessionPolicyDeviceExclusive, max: 4, devices: []string{"phone", "desktop", "phone"}, want: 2, squeezed: []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := DefaultOption()
+			opt.SessionPolicy, opt.MaxSessionConnections = tt.policy, tt.max
+			var offline []conn.Connect
+			opt.Offline = func(cli conn.Connect, ty int) {
+				if ty == OfflineBySqueezeOut {
+					offline = append(offline, cli)
+				}
+			}
+			bt := NewBucket(opt, 0, context.Background())
+			defer bt.Shutdown(context.Background(), DefaultShutdownCloseCode, DefaultShutdownCloseReason)
+			var clients []*MockConn
+			for _, device := range tt.devices {
+				cli := &MockConn{id: "steven", device: device}
+				clients = append(clients, cli)
+				if _, _, err := bt.Register(cli); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if bt.Count() != tt.want {
+				t.Fatalf("Count() = %v , want %v", bt.Count(), tt.want)
+			}
+			if len(offline) != len(tt.squeezed) || offline[0] != clients[tt.squeezed[0]] {
+				t.Fatalf("squeezed %v , want %v", offline, tt.squeezed)
+			}
+			// the message is sent to all the devices
+			if err := bt.Deliver([]byte("hello"), "steven", ""); err != nil {
+				t.Fatal(err)
+			}
+			if len(clients[len(clients)-1].messages()) != 1 || len(clients[0].messages()) != 0 {
+				t.Fatal("the message should only be sent to online connections")
+			}
+			// the closed connection squeezed out should not delete the new connection
+			bt.delUser(clients[0])
+			if bt.Count() != tt.want {
+				t.Fatalf("Count() after delete squeezed connection = %v , want %v", bt.Count(), tt.want)
+			}
+		})
+	}
+}
+
+func TestBucket_Deliver(t *testing.T) {
+	opt := DefaultOption()
+	opt.SessionPolicy = SessionPolicyDeviceExclusive
+	bt := NewBucket(opt, 0, context.Background())
+	defer bt.Shutdown(context.Background(), DefaultShutdownCloseCode, DefaultShutdownCloseReason)
+	phone, desktop := &MockConn{id: "steven", device: "phone"}, &MockConn{id: "steven", device: "desktop"}
+	bt.Register(phone)
+	bt.Register(desktop)
+	if err := bt.Deliver([]byte("to phone"), "steven", "phone"); err != nil {
+		t.Fatal(err)
+	}
+	if len(phone.messages()) != 1 || len(desktop.messages()) != 0 {
+		t.Fatalf("phone received %v , desktop received %v", len(phone.messages()), len(desktop.messages()))
+	}
+	if err := bt.Deliver([]byte("to pad"), "steven", "pad"); err != errUserIsNotOnline {
+		t.Fatalf("Deliver() error = '%v', wantErr '%v'", err, errUserIsNotOnline)
+	}
+}
+
+// blockConn record the messages sent by SendBlock
+type blockConn struct {
+	*MockConn
+	blocked []string
+}
+
+func (b *blockConn) SendBlock(data []byte) error {
+	b.mu.Lock()
+	b.blocked = append(b.blocked, string(data))
+	b.mu.Unlock()
+	return b.Send(data)
+}
+
+func TestBucket_DeliverBlock(t *testing.T) {
+	opt := DefaultOption()
+	opt.BucketBuffer = 0
+	bt := NewBucket(opt, 0, context.Background())
+	defer bt.Shutdown(context.Background(), DefaultShutdownCloseCode, DefaultShutdownCloseReason)
+	cli := &blockConn{MockConn: &MockConn{id: "steven"}}
+	bt.Register(cli)
+	bt.SendMessage([]byte("send"), "steven")
+	bt.SendMessage([]byte("broadcast"))
+	if err := bt.Deliver([]byte("deliver"), "steven", ""); err != nil {
+		t.Fatal(err)
+	}
+	// only the message delivered to one user waits for the room of connection
+	cli.mu.Lock()
+	defer cli.mu.Unlock()
+	if len(cli.received) != 3 || strings.Join(cli.blocked, ",") != "deliver" {
+		t.Fatalf("received %q , blocked %v", cli.received, cli.blocked)
+	}
+}
+
+func TestBucket_Resume(t *testing.T) {
+	opt := DefaultOption()
+	opt.BucketBuffer = 0
+	opt.Resume = resume.DefaultOption()
+	bt := NewBucket(opt, 0, context.Background())
+	defer bt.Shutdown(context.Background(), DefaultShutdownCloseCode, DefaultShutdownCloseReason)
+	// register the connection and wait the resumption finished
+	register := func(cli *MockConn, cursor resume.Cursor) {
+		if _, _, err := bt.Resume(cli, cursor); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			bt.rw.RLock()
+			resuming := bt.resuming[cli]
+			bt.rw.RUnlock()
+			if !resuming {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal("the resumption is not finished")
+	}
+
+	first := &MockConn{id: "steven"}
+	register(first, resume.Cursor{})
+	bt.SendMessage([]byte("1"), "steven")
+	bt.SendMessage([]byte("2"), "steven")
+	received := first.messages()
+	if len(received) != 3 || string(received[2]) != "#seq:2\n2" {
+		t.Fatalf("received %q", received)
+	}
+	id := strings.Split(string(received[0]), ":")[1]
+
+	// the message sent during the disconnection is resent after reconnect
+	bt.delUser(first)
+	bt.SendMessage([]byte("3"), "steven")
+	second := &MockConn{id: "steven"}
+	register(second, resume.Cursor{ID: id, Last: 2})
+	bt.SendMessage([]byte("4"), "steven")
+	want := []string{"#session:" + id + ":2", "#seq:3\n3", "#seq:4\n4"}
+	if got := second.messages(); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Fatalf("received %q , want %q", got, want)
+	}
+
+	// the unknown session should resync
+	third := &MockConn{id: "steven", device: "pad"}
+	register(third, resume.Cursor{ID: "unknown", Last: 2})
+	if got := third.messages(); len(got) != 1 || string(got[0]) != "#resync:"+id+":4" {
+		t.Fatalf("received %q , want resync", got)
+	}
+}
+
+// BenchmarkBucket_KeepAlive compare the time of bucket lock held by checking the heartbeat , the
+// scan is the way before the wheel , all the users are checked with lock every 10 seconds , the
+// wheel hold no bucket lock unless the timer expired , each op of wheel is a tick with 1% of
+// connections refreshed
+func BenchmarkBucket_KeepAlive(b *testing.B) {
+	const users = 100000
+	opt := DefaultOption()
+	opt.ClientHeartBeatInterval = 30
+	bt := NewBucket(opt, 0, context.Background())
+	bt.wheel = timewheel.New(timewheel.DefaultTick, timewheel.DefaultSlots)
+	conns := make([]*watchedConn, users)
+	for i := range conns {
+		conns[i] = &watchedConn{MockConn: &MockConn{id: fmt.Sprintf("user_%d", i)}}
+		conns[i].ReFlushHeartBeatTime()
+		if _, _, err := bt.Register(conns[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var cancelCli []conn.Connect
+			now := time.Now().Unix()
+			bt.rw.Lock()
+			for _, session := range bt.users {
+				for _, cli := range session {
+					if now-cli.GetLastHeartBeatTime() < 2*int64(bt.opts.ClientHeartBeatInterval) {
+						continue
+					}
+					cancelCli = append(cancelCli, cli)
+				}
+			}
+			bt.rw.Unlock()
+		}
+	})
+	b.Run("wheel", func(b *testing.B) {
+		timeout := bt.heartbeatTimeout()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < users/100; j++ {
+				bt.timers[conns[(i*users/100+j)%users]].Reset(timeout)
+			}
+			bt.wheel.Advance()
+		}
+	})
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mongofs/sim/pkg/cluster"
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// ClusterHandler return the handler to receive the message forwarded by other nodes , you
+// should mount it on the address of cluster.Node , it returns nil when the cluster mode is
+// turned off
+func (s *Server) ClusterHandler() http.Handler {
+	if s.opt.Cluster == nil {
+		return nil
+	}
+	return cluster.Handler(s.opt.Cluster.Secret, func(users []string, message []byte, priority int) error {
+		if s.running.Load() != RunStatusRunning {
+			return errServerIsNotRunning
+		}
+		// the forwarded message only deliver to the users of current node
+		s.sendMessage(message, users, conn.Priority(priority))
+		return nil
+	})
+}
+
+// route send the message to the nodes that users are online on , if users is empty , the
+// message will be broadcast to all the nodes
+func (s *Server) route(message []byte, users []string, priority conn.Priority) error {
+	opt := s.opt.Cluster
+	if len(users) == 0 {
+		s.sendMessage(message, nil, priority)
+		nodes, err := opt.Registry.Nodes()
+		if err != nil {
+			return err
+		}
+		remote := map[string][]string{}
+		for _, node := range nodes {
+			if node.ID != opt.Node.ID {
+				remote[node.ID] = nil
+			}
+		}
+		return s.forward(message, remote, priority)
+	}
+	local, remote, err := cluster.Route(opt.Registry, opt.Node.ID, users)
+	if err != nil {
+		return err
+	}
+	if len(local) != 0 {
+		s.sendMessage(message, local, priority)
+	}
+	return s.forward(message, remote, priority)
+}
+
+// forward send message to other nodes in parallel , the key of remote is the id of node and
+// the value is the users of node , empty users means broadcast
+func (s *Server) forward(message []byte, remote map[string][]string, priority conn.Priority) error {
+	if len(remote) == 0 {
+		return nil
+	}
+	opt := s.opt.Cluster
+	nodes, err := opt.Registry.Nodes()
+	if err != nil {
+		return err
+	}
+	addr := make(map[string]cluster.Node, len(nodes))
+	for _, node := range nodes {
+		addr[node.ID] = node
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, opt.Timeout)
+	defer cancel()
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	for id, users := range remote {
+		node, ok := addr[id]
+		if !ok {
+			failed = append(failed, id+" : "+cluster.ErrNodeIsNotExist.Error())
+			continue
+		}
+		wg.Add(1)
+		go func(node cluster.Node, users []string) {
+			defer wg.Done()
+			if err := opt.Forwarder.Forward(ctx, node, users, message, int(priority)); err != nil {
+				logging.Log.Error("forward", zap.String("NODE", node.ID), zap.Error(err))
+				mu.Lock()
+				failed = append(failed, node.ID+" : "+err.Error())
+				mu.Unlock()
+			}
+		}(node, users)
+	}
+	wg.Wait()
+	if len(failed) != 0 {
+		return fmt.Errorf("sim : forward message failed , %v", strings.Join(failed, " ; "))
+	}
+	return nil
+}
+
+// presence report the connection of user is online or offline on current node
+func (s *Server) presence(identification string, online bool) {
+	opt := s.opt.Cluster
+	if opt == nil {
+		return
+	}
+	var err error
+	if online {
+		err = opt.Registry.Online(identification, opt.Node.ID)
+	} else {
+		err = opt.Registry.Offline(identification, opt.Node.ID)
+	}
+	if err != nil {
+		logging.Log.Error("presence", zap.String("ID", identification), zap.Bool("ONLINE", online), zap.Error(err))
+	}
+}
+
+// removed is the callback of bucket , it is called after the connection is removed from bucket
+func (s *Server) removed(cli conn.Connect) {
+	s.cleanTags(cli)
+	s.presence(cli.Identification(), false)
+	// the calls waiting for the response of connection will never be resolved
+	s.calls.Cancel(cli)
+	s.inflight.Cancel(cli)
+	s.detachLimit(cli)
+	s.detachPrincipal(cli)
+}
+
+func (s *Server) joinCluster() {
+	if opt := s.opt.Cluster; opt != nil {
+		if err := opt.Registry.Join(opt.Node); err != nil {
+			logging.Log.Error("joinCluster", zap.String("NODE", opt.Node.ID), zap.Error(err))
+		}
+	}
+}
+
+func (s *Server) leaveCluster() {
+	if opt := s.opt.Cluster; opt != nil {
+		if err := opt.Registry.Leave(opt.Node.ID); err != nil {
+			logging.Log.Error("leaveCluster", zap.String("NODE", opt.Node.ID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package sim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/cluster"
+)
+
+func TestServer_Cluster(t *testing.T) {
+	registry := cluster.NewMemoryRegistry()
+	var (
+		servers []*Server
+		users   = map[string]*MockConn{}
+	)
+	for _, id := range []string{"node_1", "node_2"} {
+		// the address of node is not known until the http server started
+		handler := &lazyHandler{}
+		ts := httptest.NewServer(handler)
+		defer ts.Close()
+		s, err := NewServer(&hook{}, WithServerBucketNumber(2), WithCluster(&cluster.Option{
+			Node:     cluster.Node{ID: id, Addr: ts.URL},
+			Registry: registry,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler.h = s.ClusterHandler()
+		if err := s.Run(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	// steven is online on node_2 , mike is online on node_1
+	for i, id := range []string{"mike", "steven"} {
+		cli := &MockConn{id: id}
+		users[id] = cli
+		s := servers[i]
+		s.presence(id, true)
+		if _, _, err := s.bucket(id).Register(cli); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := servers[0].SendMessage([]byte("to steven"), []string{"steven"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := servers[1].SendMessage([]byte("to all"), nil); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := len(users["steven"].messages()); got != 2 {
+		t.Fatalf("steven should receive 2 messages , got %v", got)
+	}
+	if got := len(users["mike"].messages()); got != 1 {
+		t.Fatalf("mike should receive 1 message , got %v", got)
+	}
+
+	// the presence is removed when the user offline
+	servers[1].Kick("steven", "test")
+	if nodes, _ := registry.Lookup("steven"); len(nodes) != 0 {
+		t.Fatalf("steven should be offline in registry , got %v", nodes)
+	}
+}
+
+type lazyHandler struct {
+	h http.Handler
+}
+
+func (l *lazyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.h.ServeHTTP(w, r)
+}
@@ -0,0 +1,52 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// slowHook echo the message after the release
+type slowHook struct {
+	echoHook
+	release chan struct{}
+}
+
+func (h *slowHook) HandleReceive(cli conn.Connect, data []byte) {
+	<-h.release
+	cli.Send(data)
+}
+
+func TestServer_Dispatch(t *testing.T) {
+	h := &slowHook{release: make(chan struct{})}
+	s, err := NewServer(h, WithDispatch(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	cli := &MockConn{id: "steven"}
+	received := make(chan struct{})
+	go func() {
+		s.handleReceive(cli, []byte("1"))
+		s.handleReceive(cli, []byte("2"))
+		close(received)
+	}()
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("the receive should not be blocked by the handler")
+	}
+	close(h.release)
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cli.messages(); len(got) != 2 || string(got[0]) != "1" || string(got[1]) != "2" {
+		t.Fatalf("received %q , want the messages in order", got)
+	}
+	if s.pool.Pending() != 0 {
+		t.Fatalf("Pending() = %v , want 0", s.pool.Pending())
+	}
+}
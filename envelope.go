@@ -0,0 +1,125 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"errors"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/envelope"
+	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/rpc"
+	"go.uber.org/zap"
+)
+
+// EnvelopeHooker is optional , if the Hooker implement it , the application message will be
+// handed to HandleEnvelope instead of HandleReceive when the envelope protocol is turned on , so
+// that the hooker can get the id and timestamp of message
+type EnvelopeHooker interface {
+	HandleEnvelope(cli conn.Connect, e *envelope.Envelope)
+}
+
+// SubscribeHooker decide the connection can subscribe the label or not , the subscribe and
+// unsubscribe message of client will be refused when the Hooker not implement it
+type SubscribeHooker interface {
+	SubscribeHook(cli conn.Connect, tag string, subscribe bool) error
+}
+
+var (
+	errSubscribeNotSupported = errors.New("the subscription is not supported ")
+	errTagIsEmpty            = errors.New("the tag is empty ")
+)
+
+// handleEnvelope handle the control message , and hand the application message to hooker
+func (s *Server) handleEnvelope(cli conn.Connect, data []byte) {
+	e, err := s.opt.Envelope.Decode(data)
+	if err != nil {
+		s.reply(cli, envelope.Error("", err))
+		return
+	}
+	switch e.Type {
+	case envelope.TypeMessage:
+		if hooker, ok := s.hooker.(EnvelopeHooker); ok {
+			hooker.HandleEnvelope(cli, e)
+			return
+		}
+		s.hooker.HandleReceive(cli, e.Payload)
+	case envelope.TypeHeartbeat:
+		cli.ReFlushHeartBeatTime()
+		s.reply(cli, envelope.New(envelope.TypeHeartbeat, e.ID, nil))
+	case envelope.TypeAck:
+		if s.tracker != nil {
+			s.tracker.Ack(cli.Identification(), e.ID)
+		}
+	case envelope.TypeSubscribe, envelope.TypeUnsubscribe:
+		if err := s.subscribe(cli, string(e.Payload), e.Type == envelope.TypeSubscribe); err != nil {
+			s.reply(cli, envelope.Error(e.ID, err))
+			return
+		}
+		s.reply(cli, envelope.New(envelope.TypeAck, e.ID, nil))
+	case envelope.TypeAuth:
+		if err := s.refreshPrincipal(cli, string(e.Payload)); err != nil {
+			s.reply(cli, envelope.Error(e.ID, err))
+			return
+		}
+		s.reply(cli, envelope.New(envelope.TypeAck, e.ID, nil))
+	case envelope.TypeRequest:
+		// the request is served in its own goroutine , so the handler can call the client , the
+		// goroutines of connection are bounded by Options.MaxInflightRequests
+		ctx, release, err := s.inflight.Acquire(s.ctx, cli)
+		if err != nil {
+			s.reply(cli, envelope.Error(e.ID, err))
+			return
+		}
+		go s.serveRequest(ctx, release, cli, e)
+	case envelope.TypeResponse:
+		s.calls.Resolve(cli, e.ID, e.Payload, nil)
+	case envelope.TypeError:
+		if e.ID != "" && s.calls.Resolve(cli, e.ID, nil, rpc.RemoteError(e.Payload)) {
+			return
+		}
+		logging.Log.Warn("handleEnvelope", zap.String("ID", cli.Identification()), zap.String("MESSAGE_ID", e.ID),
+			zap.ByteString("ERROR", e.Payload))
+	}
+}
+
+func (s *Server) subscribe(cli conn.Connect, tag string, subscribe bool) error {
+	if tag == "" {
+		return errTagIsEmpty
+	}
+	hooker, ok := s.hooker.(SubscribeHooker)
+	if !ok {
+		return errSubscribeNotSupported
+	}
+	if err := hooker.SubscribeHook(cli, tag, subscribe); err != nil {
+		return err
+	}
+	if subscribe {
+		s.addTags(cli, tag)
+	} else {
+		s.delTags(cli, tag)
+	}
+	return nil
+}
+
+// reply send the envelope to the connection
+func (s *Server) reply(cli conn.Connect, e *envelope.Envelope) {
+	data, err := s.opt.Envelope.Encode(e)
+	if err == nil {
+		err = cli.Send(data)
+	}
+	if err != nil {
+		logging.Log.Warn("reply", zap.String("ID", cli.Identification()), zap.String("TYPE", e.Type.String()), zap.Error(err))
+	}
+}
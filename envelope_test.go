@@ -0,0 +1,98 @@
+package sim
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/envelope"
+)
+
+// subscribeHook refuse the subscription of private label , and record the application message
+type subscribeHook struct {
+	hook
+	received []string
+}
+
+func (h *subscribeHook) HandleReceive(cli conn.Connect, data []byte) {
+	h.received = append(h.received, string(data))
+}
+
+func (h *subscribeHook) SubscribeHook(cli conn.Connect, tag string, subscribe bool) error {
+	if tag == "private" {
+		return errors.New("the label is private")
+	}
+	return nil
+}
+
+func TestServer_HandleEnvelope(t *testing.T) {
+	h := &subscribeHook{}
+	codec := envelope.BinaryCodec{}
+	s, err := NewServer(h, WithEnvelope(codec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	cli := &MockConn{id: "steven"}
+	if _, _, err := s.bucket(cli.id).Register(cli); err != nil {
+		t.Fatal(err)
+	}
+	// send the envelope to server and return the reply
+	send := func(e *envelope.Envelope) *envelope.Envelope {
+		before := len(cli.messages())
+		data, err := codec.Encode(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.handleReceive(cli, data)
+		messages := cli.messages()
+		if len(messages) == before {
+			return nil
+		}
+		reply, err := codec.Decode(messages[len(messages)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	if reply := send(envelope.New(envelope.TypeHeartbeat, "1", nil)); reply == nil || reply.Type != envelope.TypeHeartbeat || reply.ID != "1" {
+		t.Fatalf("heartbeat reply = %+v", reply)
+	}
+	if cli.GetLastHeartBeatTime() == 0 {
+		t.Fatal("the heartbeat time is not refreshed")
+	}
+	if reply := send(envelope.New(envelope.TypeSubscribe, "2", []byte("room_2018"))); reply == nil || reply.Type != envelope.TypeAck || reply.ID != "2" {
+		t.Fatalf("subscribe reply = %+v", reply)
+	}
+	if reply := send(envelope.New(envelope.TypeSubscribe, "3", []byte("private"))); reply == nil || reply.Type != envelope.TypeError {
+		t.Fatalf("subscribe private reply = %+v", reply)
+	}
+	if err := s.SendToLabel("room_2018", []byte("room")); err != nil {
+		t.Fatal(err)
+	}
+	if messages := cli.messages(); string(messages[len(messages)-1]) != "room" {
+		t.Fatal("the connection should receive the message of label subscribed")
+	}
+	if reply := send(envelope.New(envelope.TypeUnsubscribe, "4", []byte("room_2018"))); reply == nil || reply.Type != envelope.TypeAck || len(cli.Tags()) != 0 {
+		t.Fatalf("unsubscribe reply = %+v , tags %v", reply, cli.Tags())
+	}
+	// the token can't be refreshed without the authenticator
+	if reply := send(envelope.New(envelope.TypeAuth, "6", []byte("token"))); reply == nil || reply.Type != envelope.TypeError || reply.ID != "6" {
+		t.Fatalf("auth reply = %+v", reply)
+	}
+	// only the application message is handed to the hooker
+	if reply := send(envelope.New(envelope.TypeMessage, "5", []byte("hello"))); reply != nil {
+		t.Fatalf("the application message should not be replied , reply = %+v", reply)
+	}
+	if len(h.received) != 1 || h.received[0] != "hello" {
+		t.Fatalf("hooker received %q", h.received)
+	}
+	s.handleReceive(cli, []byte("bad"))
+	if reply, _ := codec.Decode(cli.messages()[len(cli.messages())-1]); reply == nil || reply.Type != envelope.TypeError {
+		t.Fatalf("bad frame reply = %+v", reply)
+	}
+}
@@ -11,7 +11,9 @@ import (
 
 	"github.com/mongofs/sim"
 	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/envelope"
 	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/middleware"
 )
 
 type talk struct {
@@ -21,6 +23,8 @@ type talk struct {
 type hooker struct {
 }
 
+var codec = envelope.JSONCodec{}
+
 func (h hooker) Validate(token string) error {
 	return nil
 }
@@ -31,19 +35,23 @@ func (h hooker) Offline(cli conn.Connect, ty int) {
 	}
 }
 
+// ValidateFailed the connection is closed by sim after returned
 func (h hooker) ValidateFailed(err error, cli conn.Connect) {
-	panic("implement me")
+	logging.Log.Warn("ValidateFailed", zap.String("ID", cli.Identification()), zap.Error(err))
 }
 
 func (h hooker) ValidateSuccess(cli conn.Connect) {
 	return
 }
 
+// HandleReceive only receive the application message , the heartbeat is handled by the
+// envelope protocol
 func (h hooker) HandleReceive(conn conn.Connect, data []byte) {
-
-	conn.Send([]byte("你好呀"))
-
-	conn.ReFlushHeartBeatTime()
+	reply, err := codec.Encode(envelope.New(envelope.TypeMessage, "", []byte("你好呀")))
+	if err != nil {
+		return
+	}
+	conn.Send(reply)
 	//fmt.Println(string(data))
 	return
 }
@@ -57,7 +65,8 @@ func test() {
 }
 
 func main() {
-	sim.NewSIMServer(hooker{}, sim.WithServerDebug())
+	sim.NewSIMServer(hooker{}, sim.WithServerDebug(), sim.WithEnvelope(codec),
+		sim.WithMiddleware(middleware.Recovery(false), middleware.Trace()), sim.WithPingInterval(30*time.Second))
 	tk := &talk{http: NewHTTP()}
 	if err := sim.Run(); err != nil {
 		panic(err)
@@ -0,0 +1,277 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mongofs/sim/pkg/auth"
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// 降级的http 接口，客户端在websocket 不可用的时候可以自动切换 :
+//
+//	GET  /sse              建立SSE 连接 ，第一个事件为 session ，data 是会话id ，之后每条消息是一个事件 ，
+//	                       服务端关闭时会发送 close 事件 ，data 为 "{code} {reason}" ，消息类型为二进制的
+//	                       时候消息的事件为 binary ，data 使用base64 编码
+//	GET  /poll             没有sid 参数的时候建立long-polling 会话 ，返回 {"sid": "..."}
+//	GET  /poll?sid=xxx     等待消息 ，最长等待 Options.PollTimeout ，返回 {"messages": [...]} ，
+//	                       服务端关闭时返回 closed 为true ，消息类型为二进制的时候消息使用base64 编码 ，
+//	                       同时返回 encoding 为 "base64"
+//	POST /send?sid=xxx     上行消息 ，body 为消息内容 ，SSE 和long-polling 共用 ，消息交给 HandleReceive
+//
+// 用户的识别和校验与websocket 完全一样 ，都是通过 Hooker 完成的 ，连接同样会注册到bucket 中 ，
+// 心跳规则也是一样的 ，客户端需要通过 /send 上报心跳
+
+const (
+	TransportSSE         = "sse"
+	TransportLongPolling = "longpolling"
+)
+
+const (
+	// the interval of keepalive comment of SSE
+	sseKeepaliveInterval = 15 * time.Second
+	// the max size of message sent by /send
+	maxFallbackMessage = 64 << 10
+)
+
+// deliverer is implemented by the wire of SSE and long-polling , the message sent by client
+// is handed to the connection by Deliver
+type deliverer interface {
+	Deliver(ctx context.Context, data []byte) error
+}
+
+// session is the connection of SSE or long-polling , poll is nil when it is SSE
+type session struct {
+	cli  conn.Connect
+	wire deliverer
+	poll *conn.PollWire
+}
+
+// FallbackHandler return the handler of SSE and long-polling , you can mount it on your http
+// server for the client which can not use websocket
+func (s *Server) FallbackHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", s.fallbackSSE)
+	mux.HandleFunc("/poll", s.fallbackPoll)
+	mux.HandleFunc("/send", s.fallbackSend)
+	return mux
+}
+
+func (s *Server) fallbackSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	if s.running.Load() != RunStatusRunning {
+		writeAdmin(w, http.StatusServiceUnavailable, errServerIsNotRunning.Error(), nil)
+		return
+	}
+	identification, device, principal, err := s.authenticate(w, r)
+	if err != nil {
+		s.metrics.upgrades.With(TransportSSE, upgradeFailure).Inc()
+		s.metrics.observeUnauthorized(TransportSSE, err)
+		writeAdmin(w, auth.StatusCode(err), err.Error(), nil)
+		return
+	}
+	wire, err := conn.NewSSEWire(w, s.opt.Connection.MessageType)
+	if err != nil {
+		s.metrics.upgrades.With(TransportSSE, upgradeFailure).Inc()
+		writeAdmin(w, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	sig := s.bucket(identification).SignalChannel()
+	cli := conn.NewWireConn(identification, device, wire, sig, s.handleReceive, s.opt.Connection)
+	sid := newSessionID()
+	s.sessions.Store(sid, &session{cli: cli, wire: wire})
+	defer s.sessions.Delete(sid)
+	// the session event must be the first event , so it is written before the connection
+	// registered , the message replayed by bucket will be written after it
+	if err := wire.WriteEvent("session", []byte(sid)); err != nil {
+		cli.Close("write session event failed ")
+		return
+	}
+	s.attachAttributes(TransportSSE, cli, r)
+	if !s.registerSession(TransportSSE, sid, cli, r, principal) {
+		return
+	}
+
+	// the ResponseWriter can not be used after the handler returned , so the handler is blocked
+	// until the connection is closed
+	ticker := time.NewTicker(sseKeepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wire.Done():
+			return
+		case <-r.Context().Done():
+			cli.Close("sse request is done ")
+			return
+		case <-ticker.C:
+			if err := wire.Keepalive(); err != nil {
+				cli.Close("sse keepalive failed ")
+				return
+			}
+		}
+	}
+}
+
+// the encoding of messages of long-polling when the message type of connection is binary
+const pollEncodingBase64 = "base64"
+
+type pollResponse struct {
+	Sid string `json:"sid,omitempty"`
+	// Messages are encoded by base64 when the Encoding is base64 , because the binary message
+	// is not valid utf-8 , and it will be broken by JSON string
+	Messages []string `json:"messages,omitempty"`
+	Encoding string   `json:"encoding,omitempty"`
+	Closed   bool     `json:"closed,omitempty"`
+	Code     int      `json:"code,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+}
+
+func (s *Server) fallbackPoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	if s.running.Load() != RunStatusRunning {
+		writeAdmin(w, http.StatusServiceUnavailable, errServerIsNotRunning.Error(), nil)
+		return
+	}
+	sid := r.URL.Query().Get("sid")
+	if sid == "" {
+		s.createPollSession(w, r)
+		return
+	}
+	sess, ok := s.session(sid)
+	if !ok || sess.poll == nil {
+		writeAdmin(w, http.StatusNotFound, "the session is not existed", nil)
+		return
+	}
+	res, err := sess.poll.Poll(r.Context(), s.opt.PollTimeout)
+	if err != nil {
+		writeAdmin(w, http.StatusGone, err.Error(), nil)
+		return
+	}
+	resp := &pollResponse{Closed: res.Closed, Code: res.Code, Reason: res.Reason}
+	binary := s.opt.Connection.MessageType == conn.MessageTypeBinary
+	if binary && len(res.Messages) > 0 {
+		resp.Encoding = pollEncodingBase64
+	}
+	for _, message := range res.Messages {
+		if binary {
+			resp.Messages = append(resp.Messages, base64.StdEncoding.EncodeToString(message))
+		} else {
+			resp.Messages = append(resp.Messages, string(message))
+		}
+	}
+	writeAdmin(w, http.StatusOK, "ok", resp)
+}
+
+func (s *Server) createPollSession(w http.ResponseWriter, r *http.Request) {
+	identification, device, principal, err := s.authenticate(w, r)
+	if err != nil {
+		s.metrics.upgrades.With(TransportLongPolling, upgradeFailure).Inc()
+		s.metrics.observeUnauthorized(TransportLongPolling, err)
+		writeAdmin(w, auth.StatusCode(err), err.Error(), nil)
+		return
+	}
+	// the client should poll again before the session expired
+	wire := conn.NewPollWire(s.opt.Connection.Buffer, 2*s.opt.PollTimeout)
+	sig := s.bucket(identification).SignalChannel()
+	cli := conn.NewWireConn(identification, device, wire, sig, s.handleReceive, s.opt.Connection)
+	sid := newSessionID()
+	s.sessions.Store(sid, &session{cli: cli, wire: wire, poll: wire})
+	s.attachAttributes(TransportLongPolling, cli, r)
+	if !s.registerSession(TransportLongPolling, sid, cli, r, principal) {
+		writeAdmin(w, http.StatusUnauthorized, "validate failed", nil)
+		return
+	}
+	go func() {
+		<-wire.Done()
+		s.sessions.Delete(sid)
+	}()
+	writeAdmin(w, http.StatusOK, "ok", &pollResponse{Sid: sid})
+}
+
+func (s *Server) fallbackSend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdmin(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	sess, ok := s.session(r.URL.Query().Get("sid"))
+	if !ok {
+		writeAdmin(w, http.StatusNotFound, "the session is not existed", nil)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxFallbackMessage+1))
+	if err != nil {
+		writeAdmin(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if len(data) > maxFallbackMessage {
+		writeAdmin(w, http.StatusRequestEntityTooLarge, "the message is too large", nil)
+		return
+	}
+	if err := sess.wire.Deliver(r.Context(), data); err != nil {
+		writeAdmin(w, http.StatusGone, err.Error(), nil)
+		return
+	}
+	writeAdmin(w, http.StatusOK, "ok", nil)
+}
+
+// registerSession register the connection to bucket , the session is removed and the connection
+// is closed when the validation failed , because the ResponseWriter can not be used by it anymore
+func (s *Server) registerSession(transport, sid string, cli conn.Connect, r *http.Request, principal *auth.Principal) bool {
+	if ok, err := s.register(transport, cli, resumeCursor(r), principal); !ok {
+		if err != nil {
+			logging.Log.Warn("registerSession", zap.String("TRANSPORT", transport), zap.Error(err))
+		}
+		s.sessions.Delete(sid)
+		cli.Close("validate failed ")
+		return false
+	}
+	if hooker, ok := s.hooker.(LabelHooker); ok {
+		s.addTags(cli, hooker.LabelHook(cli, r)...)
+	}
+	return true
+}
+
+func (s *Server) session(sid string) (*session, bool) {
+	if sid == "" {
+		return nil, false
+	}
+	v, ok := s.sessions.Load(sid)
+	if !ok {
+		return nil, false
+	}
+	return v.(*session), true
+}
+
+func newSessionID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
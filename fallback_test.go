@@ -0,0 +1,203 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// echoHook identify the user by query and echo the message
+type echoHook struct {
+	hook
+}
+
+func (h *echoHook) Validate(token string) error {
+	return nil
+}
+
+func (h *echoHook) ValidateSuccess(cli conn.Connect) {}
+
+func (h *echoHook) HandleReceive(cli conn.Connect, data []byte) {
+	cli.Send(data)
+}
+
+func (h *echoHook) IdentificationHook(w http.ResponseWriter, r *http.Request) (string, error) {
+	return r.URL.Query().Get("id"), nil
+}
+
+// waitOnline wait for the user registered to bucket
+func waitOnline(t *testing.T, s *Server, identification string) {
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := s.bucket(identification).Get(identification); ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v is not registered", identification)
+		}
+	}
+}
+
+func TestServer_FallbackSSE(t *testing.T) {
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.FallbackHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/sse?id=steven")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	// readEvent return the event name and data of the next event
+	readEvent := func() (string, string) {
+		var event, data string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				return event, data
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+	event, sid := readEvent()
+	if event != "session" || sid == "" {
+		t.Fatalf("the first event is %q %q , want the session", event, sid)
+	}
+	waitOnline(t, s, "steven")
+	if _, err := http.Post(ts.URL+"/send?sid="+sid, "text/plain", strings.NewReader("echo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, data := readEvent(); data != "echo" {
+		t.Fatalf("received %q , want echo", data)
+	}
+	if err := s.SendMessage([]byte("push"), []string{"steven"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, data := readEvent(); data != "push" {
+		t.Fatalf("received %q , want push", data)
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if event, data := readEvent(); event != "close" || !strings.HasSuffix(data, DefaultShutdownCloseReason) {
+		t.Fatalf("received %q %q , want the close event", event, data)
+	}
+}
+
+func TestServer_FallbackLongPolling(t *testing.T) {
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2), WithPollTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	ts := httptest.NewServer(s.FallbackHandler())
+	defer ts.Close()
+
+	poll := func(query string) (int, *pollResponse) {
+		resp, err := http.Get(ts.URL + "/poll?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		res := &struct {
+			Data *pollResponse `json:"data"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, res.Data
+	}
+	if status, _ := poll("sid=unknown"); status != http.StatusNotFound {
+		t.Fatalf("poll unknown session status = %v , want 404", status)
+	}
+	_, session := poll("id=mike")
+	if session == nil || session.Sid == "" {
+		t.Fatal("the session id is empty")
+	}
+	// nothing to poll , the request returns after the poll timeout
+	if _, res := poll("sid=" + session.Sid); res == nil || len(res.Messages) != 0 {
+		t.Fatalf("poll = %+v , want empty", res)
+	}
+	if _, err := http.Post(ts.URL+"/send?sid="+session.Sid, "text/plain", strings.NewReader("echo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendMessage([]byte("push"), []string{"mike"}); err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for i := 0; i < 3 && len(messages) < 2; i++ {
+		_, res := poll("sid=" + session.Sid)
+		messages = append(messages, res.Messages...)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("polled %q , want echo and push", messages)
+	}
+}
+
+func TestServer_FallbackLongPollingBinary(t *testing.T) {
+	option := conn.DefaultOption()
+	option.MessageType = conn.MessageTypeBinary
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2), WithConnectionOption(option), WithPollTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	ts := httptest.NewServer(s.FallbackHandler())
+	defer ts.Close()
+
+	poll := func(query string) *pollResponse {
+		resp, err := http.Get(ts.URL + "/poll?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		res := &struct {
+			Data *pollResponse `json:"data"`
+		}{}
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+		return res.Data
+	}
+	session := poll("id=mike")
+	waitOnline(t, s, "mike")
+	message := []byte{0xff, 0x00, 0xfe, '\n'}
+	if err := s.SendMessage(message, []string{"mike"}); err != nil {
+		t.Fatal(err)
+	}
+	res := poll("sid=" + session.Sid)
+	if res.Encoding != pollEncodingBase64 || len(res.Messages) != 1 {
+		t.Fatalf("poll = %+v , want one message encoded by base64", res)
+	}
+	if data, err := base64.StdEncoding.DecodeString(res.Messages[0]); err != nil || !bytes.Equal(data, message) {
+		t.Fatalf("polled %v , want %v", data, message)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/zap"
+)
+
+var (
+	// the user is not online in this server
+	errUserIsNotOnline = errors.New("the user is not online ")
+)
+
+// AddTag add tags to all the connections of online user , the user will be added to the label
+// of each tag , then you can use SendToLabel or SendToLabels to send message to the user
+func (s *Server) AddTag(identification string, tags ...string) error {
+	if s.running.Load() != RunStatusRunning {
+		return errServerIsNotRunning
+	}
+	clients, ok := s.bucket(identification).Get(identification)
+	if !ok {
+		return errUserIsNotOnline
+	}
+	for _, cli := range clients {
+		s.addTags(cli, tags...)
+	}
+	return nil
+}
+
+// DelTag remove tags from all the connections of online user
+func (s *Server) DelTag(identification string, tags ...string) error {
+	if s.running.Load() != RunStatusRunning {
+		return errServerIsNotRunning
+	}
+	clients, ok := s.bucket(identification).Get(identification)
+	if !ok {
+		return errUserIsNotOnline
+	}
+	for _, cli := range clients {
+		s.delTags(cli, tags...)
+	}
+	return nil
+}
+
+// SendToLabel send message to all the users who have the tag
+func (s *Server) SendToLabel(tag string, msg []byte) error {
+	if s.running.Load() != RunStatusRunning {
+		return errServerIsNotRunning
+	}
+	start := time.Now()
+	failed, err := s.labels.BroadCastByLabel(map[string][]byte{tag: msg})
+	s.metrics.observeFanout(start)
+	if err != nil {
+		return err
+	}
+	if len(failed) != 0 {
+		logging.Log.Warn("SendToLabel", zap.String("TAG", tag), zap.Strings("FAILED_ID", failed))
+	}
+	return nil
+}
+
+// SendToLabels send message to the users who have all the tags , that is the intersection
+// of the labels
+func (s *Server) SendToLabels(msg []byte, tags []string) error {
+	if s.running.Load() != RunStatusRunning {
+		return errServerIsNotRunning
+	}
+	start := time.Now()
+	failed, err := s.labels.BroadCastWithInnerJoinLabel(msg, tags)
+	s.metrics.observeFanout(start)
+	if err != nil {
+		return err
+	}
+	if len(failed) != 0 {
+		logging.Log.Warn("SendToLabels", zap.Strings("TAGS", tags), zap.Strings("FAILED_ID", failed))
+	}
+	return nil
+}
+
+// labelClient is the connection stored in label , the identification of connection is used as
+// the key in label , but a user may have connections on different devices , so the key is made
+// up of identification and the address of connection
+type labelClient struct {
+	conn.Connect
+}
+
+func (l labelClient) Identification() string {
+	return labelKey(l.Connect)
+}
+
+func labelKey(cli conn.Connect) string {
+	return fmt.Sprintf("%s@%p", cli.Identification(), cli)
+}
+
+func (s *Server) addTags(cli conn.Connect, tags ...string) {
+	for _, tag := range tags {
+		fc, err := s.labels.AddClient(tag, labelClient{cli})
+		if err != nil {
+			logging.Log.Error("addTags", zap.String("ID", cli.Identification()), zap.String("TAG", tag), zap.Error(err))
+			continue
+		}
+		cli.SetTag(tag, fc)
+	}
+}
+
+func (s *Server) delTags(cli conn.Connect, tags ...string) {
+	for _, tag := range tags {
+		if fc, ok := cli.DelTag(tag); ok && fc != nil {
+			fc.Delete([]string{labelKey(cli)})
+		}
+	}
+}
+
+// cleanTags is the callback of bucket , when the user is removed from bucket , all the
+// tags of the user should be removed from label manager
+func (s *Server) cleanTags(cli conn.Connect) {
+	s.delTags(cli, cli.Tags()...)
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mongofs/sim/pkg/auth"
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/dispatch"
+	"github.com/mongofs/sim/pkg/metrics"
+	"github.com/mongofs/sim/pkg/netpoll"
+)
+
+// the result of upgrade
+const (
+	upgradeSuccess = "success"
+	upgradeFailure = "failure"
+)
+
+// the reason of handshake rejected
+const (
+	rejectUnauthorized = "unauthorized"
+	rejectForbidden    = "forbidden"
+	rejectOrigin       = "origin"
+	rejectBadHandshake = "bad_handshake"
+)
+
+// the reason of offline , offlineByClosed means the connection is closed by itself , for
+// example the network error or the heartbeat is timeout
+const (
+	offlineBySqueezeOut = "squeeze_out"
+	offlineByLogic      = "logic"
+	offlineByClosed     = "closed"
+)
+
+// serverMetrics is the metrics of Server , each Server has its own registry , so the metrics
+// of different Server in the same process are not mixed up
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	upgrades    *metrics.CounterVec
+	offline     *metrics.CounterVec
+	labelFanout *metrics.Histogram
+	receive     *metrics.Histogram
+	rateLimited *metrics.CounterVec
+	overflow    *metrics.CounterVec
+	rejected    *metrics.CounterVec
+	conn        *conn.Metrics
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	r := metrics.NewRegistry()
+	r.NewGaugeFunc("sim_online_connections", "The number of online connections.", func() float64 {
+		var sum int
+		for _, bt := range s.bs {
+			sum += bt.Count()
+		}
+		return float64(sum)
+	})
+	r.NewGaugeVecFunc("sim_bucket_online_connections", "The number of online connections of each bucket.", "bucket", func() map[string]float64 {
+		res := make(map[string]float64, len(s.bs))
+		for _, bt := range s.bs {
+			res[bt.ID()] = float64(bt.Count())
+		}
+		return res
+	})
+	connMetrics := &conn.Metrics{
+		MessagesSent:    r.NewCounter("sim_messages_sent_total", "The number of messages written to clients."),
+		BytesSent:       r.NewCounter("sim_message_bytes_sent_total", "The number of bytes written to clients."),
+		MessagesDropped: r.NewCounter("sim_messages_dropped_total", "The number of messages dropped because the connection is weak."),
+		WriteLatency:    r.NewHistogram("sim_write_duration_seconds", "The latency of writing a message to client.", nil),
+	}
+	r.NewGaugeVecFunc("sim_queue_depth", "The number of messages waiting in the queue of connections by lane.", "lane", func() map[string]float64 {
+		return map[string]float64{
+			conn.PriorityHigh.String():   float64(connMetrics.QueueDepth(conn.PriorityHigh)),
+			conn.PriorityNormal.String(): float64(connMetrics.QueueDepth(conn.PriorityNormal)),
+		}
+	})
+	r.NewGaugeFunc("sim_dispatch_pending_messages", "The number of inbound messages waiting or being handled in the worker pool.", func() float64 {
+		if s.pool == nil {
+			return 0
+		}
+		return float64(s.pool.Pending())
+	})
+	r.NewGaugeFunc("sim_dispatch_busy_workers", "The number of workers handling inbound messages.", func() float64 {
+		if s.pool == nil {
+			return 0
+		}
+		return float64(s.pool.Busy())
+	})
+	return &serverMetrics{
+		registry:    r,
+		conn:        connMetrics,
+		upgrades:    r.NewCounterVec("sim_upgrades_total", "The number of connection requests by transport and result.", "transport", "result"),
+		offline:     r.NewCounterVec("sim_offline_total", "The number of offline connections by reason.", "reason"),
+		labelFanout: r.NewHistogram("sim_label_broadcast_duration_seconds", "The time spent on broadcasting a message to labels.", nil),
+		receive:     r.NewHistogram("sim_receive_duration_seconds", "The time spent on handling an inbound message.", nil),
+		overflow:    r.NewCounterVec("sim_dispatch_overflow_total", "The number of inbound messages refused because the worker pool is full by policy.", "policy"),
+		rateLimited: r.NewCounterVec("sim_rate_limited_total", "The number of inbound messages refused by rate limit by scope and action.", "scope", "action"),
+		rejected:    r.NewCounterVec("sim_handshake_rejected_total", "The number of handshakes rejected by transport and reason.", "transport", "reason"),
+	}
+}
+
+// MetricsHandler return the handler which expose the metrics of Server in prometheus text
+// exposition format , you can mount it on your own http server
+func (s *Server) MetricsHandler() http.Handler {
+	return s.metrics.registry.Handler()
+}
+
+func (m *serverMetrics) observeFanout(start time.Time) {
+	m.labelFanout.Observe(time.Since(start).Seconds())
+}
+
+func (m *serverMetrics) observeReceive(cli conn.Connect, spend time.Duration) {
+	m.receive.Observe(spend.Seconds())
+}
+
+// observeOverflow return the function count the messages refused by the worker pool
+func (m *serverMetrics) observeOverflow(policy dispatch.Overflow) func(cli conn.Connect, data []byte) {
+	counter := m.overflow.With(policy.String())
+	return func(cli conn.Connect, data []byte) {
+		counter.Inc()
+	}
+}
+
+// observeUnauthorized count the request refused by the identification or the Authenticator
+func (m *serverMetrics) observeUnauthorized(transport string, err error) {
+	reason := rejectUnauthorized
+	if auth.StatusCode(err) == http.StatusForbidden {
+		reason = rejectForbidden
+	}
+	m.rejected.With(transport, reason).Inc()
+}
+
+// observeUpgradeError count the handshake refused by the policy or illegal , other errors such
+// as the network error are not counted
+func (m *serverMetrics) observeUpgradeError(transport string, err error) {
+	var handshakeErr websocket.HandshakeError
+	switch {
+	case errors.Is(err, conn.ErrOriginNotAllowed):
+		m.rejected.With(transport, rejectOrigin).Inc()
+	case errors.As(err, &handshakeErr), errors.Is(err, netpoll.ErrBadHandshake):
+		m.rejected.With(transport, rejectBadHandshake).Inc()
+	}
+}
+
+func offlineReason(ty int) string {
+	switch ty {
+	case OfflineBySqueezeOut:
+		return offlineBySqueezeOut
+	case OfflineByLogic:
+		return offlineByLogic
+	}
+	return offlineByClosed
+}
@@ -0,0 +1,85 @@
+package sim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+func TestServer_MetricsHandler(t *testing.T) {
+	s, err := NewServer(&hook{}, WithServerBucketNumber(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	for _, id := range []string{"steven", "mike"} {
+		if _, _, err := s.bucket(id).Register(&MockConn{id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Kick("mike", "test")
+
+	recorder := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"sim_online_connections 1\n",
+		`sim_offline_total{reason="logic"} 1` + "\n",
+		"# TYPE sim_write_duration_seconds histogram\n",
+		"# TYPE sim_label_broadcast_duration_seconds histogram\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics should contain %q , got \n%v", want, body)
+		}
+	}
+	if s.opt.Connection.Metrics == nil {
+		t.Fatal("the metrics of connection should be set")
+	}
+}
+
+func TestServer_HandshakeRejected(t *testing.T) {
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2), WithHandshake(&conn.Handshake{
+		AllowedOrigins: []string{"https://*.example.com"},
+		Subprotocols:   []string{"sim.v1"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Upgrade(w, r)
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "?id=steven"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.com"}})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial() from the origin not allowed = %v , want status %v", err, http.StatusForbidden)
+	}
+	dialer := &websocket.Dialer{Subprotocols: []string{"sim.v1"}}
+	client, _, err := dialer.Dial(url, http.Header{"Origin": []string{"https://chat.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	waitOnline(t, s, "steven")
+	if client.Subprotocol() != "sim.v1" {
+		t.Fatalf("Subprotocol() = %q , want sim.v1", client.Subprotocol())
+	}
+
+	recorder := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `sim_handshake_rejected_total{transport="websocket",reason="origin"} 1`; !strings.Contains(recorder.Body.String(), want) {
+		t.Fatalf("metrics should contain %q , got \n%v", want, recorder.Body.String())
+	}
+}
@@ -0,0 +1,59 @@
+package sim
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestServer_Netpoll(t *testing.T) {
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2), WithNetpoll(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Upgrade(w, r)
+	}))
+	defer ts.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?id=steven", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.WriteMessage(websocket.TextMessage, []byte("echo")); err != nil {
+		t.Fatal(err)
+	}
+	// the connection is registered to bucket after the handshake response is written
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := s.bucket("steven").Get("steven"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("steven is not registered")
+		}
+	}
+	if err := s.SendMessage([]byte("push"), []string{"steven"}); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 2; i++ {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[string(data)] = true
+	}
+	if !got["echo"] || !got["push"] {
+		t.Fatalf("client received %v", got)
+	}
+}
@@ -14,8 +14,21 @@
 package sim
 
 import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mongofs/sim/pkg/ack"
+	"github.com/mongofs/sim/pkg/auth"
+	"github.com/mongofs/sim/pkg/cluster"
 	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/dispatch"
+	"github.com/mongofs/sim/pkg/envelope"
 	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/middleware"
+	"github.com/mongofs/sim/pkg/netpoll"
+	"github.com/mongofs/sim/pkg/ratelimit"
+	"github.com/mongofs/sim/pkg/resume"
+	"github.com/mongofs/sim/pkg/store"
 )
 
 const (
@@ -25,8 +38,16 @@ const (
 	DefaultBucketBuffer               = 1 << 5 // 32
 	DefaultBucketSendMessageGoroutine = 1 << 2 // 4
 
-	// to show some pprof
+	// the address of pprof served in debug mode , see WithServerDebug
 	DefaultPProfPort = ":6060"
+
+	// the close frame send to client when server shutdown , and the time limit of Stop
+	DefaultShutdownCloseCode   = websocket.CloseGoingAway
+	DefaultShutdownCloseReason = "server shutdown"
+	DefaultShutdownTimeout     = 10 * time.Second
+
+	// the time limit of a long-polling request
+	DefaultPollTimeout = 25 * time.Second
 )
 
 const (
@@ -43,7 +64,80 @@ type Options struct {
 	ServerBucketNumber         int           // ServerBucketNumber
 	LogPath                    string        // LogPath
 	LogLevel                   logging.Level // LogLevel
-	PProfPort                  string        // PProfPort
+	PProfPort                  string        // PProfPort the address of pprof served in debug mode , it is turned off when empty
+	ShutdownCloseCode          int           // ShutdownCloseCode the code of close frame when server shutdown
+	ShutdownCloseReason        string        // ShutdownCloseReason the reason of close frame when server shutdown
+	ShutdownTimeout            time.Duration // ShutdownTimeout the time limit of Stop to drain connections
+
+	// Backpressure , PingInterval and Handshake override the same fields of Connection when they
+	// are set , they are merged into the copy of Connection by NewServer , so they work in any
+	// order with WithConnectionOption
+	Backpressure *conn.Backpressure
+	PingInterval time.Duration
+	Handshake    *conn.Handshake
+
+	// Ack is the option of reliable mode , the reliable mode is turned off when it is nil ,
+	// you can use SendWithAck to send message that need the ack of client
+	Ack *ack.Option
+
+	// Resume turn on the session resumption , each message sent to user carries a sequence and
+	// the recent messages are kept , the client reconnecting with the session id and the last
+	// sequence will receive the messages missed , it is nil when the resumption is turned off
+	Resume *resume.Option
+
+	// Envelope turn on the envelope protocol , the message of client is decoded by it , the control
+	// message is handled by server and only the application message is handed to the hooker , the
+	// client should ack the reliable message by the ack envelope . It is nil when turned off
+	Envelope envelope.Codec
+
+	// MaxInflightRequests is the max requests of a connection served at the same time , the
+	// request exceeds it is replied rpc.ErrTooManyRequests , rpc.DefaultMaxInflight is used when
+	// it is not positive
+	MaxInflightRequests int
+
+	// Store keep the message sent to offline user , the message will be replayed when the user
+	// upgrade again , if it is nil , the message of offline user will be dropped
+	Store store.MessageStore
+
+	// SessionPolicy decide what to do when the user upgrade again , the default policy is
+	// squeeze out , MaxSessionConnections is the max connections of a user
+	SessionPolicy         SessionPolicy
+	MaxSessionConnections int
+
+	// Cluster turn on the cluster mode , the message will be routed to the node that user is
+	// online on , it is nil when the server is deployed as a single node
+	Cluster *cluster.Option
+
+	// Netpoll use the epoll event loop to manage the websocket connections instead of
+	// gorilla , it is only supported on linux , it is nil when using gorilla
+	Netpoll *netpoll.Option
+
+	// Middlewares wrap the handling of inbound message , the first one is the outermost , they
+	// run the same way for all the transports , see the built-ins in package middleware
+	Middlewares []middleware.Middleware
+
+	// Dispatch handle the inbound messages in a bounded worker pool , so the slow handler will not
+	// block the connection reading , the messages of a connection are still handled in order . It
+	// is nil when the messages are handled in the goroutine of connection
+	Dispatch *dispatch.Option
+
+	// RateLimit limit the inbound messages of connections and users , the message exceeds the
+	// limit is refused before handed to hooker , it is nil when the rate limit is turned off
+	RateLimit *ratelimit.Option
+
+	// Authenticator authenticate the http request before the upgrade , the request failed is
+	// replied with 401 or 403 , and the subject of principal is used as the identification
+	// instead of the IdentificationHook . The connection is closed when the token is expired and
+	// not refreshed in band , see the package auth . It is nil when turned off
+	Authenticator auth.Authenticator
+
+	// Attribute decide the attributes collected from the http request at upgrade , the hooker can
+	// read them by conn.Connect.Attribute , if it is nil , only the built-in attributes are set
+	Attribute *AttributeOption
+
+	// PollTimeout is the max time of a long-polling request waiting for messages , the session
+	// of long-polling is expired when the client not poll in twice of PollTimeout
+	PollTimeout time.Duration
 
 	// when user Offline by some reason  , you must to know that , so there may have some operate
 	// you need to do , so you can implement this function , but i suggest you don't
@@ -70,11 +164,37 @@ func DefaultOption() *Options {
 		BucketSendMessageGoroutine: DefaultBucketSendMessageGoroutine,
 		ServerBucketNumber:         DefaultServerBucketNumber,
 		PProfPort:                  DefaultPProfPort,
+		ShutdownCloseCode:          DefaultShutdownCloseCode,
+		ShutdownCloseReason:        DefaultShutdownCloseReason,
+		ShutdownTimeout:            DefaultShutdownTimeout,
+		SessionPolicy:              SessionPolicySqueezeOut,
+		MaxSessionConnections:      DefaultMaxSessionConnections,
+		PollTimeout:                DefaultPollTimeout,
 
 		debug: false,
 	}
 }
 
+// connection return the copy of Connection merged with the options of connection set by the
+// OptionFuncs , the Connection may be shared by other Server , so it is not modified . It returns
+// nil when the Connection is nil
+func (o *Options) connection() *conn.Option {
+	if o.Connection == nil {
+		return nil
+	}
+	option := *o.Connection
+	if o.Backpressure != nil {
+		option.Backpressure = o.Backpressure
+	}
+	if o.PingInterval > 0 {
+		option.PingInterval = o.PingInterval
+	}
+	if o.Handshake != nil {
+		option.Handshake = o.Handshake
+	}
+	return &option
+}
+
 func LoadOptions(hooker Hooker, Opt ...OptionFunc) *Options {
 	opt := DefaultOption()
 	for _, o := range Opt {
@@ -85,12 +205,17 @@ func LoadOptions(hooker Hooker, Opt ...OptionFunc) *Options {
 
 type OptionFunc func(b *Options)
 
+// WithServerDebug write the log to stdout and serve the pprof on PProfPort , DefaultPProfPort by
+// default . The logger is shared by the Servers in the same process , so the log only works on
+// the first Server created , each Server serves pprof on its own address
 func WithServerDebug() OptionFunc {
 	return func(b *Options) {
 		b.debug = true
 	}
 }
 
+// WithPprofPort set the address of pprof served in debug mode , the pprof is turned off when it
+// is empty
 func WithPprofPort(pprof string) OptionFunc {
 	return func(b *Options) {
 		b.PProfPort = pprof
@@ -115,6 +240,31 @@ func WithConnectionOption(option *conn.Option) OptionFunc {
 	}
 }
 
+// WithBackpressure set the backpressure policy of connections , it is merged into the option of
+// connection by NewServer , so it can be used before or after WithConnectionOption
+func WithBackpressure(backpressure *conn.Backpressure) OptionFunc {
+	return func(b *Options) {
+		b.Backpressure = backpressure
+	}
+}
+
+// WithPingInterval send the ping frame to websocket connections in interval , the heartbeat is
+// refreshed by the pong and any message of client , the transport without ping should refresh
+// the heartbeat by ReFlushHeartBeatTime . It can be used before or after WithConnectionOption
+func WithPingInterval(interval time.Duration) OptionFunc {
+	return func(b *Options) {
+		b.PingInterval = interval
+	}
+}
+
+// WithHandshake set the policy of websocket handshake , such as the origins allowed and the
+// subprotocols supported . It can be used before or after WithConnectionOption
+func WithHandshake(handshake *conn.Handshake) OptionFunc {
+	return func(b *Options) {
+		b.Handshake = handshake
+	}
+}
+
 func WithBucketSize(BucketSize int) OptionFunc {
 	return func(b *Options) {
 		b.BucketSize = BucketSize
@@ -138,3 +288,146 @@ func WithDiscover(discover Discover) OptionFunc {
 		opts.ServerDiscover = discover
 	}
 }
+
+func WithShutdownClose(code int, reason string) OptionFunc {
+	return func(opts *Options) {
+		opts.ShutdownCloseCode = code
+		opts.ShutdownCloseReason = reason
+	}
+}
+
+func WithShutdownTimeout(timeout time.Duration) OptionFunc {
+	return func(opts *Options) {
+		opts.ShutdownTimeout = timeout
+	}
+}
+
+// WithAck turn on the reliable mode , if the option is nil , ack.DefaultOption will be used
+func WithAck(option *ack.Option) OptionFunc {
+	return func(opts *Options) {
+		if option == nil {
+			option = ack.DefaultOption()
+		}
+		opts.Ack = option
+	}
+}
+
+// WithMessageStore keep the message of offline user in store , you can use store.NewMemoryStore
+// or store.NewFileStore , or implement the store.MessageStore by yourself
+func WithMessageStore(messageStore store.MessageStore) OptionFunc {
+	return func(opts *Options) {
+		opts.Store = messageStore
+	}
+}
+
+// WithResume turn on the session resumption , if the option is nil , resume.DefaultOption will
+// be used . The message sent to a specific device by SendToDevice is not recorded in session
+func WithResume(option *resume.Option) OptionFunc {
+	return func(opts *Options) {
+		if option == nil {
+			option = resume.DefaultOption()
+		}
+		opts.Resume = option
+	}
+}
+
+// WithEnvelope turn on the envelope protocol , if the codec is nil , envelope.JSONCodec will
+// be used
+func WithEnvelope(codec envelope.Codec) OptionFunc {
+	return func(opts *Options) {
+		if codec == nil {
+			codec = envelope.JSONCodec{}
+		}
+		opts.Envelope = codec
+	}
+}
+
+// WithMaxInflightRequests set the max requests of a connection served at the same time
+func WithMaxInflightRequests(max int) OptionFunc {
+	return func(opts *Options) {
+		opts.MaxInflightRequests = max
+	}
+}
+
+// WithCluster turn on the cluster mode , the option.Node is the current node and the
+// option.Registry should be shared by all the nodes
+func WithCluster(option *cluster.Option) OptionFunc {
+	return func(opts *Options) {
+		opts.Cluster = option
+	}
+}
+
+// WithNetpoll use the epoll event loop backend , if the option is nil , netpoll.DefaultOption
+// will be used , NewServer returns error on the platform which is not linux
+func WithNetpoll(option *netpoll.Option) OptionFunc {
+	return func(opts *Options) {
+		if option == nil {
+			option = netpoll.DefaultOption()
+		}
+		opts.Netpoll = option
+	}
+}
+
+// WithPollTimeout set the max time of a long-polling request waiting for messages
+func WithPollTimeout(timeout time.Duration) OptionFunc {
+	return func(opts *Options) {
+		if timeout > 0 {
+			opts.PollTimeout = timeout
+		}
+	}
+}
+
+// WithMiddleware append the middlewares of inbound message , for example :
+//
+//	WithMiddleware(middleware.Recovery(false), middleware.Heartbeat(), middleware.MaxSize(4096, nil))
+func WithMiddleware(middlewares ...middleware.Middleware) OptionFunc {
+	return func(opts *Options) {
+		opts.Middlewares = append(opts.Middlewares, middlewares...)
+	}
+}
+
+// WithDispatch handle the inbound messages asynchronously , if the option is nil ,
+// dispatch.DefaultOption will be used
+func WithDispatch(option *dispatch.Option) OptionFunc {
+	return func(opts *Options) {
+		if option == nil {
+			option = dispatch.DefaultOption()
+		}
+		opts.Dispatch = option
+	}
+}
+
+// WithRateLimit turn on the rate limit of inbound messages , if the option is nil ,
+// ratelimit.DefaultOption will be used , which is unlimited until the hooker implement
+// RateLimitHooker
+func WithRateLimit(option *ratelimit.Option) OptionFunc {
+	return func(opts *Options) {
+		if option == nil {
+			option = ratelimit.DefaultOption()
+		}
+		opts.RateLimit = option
+	}
+}
+
+// WithAuthenticator authenticate the request before the upgrade , for example auth.NewJWT
+func WithAuthenticator(authenticator auth.Authenticator) OptionFunc {
+	return func(opts *Options) {
+		opts.Authenticator = authenticator
+	}
+}
+
+// WithAttribute set the attributes collected from the http request at upgrade
+func WithAttribute(option *AttributeOption) OptionFunc {
+	return func(opts *Options) {
+		opts.Attribute = option
+	}
+}
+
+// WithSessionPolicy set the policy when a user have more than one connection , max is the
+// max connections of a user , the oldest connection is squeezed out when the limit exceeded
+func WithSessionPolicy(policy SessionPolicy, max int) OptionFunc {
+	return func(opts *Options) {
+		opts.SessionPolicy = policy
+		opts.MaxSessionConnections = max
+	}
+}
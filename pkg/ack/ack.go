@@ -0,0 +1,299 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/timewheel"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// ack 是可靠投递模式的实现，每一条需要确认的消息都会由服务端分配一个id，客户端收到消息后需要通过
+// 连接回复这个id，服务端在超时时间内没有收到确认就会按照退避策略进行重发，直到达到重试上限，调用方
+// 通过Future 获取最终的投递结果，这里保证的是至少一次投递，客户端需要根据id 自行去重
+
+const (
+	DefaultTimeout    = 3 * time.Second
+	DefaultMaxTimeout = 30 * time.Second
+	DefaultMaxRetry   = 5
+)
+
+var (
+	// the message is not acked after retry MaxRetry times
+	ErrRetryExceeded = errors.New("ack : retry limit exceeded")
+	// the tracker is closed before the message acked
+	ErrTrackerClosed = errors.New("ack : tracker is closed")
+)
+
+type Option struct {
+	Timeout    time.Duration // Timeout the time to wait ack of the first send
+	MaxTimeout time.Duration // MaxTimeout the wait time doubles after each retry , and not bigger than MaxTimeout
+	MaxRetry   int           // MaxRetry the max times of resend , 0 means never resend
+	Codec      Codec         // Codec the format of message and ack frame , default is prefix codec
+
+	// Wheel run the timers of retry , it is set by server , so the timers of all the messages
+	// share the wheel of server , the time.Timer is used when it is nil
+	Wheel *timewheel.Wheel
+}
+
+func DefaultOption() *Option {
+	return &Option{
+		Timeout:    DefaultTimeout,
+		MaxTimeout: DefaultMaxTimeout,
+		MaxRetry:   DefaultMaxRetry,
+		Codec:      PrefixCodec{},
+	}
+}
+
+// Codec wrap the outbound message with id , and parse the ack frame sent by client
+type Codec interface {
+	// Wrap put the id into the message , the result will be sent to client
+	Wrap(id string, data []byte) []byte
+
+	// Parse judge the frame sent by client is an ack frame or not , and return the id
+	Parse(data []byte) (id string, ok bool)
+}
+
+// PrefixCodec is the default codec , the message is "#ack:{id}\n{data}" and the client
+// should reply "#ack:{id}" after received the message
+type PrefixCodec struct{}
+
+var prefix = []byte("#ack:")
+
+func (PrefixCodec) Wrap(id string, data []byte) []byte {
+	res := make([]byte, 0, len(prefix)+len(id)+1+len(data))
+	res = append(res, prefix...)
+	res = append(res, id...)
+	res = append(res, '\n')
+	return append(res, data...)
+}
+
+func (PrefixCodec) Parse(data []byte) (string, bool) {
+	if !bytes.HasPrefix(data, prefix) {
+		return "", false
+	}
+	id := bytes.TrimSpace(data[len(prefix):])
+	if len(id) == 0 || bytes.IndexByte(id, '\n') >= 0 {
+		return "", false
+	}
+	return string(id), true
+}
+
+// Sender is the function to send the message to user , the error means the message is not
+// written to the connection , for example , the user is not online
+type Sender func(identification string, data []byte) error
+
+// Future is the result of message need ack
+type Future struct {
+	id             string
+	identification string
+	done           chan struct{}
+	err            error
+	attempts       atomic.Int32
+}
+
+// ID return the id of message
+func (f *Future) ID() string {
+	return f.id
+}
+
+// Done return a channel that is closed when the message is acked or failed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err return nil when the message is acked , it should be called after Done
+func (f *Future) Err() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// Attempts return the times of the message sent
+func (f *Future) Attempts() int {
+	return int(f.attempts.Load())
+}
+
+// Wait block until the message is acked , failed or the ctx is done
+func (f *Future) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopper is the timer of retry , both time.Timer and timewheel.Timer implement it
+type stopper interface {
+	Stop() bool
+}
+
+type entry struct {
+	future  *Future
+	data    []byte
+	timeout time.Duration
+	timer   stopper
+}
+
+// Tracker record the message waiting for ack , and resend the message when timeout
+type Tracker struct {
+	rw      sync.Mutex
+	pending map[string]*entry
+	seq     atomic.Uint64
+	closed  bool
+	sender  Sender
+	opt     *Option
+}
+
+func NewTracker(sender Sender, option *Option) *Tracker {
+	if option == nil {
+		option = DefaultOption()
+	}
+	if option.Codec == nil {
+		option.Codec = PrefixCodec{}
+	}
+	if option.Timeout <= 0 {
+		option.Timeout = DefaultTimeout
+	}
+	if option.MaxTimeout < option.Timeout {
+		option.MaxTimeout = option.Timeout
+	}
+	return &Tracker{
+		pending: map[string]*entry{},
+		sender:  sender,
+		opt:     option,
+	}
+}
+
+// Send assign an id to the message and send it to user , the message will be resent until
+// the ack arrived or retry limit exceeded
+func (t *Tracker) Send(identification string, data []byte) *Future {
+	id := strconv.FormatUint(t.seq.Inc(), 10)
+	f := &Future{id: id, identification: identification, done: make(chan struct{})}
+	e := &entry{future: f, data: t.opt.Codec.Wrap(id, data), timeout: t.opt.Timeout}
+	t.rw.Lock()
+	if t.closed {
+		t.rw.Unlock()
+		t.finish(e, ErrTrackerClosed)
+		return f
+	}
+	t.pending[id] = e
+	e.timer = t.afterFunc(e.timeout, func() { t.expire(id) })
+	t.rw.Unlock()
+	t.send(e)
+	return f
+}
+
+// Handle judge the frame is an ack or not , if it is , the message will be marked acked
+// and the frame should not be handled by others
+func (t *Tracker) Handle(identification string, data []byte) bool {
+	id, ok := t.opt.Codec.Parse(data)
+	if !ok {
+		return false
+	}
+	t.Ack(identification, id)
+	return true
+}
+
+// Ack mark the message acked , the ack from other user will be ignored
+func (t *Tracker) Ack(identification, id string) bool {
+	t.rw.Lock()
+	e, ok := t.pending[id]
+	if !ok || e.future.identification != identification {
+		t.rw.Unlock()
+		return false
+	}
+	delete(t.pending, id)
+	e.timer.Stop()
+	t.rw.Unlock()
+	t.finish(e, nil)
+	return true
+}
+
+// Pending return the number of message waiting for ack
+func (t *Tracker) Pending() int {
+	t.rw.Lock()
+	defer t.rw.Unlock()
+	return len(t.pending)
+}
+
+// Close stop all the retry , the message not acked will fail with ErrTrackerClosed
+func (t *Tracker) Close() {
+	t.rw.Lock()
+	t.closed = true
+	pending := t.pending
+	t.pending = map[string]*entry{}
+	t.rw.Unlock()
+	for _, e := range pending {
+		e.timer.Stop()
+		t.finish(e, ErrTrackerClosed)
+	}
+}
+
+func (t *Tracker) send(e *entry) {
+	e.future.attempts.Inc()
+	if err := t.sender(e.future.identification, e.data); err != nil {
+		// the user may be offline now , the message will be resent when timeout
+		logging.Log.Warn("ack send", zap.String("ID", e.future.identification), zap.String("MESSAGE_ID", e.future.id), zap.Error(err))
+	}
+}
+
+func (t *Tracker) expire(id string) {
+	t.rw.Lock()
+	e, ok := t.pending[id]
+	if !ok {
+		t.rw.Unlock()
+		return
+	}
+	if int(e.future.attempts.Load()) > t.opt.MaxRetry {
+		delete(t.pending, id)
+		t.rw.Unlock()
+		t.finish(e, ErrRetryExceeded)
+		return
+	}
+	// back off , the wait time doubles after each retry
+	e.timeout *= 2
+	if e.timeout > t.opt.MaxTimeout {
+		e.timeout = t.opt.MaxTimeout
+	}
+	e.timer = t.afterFunc(e.timeout, func() { t.expire(id) })
+	t.rw.Unlock()
+	// expire is run by the goroutine of wheel , it should not be blocked by the sender , which
+	// may wait for the room of connection
+	go t.send(e)
+}
+
+func (t *Tracker) afterFunc(d time.Duration, fn func()) stopper {
+	if t.opt.Wheel != nil {
+		return t.opt.Wheel.AfterFunc(d, fn)
+	}
+	return time.AfterFunc(d, fn)
+}
+
+func (t *Tracker) finish(e *entry, err error) {
+	e.future.err = err
+	close(e.future.done)
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ack
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/timewheel"
+	"go.uber.org/atomic"
+)
+
+type mockSender struct {
+	mu   sync.Mutex
+	sent map[string][][]byte
+}
+
+func (m *mockSender) send(identification string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sent == nil {
+		m.sent = map[string][][]byte{}
+	}
+	m.sent[identification] = append(m.sent[identification], data)
+	return nil
+}
+
+func (m *mockSender) count(identification string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sent[identification])
+}
+
+func testOption() *Option {
+	return &Option{Timeout: 10 * time.Millisecond, MaxTimeout: 20 * time.Millisecond, MaxRetry: 2}
+}
+
+func TestPrefixCodec(t *testing.T) {
+	codec := PrefixCodec{}
+	wrapped := codec.Wrap("12", []byte("hello"))
+	if string(wrapped) != "#ack:12\nhello" {
+		t.Fatalf("Wrap() = %q", wrapped)
+	}
+	if id, ok := codec.Parse([]byte("#ack:12")); !ok || id != "12" {
+		t.Fatalf("Parse() = %v , %v", id, ok)
+	}
+	if _, ok := codec.Parse(wrapped); ok {
+		t.Fatal("the message should not be parsed as ack")
+	}
+	if _, ok := codec.Parse([]byte("hello")); ok {
+		t.Fatal("the normal frame should not be parsed as ack")
+	}
+}
+
+func TestTracker_Ack(t *testing.T) {
+	sender := &mockSender{}
+	tracker := NewTracker(sender.send, testOption())
+	f := tracker.Send("steven", []byte("order paid"))
+	if tracker.Handle("mike", []byte("#ack:"+f.ID())) != true {
+		t.Fatal("the ack frame should be handled")
+	}
+	// the ack of other user should be ignored
+	select {
+	case <-f.Done():
+		t.Fatal("the message is acked by other user")
+	default:
+	}
+	if !tracker.Ack("steven", f.ID()) {
+		t.Fatal("Ack() should return true")
+	}
+	if err := f.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if tracker.Pending() != 0 {
+		t.Fatalf("Pending() = %v , want 0", tracker.Pending())
+	}
+}
+
+func TestTracker_Retry(t *testing.T) {
+	sender := &mockSender{}
+	tracker := NewTracker(sender.send, testOption())
+	f := tracker.Send("steven", []byte("order paid"))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := f.Wait(ctx); !errors.Is(err, ErrRetryExceeded) {
+		t.Fatalf("Wait() error = '%v', wantErr '%v'", err, ErrRetryExceeded)
+	}
+	// the first send and two retries
+	if sender.count("steven") != 3 || f.Attempts() != 3 {
+		t.Fatalf("sent %v times , attempts %v , want 3", sender.count("steven"), f.Attempts())
+	}
+}
+
+func TestTracker_Wheel(t *testing.T) {
+	sender := &mockSender{}
+	option := testOption()
+	// advance the wheel by the test only
+	option.Wheel = timewheel.New(time.Millisecond, 8)
+	tracker := NewTracker(sender.send, option)
+	tracker.Send("steven", []byte("order paid"))
+	for i := 0; i < 9; i++ {
+		option.Wheel.Advance()
+	}
+	if sender.count("steven") != 1 {
+		t.Fatalf("sent %v times before timeout , want 1", sender.count("steven"))
+	}
+	option.Wheel.Advance()
+	// the retry is sent in another goroutine
+	for i := 0; i < 100 && sender.count("steven") != 2; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if sender.count("steven") != 2 || tracker.Pending() != 1 {
+		t.Fatalf("sent %v times after timeout , pending %v , want 2 and 1", sender.count("steven"), tracker.Pending())
+	}
+	tracker.Close()
+	if option.Wheel.Len() != 0 {
+		t.Fatalf("Len() = %v after closed , want 0", option.Wheel.Len())
+	}
+}
+
+func TestTracker_WheelBlocked(t *testing.T) {
+	option := testOption()
+	option.Wheel = timewheel.New(time.Millisecond, 8)
+	// the first send returns , the retries are blocked until the test finished
+	unblock, sent := make(chan struct{}), atomic.NewInt32(0)
+	defer close(unblock)
+	tracker := NewTracker(func(identification string, data []byte) error {
+		if sent.Inc() > 1 {
+			<-unblock
+		}
+		return nil
+	}, option)
+	defer tracker.Close()
+	tracker.Send("steven", []byte("order paid"))
+	fired := make(chan struct{})
+	option.Wheel.AfterFunc(20*time.Millisecond, func() { close(fired) })
+	advanced := make(chan struct{})
+	go func() {
+		defer close(advanced)
+		for i := 0; i < 20; i++ {
+			option.Wheel.Advance()
+		}
+	}()
+	select {
+	case <-advanced:
+	case <-time.After(time.Second):
+		t.Fatal("the wheel is blocked by the retry")
+	}
+	select {
+	case <-fired:
+	default:
+		t.Fatal("the timer after the retry is not fired")
+	}
+}
+
+func TestTracker_Close(t *testing.T) {
+	sender := &mockSender{}
+	tracker := NewTracker(sender.send, DefaultOption())
+	f := tracker.Send("steven", []byte("order paid"))
+	tracker.Close()
+	if err := f.Wait(context.Background()); err != ErrTrackerClosed {
+		t.Fatalf("Wait() error = '%v', wantErr '%v'", err, ErrTrackerClosed)
+	}
+	if err := tracker.Send("steven", []byte("after close")).Err(); err != ErrTrackerClosed {
+		t.Fatalf("Send() after Close error = '%v', wantErr '%v'", err, ErrTrackerClosed)
+	}
+}
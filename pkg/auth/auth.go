@@ -0,0 +1,147 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// auth 是连接建立之前的认证，认证在websocket 升级之前完成，失败的请求直接返回 401 或者 403 ，不会产生
+// 升级的开销。认证成功得到一个 Principal ，其中的 Subject 作为用户的标识，Principal 会挂在连接上，
+// 业务可以通过服务端获取。带有过期时间的令牌到期之后连接会被断开，客户端需要在过期之前通过连接发送新
+// 的令牌进行续期，续期的令牌必须属于同一个用户
+
+const (
+	// DefaultQueryParam is the query parameter carrying the token , the browser can't set the
+	// header of websocket request
+	DefaultQueryParam   = "access_token"
+	DefaultSubjectClaim = "sub"
+	DefaultDeviceClaim  = "device"
+
+	// the close frame sent when the token is expired and not refreshed
+	ExpiredCloseCode   = websocket.ClosePolicyViolation
+	ExpiredCloseReason = "token expired"
+)
+
+var (
+	ErrTokenMissing     = errors.New("auth : token is missing")
+	ErrTokenInvalid     = errors.New("auth : token is invalid")
+	ErrTokenExpired     = errors.New("auth : token is expired")
+	ErrTokenNotValidYet = errors.New("auth : token is not valid yet")
+	// the token refreshed belongs to other user
+	ErrSubjectMismatch = errors.New("auth : the subject of token is mismatched")
+	ErrJWTParam        = errors.New("auth : jwt param is wrong err , the Secret or PublicKey must be set")
+)
+
+// Principal is the user authenticated , it is attached to the connection
+type Principal struct {
+	// Subject is the identification of user
+	Subject string
+	// Device is the device of connection , it is empty when the device is unknown
+	Device string
+	Claims map[string]interface{}
+	// ExpiresAt is the time when the token expired , the leeway of validator is included , the
+	// zero value means never expired
+	ExpiresAt time.Time
+}
+
+// Expired judge the principal is expired at the time
+func (p *Principal) Expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// Authenticator authenticate the request before the upgrade , the error is replied with the
+// status returned by StatusCode
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Validator validate the token , the Authenticator implements it can refresh the token in band
+type Validator interface {
+	Validate(token string) (*Principal, error)
+}
+
+type forbiddenError struct {
+	err error
+}
+
+func (e *forbiddenError) Error() string {
+	return e.err.Error()
+}
+
+func (e *forbiddenError) Unwrap() error {
+	return e.err
+}
+
+// Forbidden mark the error as forbidden , the user is authenticated but not allowed , so the
+// request is replied with 403
+func Forbidden(err error) error {
+	return &forbiddenError{err: err}
+}
+
+// StatusCode return the http status of the error returned by Authenticator , it is 403 for the
+// error marked by Forbidden and 401 for others
+func StatusCode(err error) int {
+	var forbidden *forbiddenError
+	if errors.As(err, &forbidden) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// TokenFromRequest return the bearer token of Authorization header , if the header is not set ,
+// the query parameter is used
+func TokenFromRequest(r *http.Request, queryParam string) string {
+	if header := r.Header.Get("Authorization"); len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	if queryParam == "" {
+		queryParam = DefaultQueryParam
+	}
+	return r.URL.Query().Get(queryParam)
+}
+
+// Refresh validate the token sent by the connection of principal , the new principal must have
+// the same subject
+func Refresh(validator Validator, principal *Principal, token string) (*Principal, error) {
+	res, err := validator.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+	if res.Subject != principal.Subject {
+		return nil, Forbidden(ErrSubjectMismatch)
+	}
+	return res, nil
+}
+
+var refreshPrefix = []byte("#auth:")
+
+// ParseRefresh judge the frame is the refresh frame or not , it is used when the envelope protocol
+// is turned off , the frame is "#auth:{token}"
+func ParseRefresh(data []byte) (string, bool) {
+	if !bytes.HasPrefix(data, refreshPrefix) {
+		return "", false
+	}
+	token := bytes.TrimSpace(data[len(refreshPrefix):])
+	if len(token) == 0 {
+		return "", false
+	}
+	return string(token), true
+}
@@ -0,0 +1,238 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Option is the option of JWT , the HS256 , HS384 and HS512 are verified by Secret , and the
+// RS256 , RS384 and RS512 are verified by PublicKey , the token of other algorithms is refused
+type Option struct {
+	Secret    []byte         // Secret the key of HMAC
+	PublicKey *rsa.PublicKey // PublicKey the key of RSA
+
+	Leeway   time.Duration // Leeway the clock skew allowed when checking exp and nbf
+	Issuer   string        // Issuer the iss claim must be equal to it when it is not empty
+	Audience string        // Audience the aud claim must contain it when it is not empty
+
+	SubjectClaim string // SubjectClaim the claim used as the identification , default is sub
+	DeviceClaim  string // DeviceClaim the claim used as the device , default is device
+	QueryParam   string // QueryParam the query parameter carrying the token , default is access_token
+
+	// Authorize check the principal after the token validated , the request is replied with 403
+	// when it returns error
+	Authorize func(principal *Principal) error
+}
+
+func DefaultOption() *Option {
+	return &Option{
+		SubjectClaim: DefaultSubjectClaim,
+		DeviceClaim:  DefaultDeviceClaim,
+		QueryParam:   DefaultQueryParam,
+	}
+}
+
+func (o *Option) fix() *Option {
+	res := *o
+	if res.SubjectClaim == "" {
+		res.SubjectClaim = DefaultSubjectClaim
+	}
+	if res.DeviceClaim == "" {
+		res.DeviceClaim = DefaultDeviceClaim
+	}
+	if res.QueryParam == "" {
+		res.QueryParam = DefaultQueryParam
+	}
+	return &res
+}
+
+var hashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+// JWT is the Authenticator and Validator of json web token
+type JWT struct {
+	opt *Option
+}
+
+func NewJWT(opt *Option) (*JWT, error) {
+	if opt == nil || (len(opt.Secret) == 0 && opt.PublicKey == nil) {
+		return nil, ErrJWTParam
+	}
+	return &JWT{opt: opt.fix()}, nil
+}
+
+// Authenticate validate the token of request , see TokenFromRequest
+func (j *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	token := TokenFromRequest(r, j.opt.QueryParam)
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+	return j.Validate(token)
+}
+
+// Validate verify the signature and the claims of token , and return the principal
+func (j *JWT) Validate(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if err := j.verify(header.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	principal, err := j.principal(claims)
+	if err != nil {
+		return nil, err
+	}
+	if j.opt.Authorize != nil {
+		if err := j.opt.Authorize(principal); err != nil {
+			return nil, Forbidden(err)
+		}
+	}
+	return principal, nil
+}
+
+// verify the signature by the key of algorithm , the algorithm must match the key , so the
+// public key of RSA can't be used as the secret of HMAC
+func (j *JWT) verify(alg, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("%w : unsupported algorithm %q", ErrTokenInvalid, alg)
+	}
+	hash, ok := hashes[alg[2:]]
+	if !ok {
+		return fmt.Errorf("%w : unsupported algorithm %q", ErrTokenInvalid, alg)
+	}
+	switch alg[:2] {
+	case "HS":
+		if len(j.opt.Secret) == 0 {
+			break
+		}
+		mac := hmac.New(hash.New, j.opt.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return ErrTokenInvalid
+		}
+		return nil
+	case "RS":
+		if j.opt.PublicKey == nil {
+			break
+		}
+		h := hash.New()
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(j.opt.PublicKey, hash, h.Sum(nil), signature); err != nil {
+			return ErrTokenInvalid
+		}
+		return nil
+	}
+	return fmt.Errorf("%w : unsupported algorithm %q", ErrTokenInvalid, alg)
+}
+
+// principal check the registered claims and create the principal
+func (j *JWT) principal(claims map[string]interface{}) (*Principal, error) {
+	now := time.Now()
+	res := &Principal{Claims: claims}
+	if exp, ok, err := timeClaim(claims, "exp"); err != nil {
+		return nil, err
+	} else if ok {
+		// the leeway is added to the ExpiresAt too , otherwise the token accepted in leeway will
+		// be expired by server right after the handshake
+		if !now.Before(exp.Add(j.opt.Leeway)) {
+			return nil, ErrTokenExpired
+		}
+		res.ExpiresAt = exp.Add(j.opt.Leeway)
+	}
+	if nbf, ok, err := timeClaim(claims, "nbf"); err != nil {
+		return nil, err
+	} else if ok && now.Add(j.opt.Leeway).Before(nbf) {
+		return nil, ErrTokenNotValidYet
+	}
+	if j.opt.Issuer != "" && claims["iss"] != j.opt.Issuer {
+		return nil, fmt.Errorf("%w : issuer mismatched", ErrTokenInvalid)
+	}
+	if j.opt.Audience != "" && !hasAudience(claims["aud"], j.opt.Audience) {
+		return nil, fmt.Errorf("%w : audience mismatched", ErrTokenInvalid)
+	}
+	res.Subject, _ = claims[j.opt.SubjectClaim].(string)
+	if res.Subject == "" {
+		return nil, fmt.Errorf("%w : claim %s is missing", ErrTokenInvalid, j.opt.SubjectClaim)
+	}
+	res.Device, _ = claims[j.opt.DeviceClaim].(string)
+	return res, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrTokenInvalid
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// timeClaim return the time of numeric date claim , it returns false when the claim is not set
+func timeClaim(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	sec, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w : claim %s is not a number", ErrTokenInvalid, name)
+	}
+	return time.Unix(int64(sec), 0), true, nil
+}
+
+// hasAudience judge the aud claim contains the audience , the claim is a string or an array
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, item := range v {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
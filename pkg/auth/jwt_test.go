@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var secret = []byte("steven")
+
+// sign create the token of claims , key is the secret of HMAC or the private key of RSA
+func sign(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(crypto.SHA256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := crypto.SHA256.New()
+		h.Write([]byte(signed))
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWT_Validate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	option := DefaultOption()
+	option.Secret, option.PublicKey, option.Issuer = secret, &key.PublicKey, "sim"
+	option.Authorize = func(principal *Principal) error {
+		if principal.Claims["banned"] == true {
+			return errors.New("the user is banned")
+		}
+		return nil
+	}
+	validator, err := NewJWT(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		res := map[string]interface{}{"sub": "steven", "iss": "sim", "device": "ios", "exp": now + 60}
+		for k, v := range extra {
+			res[k] = v
+		}
+		return res
+	}
+	tests := []struct {
+		name   string
+		token  string
+		err    error
+		status int
+	}{
+		{name: "hmac", token: sign(t, "HS256", secret, claims(nil))},
+		{name: "rsa", token: sign(t, "RS256", key, claims(nil))},
+		{name: "bad signature", token: sign(t, "HS256", []byte("mike"), claims(nil)), err: ErrTokenInvalid, status: http.StatusUnauthorized},
+		{name: "none algorithm", token: sign(t, "none", nil, claims(nil)), err: ErrTokenInvalid, status: http.StatusUnauthorized},
+		{name: "expired", token: sign(t, "HS256", secret, claims(map[string]interface{}{"exp": now - 1})), err: ErrTokenExpired, status: http.StatusUnauthorized},
+		{name: "not valid yet", token: sign(t, "HS256", secret, claims(map[string]interface{}{"nbf": now + 60})), err: ErrTokenNotValidYet, status: http.StatusUnauthorized},
+		{name: "issuer", token: sign(t, "HS256", secret, claims(map[string]interface{}{"iss": "other"})), err: ErrTokenInvalid, status: http.StatusUnauthorized},
+		{name: "no subject", token: sign(t, "HS256", secret, claims(map[string]interface{}{"sub": ""})), err: ErrTokenInvalid, status: http.StatusUnauthorized},
+		{name: "forbidden", token: sign(t, "HS256", secret, claims(map[string]interface{}{"banned": true})), status: http.StatusForbidden},
+		{name: "malformed", token: "steven", err: ErrTokenInvalid, status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := validator.Validate(tt.token)
+			if tt.status == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if principal.Subject != "steven" || principal.Device != "ios" || principal.ExpiresAt.Unix() != now+60 {
+					t.Fatalf("principal = %+v", principal)
+				}
+				return
+			}
+			if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+				t.Fatalf("Validate() error = '%v', wantErr '%v'", err, tt.err)
+			}
+			if StatusCode(err) != tt.status {
+				t.Fatalf("StatusCode() = %v , want %v", StatusCode(err), tt.status)
+			}
+		})
+	}
+}
+
+func TestJWT_Leeway(t *testing.T) {
+	validator, err := NewJWT(&Option{Secret: secret, Leeway: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Add(-time.Second).Unix()
+	principal, err := validator.Validate(sign(t, "HS256", secret, map[string]interface{}{"sub": "steven", "exp": exp}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the token accepted in leeway should not be expired at once
+	if principal.Expired(time.Now()) || principal.ExpiresAt.Unix() != exp+60 {
+		t.Fatalf("ExpiresAt = %v , want the exp with leeway", principal.ExpiresAt)
+	}
+}
+
+func TestJWT_Authenticate(t *testing.T) {
+	validator, err := NewJWT(&Option{Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, "HS256", secret, map[string]interface{}{"sub": "steven"})
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/conn?access_token="+token, nil),
+		func() *http.Request {
+			r := httptest.NewRequest("GET", "/conn", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			return r
+		}(),
+	} {
+		if principal, err := validator.Authenticate(r); err != nil || principal.Subject != "steven" {
+			t.Fatalf("Authenticate() = %v , %v", principal, err)
+		}
+	}
+	if _, err := validator.Authenticate(httptest.NewRequest("GET", "/conn", nil)); err != ErrTokenMissing {
+		t.Fatalf("Authenticate() error = '%v', wantErr '%v'", err, ErrTokenMissing)
+	}
+	if _, err := NewJWT(&Option{}); err != ErrJWTParam {
+		t.Fatalf("NewJWT() error = '%v', wantErr '%v'", err, ErrJWTParam)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	validator, _ := NewJWT(&Option{Secret: secret})
+	principal := &Principal{Subject: "steven"}
+	data := []byte("#auth:" + sign(t, "HS256", secret, map[string]interface{}{"sub": "mike"}))
+	token, ok := ParseRefresh(data)
+	if !ok {
+		t.Fatal("ParseRefresh() should parse the refresh frame")
+	}
+	if _, err := Refresh(validator, principal, token); !errors.Is(err, ErrSubjectMismatch) || StatusCode(err) != http.StatusForbidden {
+		t.Fatalf("Refresh() error = '%v', wantErr '%v'", err, ErrSubjectMismatch)
+	}
+	if _, ok := ParseRefresh([]byte("hello")); ok {
+		t.Fatal("ParseRefresh() should ignore the application message")
+	}
+}
@@ -129,6 +129,7 @@ func (s *Bench) CreateClient(identification string) error {
 		if err != nil {
 			s.closeMonitor <- identification
 			fmt.Printf("Connection_Read_Err : %v\r\n", err)
+			return err
 		}
 		s.allUserMessageCount.Inc()
 		if identification == s.oToken {
@@ -143,7 +144,6 @@ func (s *Bench) CreateClient(identification string) error {
 			}
 		}
 	}
-	return nil
 }
 
 func (s *Bench) monitor() {
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// cluster 是多节点部署时的路由层，每个节点在用户上线和下线的时候向Registry 上报用户所在的节点，
+// 发送消息的时候先通过Registry 找到用户所在的节点，本节点的用户直接投递，其他节点的用户通过
+// Forwarder 转发给对应节点，由对应节点进行本地投递，节点内部依旧使用cityhash 路由到bucket ，
+// 转发的消息不会被再次转发，所以不会出现环路
+
+const (
+	DefaultForwardTimeout = 3 * time.Second
+)
+
+var (
+	// the option of cluster is illegal
+	ErrNodeIsEmpty     = errors.New("cluster : the id and address of node can not be empty")
+	ErrRegistryIsNil   = errors.New("cluster : the registry is nil")
+	ErrNodeIsNotExist  = errors.New("cluster : the node is not existed")
+	ErrForwardRejected = errors.New("cluster : the forward request is rejected")
+)
+
+// Node is a sim server in cluster
+type Node struct {
+	// ID is the unique name of node in cluster
+	ID string `json:"id"`
+	// Addr is the base url of the cluster handler of node , for example
+	// "http://10.0.0.1:8081/cluster"
+	Addr string `json:"addr"`
+}
+
+// Registry record the nodes of cluster and the node that users are online on . A user may be
+// online on more than one node when the session policy allow multiple connections , the
+// Online and Offline are called for each connection , so the implement should count them .
+// You can implement it by redis , etcd and so on , NewMemoryRegistry is a stand-in for the
+// nodes in the same process
+type Registry interface {
+	// Join add the node to cluster , it is called when the server run
+	Join(node Node) error
+
+	// Leave remove the node and all the users on it from cluster , it is called when the
+	// server shutdown
+	Leave(node string) error
+
+	// Nodes return all the nodes in cluster
+	Nodes() ([]Node, error)
+
+	// Online record one connection of user is online on the node
+	Online(identification, node string) error
+
+	// Offline record one connection of user is offline on the node
+	Offline(identification, node string) error
+
+	// Lookup return the nodes that user is online on , it returns empty when the user is offline
+	Lookup(identification string) ([]string, error)
+}
+
+// Forwarder send the message to other node , if users is empty , the message will be broadcast
+// to all the users of the node , priority is the priority of message on the connections
+type Forwarder interface {
+	Forward(ctx context.Context, node Node, users []string, message []byte, priority int) error
+}
+
+type Option struct {
+	Node      Node          // Node is the current node
+	Registry  Registry      // Registry is the presence registry shared by nodes
+	Forwarder Forwarder     // Forwarder default is the http forwarder
+	Secret    string        // Secret is checked by the cluster handler if it is not empty
+	Timeout   time.Duration // Timeout the time limit of forwarding message to a node
+}
+
+// DefaultOption return the option with memory registry , it only works in single process
+func DefaultOption(node Node) *Option {
+	return &Option{
+		Node:     node,
+		Registry: NewMemoryRegistry(),
+		Timeout:  DefaultForwardTimeout,
+	}
+}
+
+// Validate check the option and set the default value
+func (o *Option) Validate() error {
+	if o.Node.ID == "" || o.Node.Addr == "" {
+		return ErrNodeIsEmpty
+	}
+	if o.Registry == nil {
+		return ErrRegistryIsNil
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultForwardTimeout
+	}
+	if o.Forwarder == nil {
+		o.Forwarder = NewHTTPForwarder(nil, o.Secret)
+	}
+	return nil
+}
+
+// Route split the users into local users and remote users grouped by node , the user who is
+// not online on any node is regarded as local user , so the message can be kept by the store
+// of current node
+func Route(registry Registry, self string, users []string) (local []string, remote map[string][]string, err error) {
+	remote = map[string][]string{}
+	for _, user := range users {
+		nodes, err := registry.Lookup(user)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(nodes) == 0 {
+			local = append(local, user)
+			continue
+		}
+		for _, node := range nodes {
+			if node == self {
+				local = append(local, user)
+				continue
+			}
+			remote[node] = append(remote[node], user)
+		}
+	}
+	return local, remote, nil
+}
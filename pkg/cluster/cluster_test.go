@@ -0,0 +1,101 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	r := NewMemoryRegistry()
+	for _, node := range []Node{{ID: "node_1", Addr: "http://node_1"}, {ID: "node_2", Addr: "http://node_2"}} {
+		if err := r.Join(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Online("steven", "node_3"); err != ErrNodeIsNotExist {
+		t.Fatalf("Online() error = '%v', wantErr '%v'", err, ErrNodeIsNotExist)
+	}
+	// steven have two connections on node_1 and one on node_2
+	r.Online("steven", "node_1")
+	r.Online("steven", "node_1")
+	r.Online("steven", "node_2")
+	r.Online("mike", "node_2")
+
+	r.Offline("steven", "node_1")
+	if nodes, _ := r.Lookup("steven"); !reflect.DeepEqual(nodes, []string{"node_1", "node_2"}) {
+		t.Fatalf("Lookup() got %v", nodes)
+	}
+	r.Offline("steven", "node_1")
+	if nodes, _ := r.Lookup("steven"); !reflect.DeepEqual(nodes, []string{"node_2"}) {
+		t.Fatalf("Lookup() got %v", nodes)
+	}
+	r.Leave("node_2")
+	for _, user := range []string{"steven", "mike"} {
+		if nodes, _ := r.Lookup(user); len(nodes) != 0 {
+			t.Fatalf("the users of node_2 should be removed when leave , got %v", nodes)
+		}
+	}
+	if nodes, _ := r.Nodes(); len(nodes) != 1 || nodes[0].ID != "node_1" {
+		t.Fatalf("Nodes() got %v", nodes)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	r := NewMemoryRegistry()
+	r.Join(Node{ID: "node_1", Addr: "http://node_1"})
+	r.Join(Node{ID: "node_2", Addr: "http://node_2"})
+	r.Online("steven", "node_1")
+	r.Online("steven", "node_2")
+	r.Online("mike", "node_2")
+
+	local, remote, err := Route(r, "node_1", []string{"steven", "mike", "jack"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(local, []string{"steven", "jack"}) {
+		t.Fatalf("local got %v", local)
+	}
+	if !reflect.DeepEqual(remote, map[string][]string{"node_2": {"steven", "mike"}}) {
+		t.Fatalf("remote got %v", remote)
+	}
+}
+
+func TestForward(t *testing.T) {
+	var (
+		gotUsers    []string
+		gotMessage  []byte
+		gotPriority int
+	)
+	server := httptest.NewServer(Handler("secret", func(users []string, message []byte, priority int) error {
+		gotUsers, gotMessage, gotPriority = users, message, priority
+		return nil
+	}))
+	defer server.Close()
+	node := Node{ID: "node_2", Addr: server.URL + "/"}
+
+	if err := NewHTTPForwarder(nil, "wrong").Forward(context.Background(), node, []string{"steven"}, []byte("hello"), 0); !errors.Is(err, ErrForwardRejected) {
+		t.Fatalf("Forward() error = '%v', wantErr '%v'", err, ErrForwardRejected)
+	}
+	if err := NewHTTPForwarder(nil, "secret").Forward(context.Background(), node, []string{"steven"}, []byte("hello"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotUsers, []string{"steven"}) || string(gotMessage) != "hello" || gotPriority != 1 {
+		t.Fatalf("the handler got users %v , message %v , priority %v", gotUsers, string(gotMessage), gotPriority)
+	}
+}
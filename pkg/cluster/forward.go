@@ -0,0 +1,115 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// 节点之间的转发协议基于http ，请求为 POST {Addr}/forward ，body 为json 格式的 forwardRequest ，
+// 如果设置了Secret 那么会通过 SecretHeader 传递，接收方校验不通过返回 403
+
+const (
+	// ForwardPath is the path of forward request
+	ForwardPath = "/forward"
+	// SecretHeader carry the secret of cluster
+	SecretHeader = "X-Sim-Cluster-Secret"
+
+	// the max size of forward request
+	maxForwardBody = 4 << 20
+)
+
+type forwardRequest struct {
+	// Users is the receiver of message , empty means broadcast
+	Users   []string `json:"users,omitempty"`
+	Message []byte   `json:"message"`
+	// Priority is the priority of message , zero is the normal priority
+	Priority int `json:"priority,omitempty"`
+}
+
+type httpForwarder struct {
+	client *http.Client
+	secret string
+}
+
+// NewHTTPForwarder return the default forwarder , the http.DefaultClient is used when client is nil
+func NewHTTPForwarder(client *http.Client, secret string) Forwarder {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpForwarder{client: client, secret: secret}
+}
+
+func (h *httpForwarder) Forward(ctx context.Context, node Node, users []string, message []byte, priority int) error {
+	body, err := json.Marshal(forwardRequest{Users: users, Message: message, Priority: priority})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(node.Addr, "/")+ForwardPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if h.secret != "" {
+		req.Header.Set(SecretHeader, h.secret)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w : node %v , status %v", ErrForwardRejected, node.ID, resp.StatusCode)
+	}
+	return nil
+}
+
+// Deliver send the forwarded message to the users of current node , if users is empty the
+// message should be broadcast to all the users of current node
+type Deliver func(users []string, message []byte, priority int) error
+
+// Handler return the handler to receive the message forwarded by other nodes , the handler
+// should be mounted on the Addr of node
+func Handler(secret string, deliver Deliver) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ForwardPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if secret != "" && r.Header.Get(SecretHeader) != secret {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var req forwardRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxForwardBody)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := deliver(req.Users, req.Message, req.Priority); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
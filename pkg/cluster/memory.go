@@ -0,0 +1,111 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryRegistry keep the presence in memory , it can be shared by the servers in the same
+// process , it is useful for test and local development
+type memoryRegistry struct {
+	rw    sync.RWMutex
+	nodes map[string]Node
+	// users is identification -> node -> the number of connections
+	users map[string]map[string]int
+}
+
+func NewMemoryRegistry() Registry {
+	return &memoryRegistry{
+		nodes: map[string]Node{},
+		users: map[string]map[string]int{},
+	}
+}
+
+func (m *memoryRegistry) Join(node Node) error {
+	if node.ID == "" || node.Addr == "" {
+		return ErrNodeIsEmpty
+	}
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	m.nodes[node.ID] = node
+	return nil
+}
+
+func (m *memoryRegistry) Leave(node string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	delete(m.nodes, node)
+	for identification, nodes := range m.users {
+		delete(nodes, node)
+		if len(nodes) == 0 {
+			delete(m.users, identification)
+		}
+	}
+	return nil
+}
+
+func (m *memoryRegistry) Nodes() ([]Node, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+	res := make([]Node, 0, len(m.nodes))
+	for _, node := range m.nodes {
+		res = append(res, node)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+func (m *memoryRegistry) Online(identification, node string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	if _, ok := m.nodes[node]; !ok {
+		return ErrNodeIsNotExist
+	}
+	nodes, ok := m.users[identification]
+	if !ok {
+		nodes = map[string]int{}
+		m.users[identification] = nodes
+	}
+	nodes[node]++
+	return nil
+}
+
+func (m *memoryRegistry) Offline(identification, node string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	nodes, ok := m.users[identification]
+	if !ok || nodes[node] == 0 {
+		return nil
+	}
+	if nodes[node]--; nodes[node] == 0 {
+		delete(nodes, node)
+	}
+	if len(nodes) == 0 {
+		delete(m.users, identification)
+	}
+	return nil
+}
+
+func (m *memoryRegistry) Lookup(identification string) ([]string, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+	res := make([]string, 0, len(m.users[identification]))
+	for node := range m.users[identification] {
+		res = append(res, node)
+	}
+	sort.Strings(res)
+	return res, nil
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"sync"
+)
+
+// the attributes set by server when the connection upgraded
+const (
+	AttrTransport  = "transport"   // AttrTransport the name of transport , for example websocket
+	AttrRemoteAddr = "remote_addr" // AttrRemoteAddr the address of client , the proxy may be honored
+	AttrUserAgent  = "user_agent"  // AttrUserAgent the User-Agent header of request
+	AttrDevice     = "device"      // AttrDevice the device returned by identification
+)
+
+// AttributeSet is the metadata of connection , such as the address , the app version and the
+// tenant , it implements the attribute methods of Connect , so the implement of Connect can
+// embed it . The zero value is ready to use
+type AttributeSet struct {
+	attrLock sync.RWMutex
+	attrs    map[string]interface{}
+}
+
+func (a *AttributeSet) SetAttribute(key string, value interface{}) {
+	a.attrLock.Lock()
+	defer a.attrLock.Unlock()
+	if a.attrs == nil {
+		a.attrs = map[string]interface{}{}
+	}
+	a.attrs[key] = value
+}
+
+func (a *AttributeSet) Attribute(key string) (interface{}, bool) {
+	a.attrLock.RLock()
+	defer a.attrLock.RUnlock()
+	value, ok := a.attrs[key]
+	return value, ok
+}
+
+func (a *AttributeSet) Attributes() map[string]interface{} {
+	a.attrLock.RLock()
+	defer a.attrLock.RUnlock()
+	res := make(map[string]interface{}, len(a.attrs))
+	for key, value := range a.attrs {
+		res[key] = value
+	}
+	return res
+}
+
+// StringAttribute return the attribute of connection as string , it is empty when the attribute
+// is not set or not a string
+func StringAttribute(cli Connect, key string) string {
+	value, _ := cli.Attribute(key)
+	res, _ := value.(string)
+	return res
+}
+
+// IntAttribute return the attribute of connection as int , it returns false when the attribute
+// is not set or not an int
+func IntAttribute(cli Connect, key string) (int, bool) {
+	value, _ := cli.Attribute(key)
+	res, ok := value.(int)
+	return res, ok
+}
@@ -0,0 +1,28 @@
+package conn
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestAttributeSet(t *testing.T) {
+	cli := &conn{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cli.SetAttribute("worker_"+strconv.Itoa(i), i)
+			cli.Attributes()
+		}(i)
+	}
+	wg.Wait()
+	if value, ok := IntAttribute(cli, "worker_3"); !ok || value != 3 {
+		t.Fatalf("IntAttribute() = %v , %v , want 3", value, ok)
+	}
+	cli.SetAttribute(AttrDevice, 1)
+	if StringAttribute(cli, AttrDevice) != "" || len(cli.Attributes()) != 9 {
+		t.Fatalf("Attributes() = %v", cli.Attributes())
+	}
+}
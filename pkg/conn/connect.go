@@ -13,16 +13,28 @@
 
 package conn
 
+import (
+	"context"
+
+	"github.com/mongofs/sim/pkg/label"
+)
+
 //  Connect 目前暂时支持 "github.com/gorilla/websocket" ，后续笔者打算编写一个基于epoll+EL的一个
 //  网络模型，目前初步名称称为snetpoll，将websocket支持该模型，不过当下来说gorilla的包还是一个非常不错
 //  的选择，所以目前所有调用都抽象出来，后续可能增加底层扩展支持，目前只用到gorilla的基础方法,后续增加或者
 //  切换底层支持的话会重新发版，也考虑通过参数控制让用户自行选择实现
+//  现在协议相关的读写已经抽象为 Wire ，目前有 websocket 和长度前缀的 tcp 两种实现，不同协议的连接
+//  共用同一套缓冲、心跳以及标签逻辑
 
 type Connect interface {
 
 	// the connection
 	Identification() string
 
+	// Device return the device of connection , a user may have connections on different
+	// devices at the same time , it is empty when the device is unknown
+	Device() string
+
 	Send(data []byte) error
 
 	Close(reason string)
@@ -31,4 +43,107 @@ type Connect interface {
 
 	GetLastHeartBeatTime() int64
 
+	// Shutdown stop receiving new message , flush the message in buffer and send the close
+	// frame with code and reason to client , it returns nil when the connection is drained ,
+	// returns the error of ctx when the connection is force closed , and returns
+	// ErrConnectionIsClosed when the connection is closed by other reason before drained
+	Shutdown(ctx context.Context, code int, reason string) error
+
+	// HaveTags judge the connection have all the tags or not , it makes the connection
+	// implement label.Client , so the connection can be added to label manager
+	HaveTags(tags []string) bool
+
+	// SetTag store the tag and the handler returned by label manager , the handler is used
+	// to delete the connection from the label when the tag is removed
+	SetTag(tag string, fc label.ForClient)
+
+	// DelTag remove the tag from the connection and return the handler stored by SetTag
+	DelTag(tag string) (label.ForClient, bool)
+
+	// Tags return all the tags of the connection
+	Tags() []string
+
+	// SetAttribute store the metadata of connection , the attributes collected from the request
+	// are set before the connection registered , the value should not be changed after set
+	SetAttribute(key string, value interface{})
+
+	// Attribute return the metadata of connection , see StringAttribute for the typed value
+	Attribute(key string) (interface{}, bool)
+
+	// Attributes return the copy of all the attributes
+	Attributes() map[string]interface{}
+}
+
+// Priority is the priority of message , the message of high priority is written before the
+// normal messages , and it is never dropped by the backpressure
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityHigh
+)
+
+func (p Priority) String() string {
+	if p == PriorityHigh {
+		return "high"
+	}
+	return "normal"
+}
+
+// PrioritySender is implemented by the connection which has priority lanes
+type PrioritySender interface {
+	SendPriority(data []byte, priority Priority) error
+}
+
+// SendWithPriority send the message by priority , if the connection not implement PrioritySender ,
+// the message is sent by Send
+func SendWithPriority(cli Connect, data []byte, priority Priority) error {
+	if sender, ok := cli.(PrioritySender); ok {
+		return sender.SendPriority(data, priority)
+	}
+	return cli.Send(data)
+}
+
+// BlockSender is implemented by the connection which can wait for the room of send queue
+type BlockSender interface {
+	SendBlock(data []byte) error
+}
+
+// SendWithBlock send the message and wait for the room of send queue when the policy is
+// BackpressureBlock , it should only be called without the lock of bucket . If the connection
+// not implement BlockSender , the message is sent by Send
+func SendWithBlock(cli Connect, data []byte) error {
+	if sender, ok := cli.(BlockSender); ok {
+		return sender.SendBlock(data)
+	}
+	return cli.Send(data)
+}
+
+// Wire is the network connection of a transport which has finished the handshake , it reads
+// and writes a whole message each time , so the connection can work on different protocols ,
+// for example the websocket and the length-prefixed tcp
+type Wire interface {
+	ReadMessage() ([]byte, error)
+
+	WriteMessage(data []byte) error
+
+	// WriteClose send the close frame with code and reason to client , the protocol which
+	// has no close frame can do nothing
+	WriteClose(code int, reason string) error
+
+	Close() error
+}
+
+// PingWire is the Wire which support the ping of protocol , the connection send the ping in the
+// interval of Option.PingInterval , and the heartbeat is refreshed when the pong received . The
+// transport without ping should refresh the heartbeat by application message
+type PingWire interface {
+	Wire
+
+	// WritePing send the ping frame , it can be called during WriteMessage
+	WritePing() error
+
+	// OnControl set the handler called when the control frame such as pong or ping received ,
+	// it is called in the goroutine of ReadMessage
+	OnControl(handler func())
 }
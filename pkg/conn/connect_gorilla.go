@@ -13,208 +13,106 @@
 package conn
 
 import (
-	"go.uber.org/atomic"
-	"go.uber.org/zap"
+	"net"
 	"net/http"
-	"sync"
 	"time"
 
-	"github.com/mongofs/sim/pkg/logging"
-
 	"github.com/gorilla/websocket"
-	"github.com/pkg/errors"
-)
-
-const (
-	StatusConnectionClosed = iota + 1
-	StatusConnectionRunning
-)
-
-var (
-	ErrConnectionIsClosed = errors.New("connection is closed")
-	ErrConnectionIsWeak   = errors.New("connection is in weak status")
 )
 
-// This connection is upgrade of  github.com/gorilla/websocket
-type conn struct {
-	once           sync.Once
-	con            *websocket.Conn
-	identification string
-	// buffer 这里是用户进行设置缓冲区的，这里和websocket的缓冲区不同的是，这里的内容是单独
-	// 按照消息个数来缓冲的，而websocket是基于tcp的缓冲区进行字节数组缓冲，本质是不同
-	// 的概念，值得注意的是，slice是指针类型，意味着传输的内容是可以很大的，在chan层
-	// 表示仅仅是8字节的指针，建议单个传输内容不要太大，否则在用户下发的过程中如果用户网络
-	// 不是很好，TCP连接写入能力较差，内容都会堆积在内存中导致内存上涨，这个参数也建议不要
-	// 设置太大，建议在8个
-	buffer chan []byte
-
-	// heartBeatTime 这里是唯一一个伴随业务性质的1结构，值得注意的是，在我们实际应用场景中
-	// 这里会容易出错，如果我将连接本身close掉，然后将连接标示放入closeChan，此时
-	// 如果通道阻塞，本次连接的用户拿着同样的token进行连接，那么就会出现新的
-	// 连接在bucket不存在的情况，建议做法是：最后在客户端能保证，每次发起连接
-	// 都是一个全新的token，这样就能完全隔离掉这种情况
-	// 由于本身业务复杂性，客户端某些功能不能实现，那么就只能采取：建立连接在
-	// 之前先查后写，目前默认采取这种方案，但是又会伴随另外一个问题： 如果旧链接
-	// 依旧在线，那么就得发送信号释放old conn ，整体性能就会降低
-	// 针对第二种，我们踩过坑： 前台调用接口进入具体聊天室，聊天室内用户一直停留
-	// 用户连接死掉或者被客观下线，前台发起重连，然后旧的连接下线新的链接收不到消息
-	heartBeatTime int64
-
-	// closeChan 是一个上层传入的一个chan，当用户连接关闭，可以将本身token传入closeChan
-	// 通知到bucket层以及其他层进行处理，但是bucket作为connect管理单元，在做上层channel监听
-	// 的时候尽量不要读取closeChan
-
-	notify chan<- string
-
-	status int
+// the time limit of write the close frame and ping frame to client
+const closeFrameWriteWait = time.Second
 
-	// closeChan
-	closeChan   chan struct{}
-	messageType MessageType // text /binary
-}
-
-type Receive func(conn Connect, data []byte)
-
-func NewConn(Id string, sig chan<- string, w http.ResponseWriter, r *http.Request, Receive Receive) (Connect, error) {
-	result := &conn{
-		once:           sync.Once{},
-		identification: Id,
-		buffer:         make(chan []byte, userOption.Buffer),
-		heartBeatTime:  time.Now().Unix(),
-		notify:         sig,
-		closeChan:      make(chan struct{}),
-		messageType:    userOption.MessageType,
+// NewConn upgrade the http request to websocket connection , the option is the connection
+// option of server , if the option is nil , the option set by SetOption will be used . The
+// connection will be sent to sig when it is closed
+func NewConn(Id, device string, sig chan<- Connect, w http.ResponseWriter, r *http.Request, Receive Receive, option *Option) (Connect, error) {
+	if option == nil {
+		option = userOption
 	}
-	err := result.upgrade(w, r, userOption.ConnectionReadBuffer, userOption.ConnectionWriteBuffer)
+	con, err := upgrade(w, r, option)
 	if err != nil {
 		return nil, err
 	}
-	result.status = StatusConnectionRunning
-	go result.monitorSend()
-	go result.monitorReceive(Receive)
-	return result, nil
-}
-
-func (c *conn) Identification() string {
-	return c.identification
-}
-
-func (c *conn) Send(data []byte) error {
-	if c.status != StatusConnectionRunning {
-		// judge the status of connection
-		return ErrConnectionIsClosed
-	}
-	if len(c.buffer)*10 > cap(c.buffer)*7 {
-		sendLoseContent.Inc()
-		// judge the Send channel first
-		return ErrConnectionIsWeak
+	res := NewWireConn(Id, device, NewGorillaWire(con, option.MessageType), sig, Receive, option)
+	if protocol := con.Subprotocol(); protocol != "" {
+		res.SetAttribute(AttrSubprotocol, protocol)
 	}
-	c.buffer <- data
-	return nil
+	return res, nil
 }
 
-func (c *conn) Close(reason string) {
-	c.close(reason)
+// gorillaWire is the wire of github.com/gorilla/websocket
+type gorillaWire struct {
+	con         *websocket.Conn
+	messageType MessageType // text /binary
 }
 
-func (c *conn) ReFlushHeartBeatTime() {
-	c.heartBeatTime = time.Now().Unix()
+// NewGorillaWire wrap the websocket connection as Wire , the message is written in messageType
+func NewGorillaWire(con *websocket.Conn, messageType MessageType) Wire {
+	return &gorillaWire{con: con, messageType: messageType}
 }
 
-func (c *conn) GetLastHeartBeatTime() int64 {
-	return c.heartBeatTime
+func (g *gorillaWire) ReadMessage() ([]byte, error) {
+	_, data, err := g.con.ReadMessage()
+	return data, err
 }
 
-var (
-	sendContent       *atomic.Int64 = &atomic.Int64{}
-	sendContentLength *atomic.Int64 = &atomic.Int64{}
-	sendLoseContent   *atomic.Int64 = &atomic.Int64{}
-)
-
-func SwapSendData() (content, loseContent, contentLength int64) {
-	content = sendContent.Swap(0)
-	loseContent = sendLoseContent.Swap(0)
-	contentLength = sendContentLength.Swap(0)
-	return
+func (g *gorillaWire) WriteMessage(data []byte) error {
+	return g.con.WriteMessage(int(g.messageType), data)
 }
 
-func (c *conn) monitorSend() {
-	defer func() {
-		if err := recover(); err != nil {
-			logging.Log.Error("monitorSend", zap.Any("PANIC", err))
-		}
-	}()
-	for {
-		select {
-		case <-c.closeChan:
-			goto loop
-		case data := <-c.buffer:
-			startTime := time.Now()
-			err := c.con.WriteMessage(int(c.messageType), data)
-			if err != nil {
-				logging.Log.Warn("monitorSend", zap.Error(err))
-				goto loop
-			}
-			spendTime := time.Since(startTime)
-			if spendTime > time.Duration(2)*time.Second {
-				logging.Log.Warn("monitorSend weak net ", zap.String("ID", c.identification), zap.Any("WEAK_NET", spendTime))
-			}
-			sendContent.Inc()
-			sendContentLength.Add(int64(len(data)))
-		}
-	}
-loop:
-	c.close("monitorSend")
+func (g *gorillaWire) WriteClose(code int, reason string) error {
+	message := websocket.FormatCloseMessage(code, reason)
+	return g.con.WriteControl(websocket.CloseMessage, message, time.Now().Add(closeFrameWriteWait))
 }
 
-func (c *conn) monitorReceive(handleReceive Receive) {
-	defer func() {
-		if err := recover(); err != nil {
-			logging.Log.Error("monitorReceive ", zap.Any("panic", err))
-		}
-	}()
-	var temErr error
-	for {
-		_, data, err := c.con.ReadMessage()
-		if err != nil {
-			temErr = err
-			goto loop
-		}
-		handleReceive(c, data)
-	}
-loop:
-	c.close("monitorReceive", temErr)
+// WritePing is safe to call with WriteMessage , the control frame is written by WriteControl
+func (g *gorillaWire) WritePing() error {
+	return g.con.WriteControl(websocket.PingMessage, nil, time.Now().Add(closeFrameWriteWait))
 }
 
-func (c *conn) close(cause string, err ...error) {
-	c.once.Do(func() {
-		c.status = StatusConnectionClosed
-		c.notify <- c.Identification()
-		if len(err) > 0 {
-			if err[0] != nil {
-				// todo
-				//logging.Log.Error("close ", zap.String("ID",c.identification),zap.Error(err[0]))
-			}
-		}
-		close(c.closeChan)
-		if err := c.con.Close(); err != nil {
-			logging.Log.Error("close ", zap.String("ID", c.identification), zap.Error(err))
+func (g *gorillaWire) OnControl(handler func()) {
+	g.con.SetPongHandler(func(string) error {
+		handler()
+		return nil
+	})
+	g.con.SetPingHandler(func(data string) error {
+		handler()
+		// the same as the default ping handler of gorilla
+		err := g.con.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(closeFrameWriteWait))
+		if err == websocket.ErrCloseSent {
+			return nil
+		} else if e, ok := err.(net.Error); ok && e.Temporary() {
+			return nil
 		}
-		logging.Log.Info("close", zap.String("ID", c.identification), zap.String("OFFLINE_CAUSE", cause))
+		return err
 	})
 }
 
-func (c *conn) upgrade(w http.ResponseWriter, r *http.Request, readerSize, writeSize int) error {
-	conn, err := (&websocket.Upgrader{
+func (g *gorillaWire) Close() error {
+	return g.con.Close()
+}
+
+// upgrade finish the handshake by the policy of option , the origin is checked before the gorilla
+// upgrader , so the request refused by origin can be told from the bad handshake
+func upgrade(w http.ResponseWriter, r *http.Request, option *Option) (*websocket.Conn, error) {
+	upgrader := &websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
 			return true
 		},
-		ReadBufferSize:  readerSize,
-		WriteBufferSize: writeSize,
-	}).Upgrade(w, r, nil)
+		ReadBufferSize:  option.ConnectionReadBuffer,
+		WriteBufferSize: option.ConnectionWriteBuffer,
+	}
+	h := option.HandshakePolicy()
+	if !h.AllowOrigin(r) {
+		http.Error(w, ErrOriginNotAllowed.Error(), http.StatusForbidden)
+		return nil, ErrOriginNotAllowed
+	}
+	upgrader.Subprotocols = h.Subprotocols
+	upgrader.HandshakeTimeout = h.Timeout
+	conn, err := upgrader.Upgrade(w, r, h.Header)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	c.con = conn
-	return nil
+	return conn, nil
 }
@@ -0,0 +1,51 @@
+package conn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestConn_Ping(t *testing.T) {
+	option := DefaultOption()
+	option.PingInterval = 10 * time.Millisecond
+	conns := make(chan Connect, 1)
+	sig := make(chan Connect, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cli, err := NewConn("steven", "", sig, w, r, func(Connect, []byte) {}, option)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- cli
+	}))
+	defer ts.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	cli := <-conns
+	defer cli.Close("test")
+
+	ping := make(chan struct{}, 16)
+	client.SetPingHandler(func(data string) error {
+		ping <- struct{}{}
+		return client.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go client.ReadMessage()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ping:
+		case <-time.After(time.Second):
+			t.Fatal("ping is not received")
+		}
+	}
+	if time.Now().Unix()-cli.GetLastHeartBeatTime() > 1 {
+		t.Fatal("the heartbeat should be refreshed by pong")
+	}
+}
@@ -0,0 +1,327 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package conn
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+
+	"github.com/mongofs/sim/pkg/logging"
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+)
+
+const (
+	StatusConnectionClosed = iota + 1
+	StatusConnectionRunning
+	StatusConnectionDraining
+)
+
+var (
+	ErrConnectionIsClosed = errors.New("connection is closed")
+	ErrConnectionIsWeak   = errors.New("connection is in weak status")
+)
+
+// conn is the connection of all transports , it buffers the message to send and watches the
+// wire in goroutines , the protocol detail is hidden in Wire
+type conn struct {
+	once           sync.Once
+	wire           Wire
+	identification string
+	device         string
+	// buffer 这里是用户进行设置缓冲区的，这里和websocket的缓冲区不同的是，这里的内容是单独
+	// 按照消息个数来缓冲的，而websocket是基于tcp的缓冲区进行字节数组缓冲，本质是不同
+	// 的概念，值得注意的是，slice是指针类型，意味着传输的内容是可以很大的，在chan层
+	// 表示仅仅是8字节的指针，建议单个传输内容不要太大，否则在用户下发的过程中如果用户网络
+	// 不是很好，TCP连接写入能力较差，内容都会堆积在内存中导致内存上涨，这个参数也建议不要
+	// 设置太大，建议在8个 ；缓冲区满了之后的处理方式由 Option.Backpressure 决定
+	queue *Queue
+
+	// Heartbeat 这里是唯一一个伴随业务性质的1结构，值得注意的是，在我们实际应用场景中
+	// 这里会容易出错，如果我将连接本身close掉，然后将连接标示放入closeChan，此时
+	// 如果通道阻塞，本次连接的用户拿着同样的token进行连接，那么就会出现新的
+	// 连接在bucket不存在的情况，建议做法是：最后在客户端能保证，每次发起连接
+	// 都是一个全新的token，这样就能完全隔离掉这种情况
+	// 由于本身业务复杂性，客户端某些功能不能实现，那么就只能采取：建立连接在
+	// 之前先查后写，目前默认采取这种方案，但是又会伴随另外一个问题： 如果旧链接
+	// 依旧在线，那么就得发送信号释放old conn ，整体性能就会降低
+	// 针对第二种，我们踩过坑： 前台调用接口进入具体聊天室，聊天室内用户一直停留
+	// 用户连接死掉或者被客观下线，前台发起重连，然后旧的连接下线新的链接收不到消息
+	Heartbeat
+
+	// closeChan 是一个上层传入的一个chan，当用户连接关闭，可以将本身token传入closeChan
+	// 通知到bucket层以及其他层进行处理，但是bucket作为connect管理单元，在做上层channel监听
+	// 的时候尽量不要读取closeChan
+
+	notify chan<- Connect
+	// done is closed when the server is shut down , the notify is given up after it
+	done <-chan struct{}
+
+	status atomic.Int32
+
+	// closeChan
+	closeChan chan struct{}
+
+	// drain is closed when Shutdown is called , monitorSend will flush the buffer and close
+	// the drained after the close frame is written
+	drainOnce      sync.Once
+	drain, drained chan struct{}
+	closeCode      int
+	closeReason    string
+
+	// tags of the connection , the value is the handler to delete connection from label
+	TagSet
+	// attributes of the connection , they are set by server before registered
+	AttributeSet
+
+	metrics *Metrics
+
+	// pinging is true when the ping of protocol is turned on , the heartbeat is refreshed by the
+	// connection itself
+	pinging bool
+}
+
+type Receive func(conn Connect, data []byte)
+
+// NewWireConn create the connection on the wire which has finished the handshake , the
+// option is the connection option of server , if the option is nil , the option set by
+// SetOption will be used . The connection will be sent to sig when it is closed
+func NewWireConn(Id, device string, wire Wire, sig chan<- Connect, Receive Receive, option *Option) Connect {
+	if option == nil {
+		option = userOption
+	}
+	result := &conn{
+		once:           sync.Once{},
+		wire:           wire,
+		identification: Id,
+		device:         device,
+		notify:         sig,
+		done:           option.Done,
+		closeChan:      make(chan struct{}),
+		drain:          make(chan struct{}),
+		drained:        make(chan struct{}),
+		metrics:        option.Metrics,
+	}
+	result.status.Store(StatusConnectionRunning)
+	result.queue = NewQueue(option.Buffer, option.Backpressure, option.Metrics, result.trigger(option.Backpressure))
+	result.ReFlushHeartBeatTime()
+	pinger, ok := wire.(PingWire)
+	if ok && option.PingInterval > 0 {
+		result.pinging = true
+		pinger.OnControl(result.ReFlushHeartBeatTime)
+		go result.monitorPing(pinger, option.PingInterval)
+	}
+	go result.monitorSend()
+	go result.monitorReceive(Receive)
+	return result
+}
+
+func (c *conn) Identification() string {
+	return c.identification
+}
+
+func (c *conn) Device() string {
+	return c.device
+}
+
+func (c *conn) Send(data []byte) error {
+	return c.SendPriority(data, PriorityNormal)
+}
+
+// SendPriority put the message to the lane of priority , the message of high priority is
+// written before the normal messages and never dropped by the backpressure policy
+func (c *conn) SendPriority(data []byte, priority Priority) error {
+	if c.status.Load() != StatusConnectionRunning {
+		// judge the status of connection
+		return ErrConnectionIsClosed
+	}
+	return c.pushed(c.queue.PushPriority(data, priority))
+}
+
+// SendBlock is the Send waiting for the room of buffer when the policy is BackpressureBlock
+func (c *conn) SendBlock(data []byte) error {
+	if c.status.Load() != StatusConnectionRunning {
+		return ErrConnectionIsClosed
+	}
+	return c.pushed(c.queue.PushBlock(data))
+}
+
+// pushed handle the error of pushing message to the buffer
+func (c *conn) pushed(err error) error {
+	if err == ErrConnectionIsSlow {
+		// the Send is called by bucket , so the connection is closed in another goroutine
+		go c.close("slow consumer")
+	}
+	return err
+}
+
+// trigger return the function called when the backpressure policy triggered
+func (c *conn) trigger(bp *Backpressure) func(dropped []byte) {
+	return func(dropped []byte) {
+		if bp != nil && bp.Hook != nil {
+			bp.Hook(c, bp.Policy, dropped)
+		}
+	}
+}
+
+func (c *conn) Close(reason string) {
+	c.close(reason)
+}
+
+func (c *conn) Shutdown(ctx context.Context, code int, reason string) error {
+	c.drainOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		c.status.CAS(StatusConnectionRunning, StatusConnectionDraining)
+		close(c.drain)
+	})
+	select {
+	case <-c.drained:
+		c.close("shutdown")
+		return nil
+	case <-c.closeChan:
+		// the connection is closed by other reason during draining , the messages left are lost
+		select {
+		case <-c.drained:
+			return nil
+		default:
+			return ErrConnectionIsClosed
+		}
+	case <-ctx.Done():
+		c.close("shutdown forced")
+		return ctx.Err()
+	}
+}
+
+// monitorPing send the ping frame in interval until the connection closed
+func (c *conn) monitorPing(pinger PingWire, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeChan:
+			return
+		case <-ticker.C:
+			if err := pinger.WritePing(); err != nil {
+				logging.Log.Warn("monitorPing", zap.String("ID", c.identification), zap.Error(err))
+				c.close("monitorPing", err)
+				return
+			}
+		}
+	}
+}
+
+func (c *conn) monitorSend() {
+	defer func() {
+		if err := recover(); err != nil {
+			logging.Log.Error("monitorSend", zap.Any("PANIC", err))
+		}
+	}()
+	for {
+		select {
+		case <-c.closeChan:
+			goto loop
+		case <-c.drain:
+			if err := c.flush(); err != nil {
+				logging.Log.Warn("monitorSend flush", zap.String("ID", c.identification), zap.Error(err))
+				goto loop
+			}
+			close(c.drained)
+			return
+		case <-c.queue.Ready():
+			for data, ok := c.queue.Pop(); ok; data, ok = c.queue.Pop() {
+				if err := c.write(data); err != nil {
+					logging.Log.Warn("monitorSend", zap.Error(err))
+					goto loop
+				}
+			}
+		}
+	}
+loop:
+	c.close("monitorSend")
+}
+
+func (c *conn) write(data []byte) error {
+	startTime := time.Now()
+	err := c.wire.WriteMessage(data)
+	if err != nil {
+		return err
+	}
+	spendTime := time.Since(startTime)
+	if spendTime > time.Duration(2)*time.Second {
+		logging.Log.Warn("monitorSend weak net ", zap.String("ID", c.identification), zap.Any("WEAK_NET", spendTime))
+	}
+	c.metrics.Sent(len(data), spendTime)
+	return nil
+}
+
+// flush write all the message left in buffer , and then send the close frame to client
+func (c *conn) flush() error {
+	for data, ok := c.queue.Pop(); ok; data, ok = c.queue.Pop() {
+		if err := c.write(data); err != nil {
+			return err
+		}
+	}
+	return c.wire.WriteClose(c.closeCode, c.closeReason)
+}
+
+func (c *conn) monitorReceive(handleReceive Receive) {
+	defer func() {
+		if err := recover(); err != nil {
+			// the connection can't receive any more , so close it instead of leaving it alive
+			logging.Log.Error("monitorReceive ", zap.String("ID", c.identification), zap.Any("PANIC", err), zap.Stack("STACK"))
+			c.close("monitorReceive panic")
+		}
+	}()
+	var temErr error
+	for {
+		data, err := c.wire.ReadMessage()
+		if err != nil {
+			temErr = err
+			goto loop
+		}
+		if c.pinging {
+			// any message of client proves the connection is alive
+			c.ReFlushHeartBeatTime()
+		}
+		handleReceive(c, data)
+	}
+loop:
+	c.close("monitorReceive", temErr)
+}
+
+func (c *conn) close(cause string, err ...error) {
+	c.once.Do(func() {
+		c.status.Store(StatusConnectionClosed)
+		if len(err) > 0 {
+			if err[0] != nil {
+				// todo
+				//logging.Log.Error("close ", zap.String("ID",c.identification),zap.Error(err[0]))
+			}
+		}
+		close(c.closeChan)
+		c.queue.Close()
+		if err := c.wire.Close(); err != nil {
+			logging.Log.Error("close ", zap.String("ID", c.identification), zap.Error(err))
+		}
+		// the bucket may stop receiving after shutdown , so the closing should not be blocked
+		go func() {
+			select {
+			case c.notify <- c:
+			case <-c.done:
+			}
+		}()
+		logging.Log.Info("close", zap.String("ID", c.identification), zap.String("OFFLINE_CAUSE", cause))
+	})
+}
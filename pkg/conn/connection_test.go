@@ -0,0 +1,52 @@
+package conn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// brokenWire fail all the writes , and block the read until closed
+type brokenWire struct {
+	closed chan struct{}
+}
+
+func (b *brokenWire) ReadMessage() ([]byte, error) {
+	<-b.closed
+	return nil, errors.New("closed")
+}
+
+func (b *brokenWire) WriteMessage(data []byte) error {
+	return errors.New("broken pipe")
+}
+
+func (b *brokenWire) WriteClose(code int, reason string) error {
+	return errors.New("broken pipe")
+}
+
+func (b *brokenWire) Close() error {
+	select {
+	case <-b.closed:
+	default:
+		close(b.closed)
+	}
+	return nil
+}
+
+func TestConn_ShutdownBroken(t *testing.T) {
+	// nobody receive the sig , like the bucket stopped after shutdown
+	sig := make(chan Connect)
+	cli := NewWireConn("steven", "", &brokenWire{closed: make(chan struct{})}, sig, func(Connect, []byte) {}, nil)
+	cli.Send([]byte("hello"))
+	done := make(chan error, 1)
+	go func() { done <- cli.Shutdown(context.Background(), 1001, "shutdown") }()
+	select {
+	case err := <-done:
+		if err != ErrConnectionIsClosed {
+			t.Fatalf("Shutdown() error = '%v', wantErr '%v'", err, ErrConnectionIsClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown() is blocked by the sig")
+	}
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// AttrSubprotocol is the attribute of subprotocol negotiated at upgrade , it is not set when
+// the client request no subprotocol supported
+const AttrSubprotocol = "subprotocol"
+
+var (
+	// ErrOriginNotAllowed the origin of request is refused by Handshake , the request is replied
+	// with 403
+	ErrOriginNotAllowed = errors.New("conn : request origin not allowed")
+	ErrOriginPattern    = errors.New("conn handshake param is wrong err , the pattern of AllowedOrigins is illegal")
+)
+
+// Handshake is the policy of websocket handshake , it works on both gorilla and netpoll
+type Handshake struct {
+	// AllowedOrigins is the origins allowed , the pattern can contain the wildcard , for example
+	// "https://*.example.com" , and "*" allows all the origins . When both AllowedOrigins and
+	// CheckOrigin are empty , only the origin of the same host is allowed . The request without
+	// Origin header is always allowed , because it is not sent by browser
+	AllowedOrigins []string
+
+	// CheckOrigin is the custom check of origin , it is used instead of AllowedOrigins
+	CheckOrigin func(r *http.Request) bool
+
+	// Subprotocols is the subprotocols supported in order of preference of server , the first one
+	// of them requested by client is selected , no matter the order requested by client , and it is
+	// set to the attribute AttrSubprotocol of connection
+	Subprotocols []string
+
+	// Timeout is the time limit of writing the handshake response , zero means no limit
+	Timeout time.Duration
+
+	// Header is added to the handshake response , such as Set-Cookie
+	Header http.Header
+}
+
+// defaultHandshake is used when the Handshake of Option is nil , it allows the origin of the same
+// host only
+var defaultHandshake = &Handshake{}
+
+// HandshakePolicy return the Handshake of option , the default one which allows the origin of
+// the same host only is returned when it is nil
+func (o *Option) HandshakePolicy() *Handshake {
+	if o.Handshake == nil {
+		return defaultHandshake
+	}
+	return o.Handshake
+}
+
+func (h *Handshake) validate() error {
+	for _, pattern := range h.AllowedOrigins {
+		if _, err := path.Match(strings.ToLower(pattern), ""); err != nil {
+			return ErrOriginPattern
+		}
+	}
+	return nil
+}
+
+// AllowOrigin judge the origin of request is allowed or not
+func (h *Handshake) AllowOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if h.CheckOrigin != nil {
+		return h.CheckOrigin(r)
+	}
+	if len(h.AllowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range h.AllowedOrigins {
+		if pattern == "*" {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(pattern), origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate return the subprotocol selected , it is empty when there is no subprotocol both
+// supported by server and requested by client
+func (h *Handshake) Negotiate(r *http.Request) string {
+	var requested []string
+	for _, v := range r.Header[http.CanonicalHeaderKey("Sec-WebSocket-Protocol")] {
+		for _, protocol := range strings.Split(v, ",") {
+			requested = append(requested, strings.TrimSpace(protocol))
+		}
+	}
+	for _, protocol := range h.Subprotocols {
+		for _, v := range requested {
+			if v == protocol {
+				return protocol
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package conn
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHandshake_AllowOrigin(t *testing.T) {
+	wildcard := &Handshake{AllowedOrigins: []string{"https://*.example.com", "http://localhost:8080"}}
+	custom := &Handshake{AllowedOrigins: []string{"*"}, CheckOrigin: func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://custom.com"
+	}}
+	tests := []struct {
+		name      string
+		handshake *Handshake
+		origin    string
+		want      bool
+	}{
+		{name: "no origin", handshake: wildcard, want: true},
+		{name: "wildcard", handshake: wildcard, origin: "https://chat.Example.com", want: true},
+		{name: "wildcard scheme", handshake: wildcard, origin: "http://chat.example.com"},
+		{name: "wildcard suffix", handshake: wildcard, origin: "https://chat.example.com.evil.com"},
+		{name: "exact", handshake: wildcard, origin: "http://localhost:8080", want: true},
+		{name: "same host", handshake: &Handshake{}, origin: "https://sim.io", want: true},
+		{name: "cross host", handshake: &Handshake{}, origin: "https://evil.com"},
+		{name: "all", handshake: &Handshake{AllowedOrigins: []string{"*"}}, origin: "https://evil.com", want: true},
+		{name: "custom", handshake: custom, origin: "https://custom.com", want: true},
+		{name: "custom refused", handshake: custom, origin: "https://evil.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "https://sim.io/conn", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := tt.handshake.AllowOrigin(r); got != tt.want {
+				t.Fatalf("AllowOrigin() = %v , want %v", got, tt.want)
+			}
+		})
+	}
+	if err := validate(&Option{Buffer: 1, ConnectionReadBuffer: 1, ConnectionWriteBuffer: 1, MessageType: MessageTypeText,
+		Handshake: &Handshake{AllowedOrigins: []string{"https://[.com"}}}); err != ErrOriginPattern {
+		t.Fatalf("validate() error = '%v', wantErr '%v'", err, ErrOriginPattern)
+	}
+}
+
+func TestConn_Handshake(t *testing.T) {
+	option := DefaultOption()
+	option.Handshake = &Handshake{
+		AllowedOrigins: []string{"https://*.example.com"},
+		Subprotocols:   []string{"sim.v2", "sim.v1"},
+		Header:         http.Header{"X-Server": []string{"sim"}},
+	}
+	conns := make(chan Connect, 1)
+	errs := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cli, err := NewConn("steven", "", make(chan Connect, 1), w, r, func(Connect, []byte) {}, option)
+		if err != nil {
+			errs <- err
+			return
+		}
+		conns <- cli
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.com"}})
+	if err == nil || resp.StatusCode != http.StatusForbidden || <-errs != ErrOriginNotAllowed {
+		t.Fatalf("Dial() from the origin not allowed = %v", err)
+	}
+	dialer := &websocket.Dialer{Subprotocols: []string{"sim.v1", "sim.v2"}}
+	client, resp, err := dialer.Dial(url, http.Header{"Origin": []string{"https://chat.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	cli := <-conns
+	defer cli.Close("test")
+	// the preference of server decide the subprotocol
+	if client.Subprotocol() != "sim.v2" || StringAttribute(cli, AttrSubprotocol) != "sim.v2" {
+		t.Fatalf("subprotocol = %q and %q , want sim.v2", client.Subprotocol(), StringAttribute(cli, AttrSubprotocol))
+	}
+	if resp.Header.Get("X-Server") != "sim" {
+		t.Fatal("the header of handshake response is not set")
+	}
+}
+
+func TestConn_HandshakeDefault(t *testing.T) {
+	errs := make(chan error, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cli, err := NewConn("steven", "", make(chan Connect, 1), w, r, func(Connect, []byte) {}, DefaultOption())
+		if err == nil {
+			cli.Close("test")
+		}
+		errs <- err
+	}))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	// only the origin of the same host is allowed when the Handshake is nil
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.com"}})
+	if err == nil || resp.StatusCode != http.StatusForbidden || <-errs != ErrOriginNotAllowed {
+		t.Fatalf("Dial() from the other host = %v", err)
+	}
+	client, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{ts.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
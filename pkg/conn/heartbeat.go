@@ -0,0 +1,54 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// HeartbeatWatcher is implemented by the connection which report the refresh of heartbeat , so
+// the timer of heartbeat can be rescheduled when the heartbeat arrived instead of scanning
+type HeartbeatWatcher interface {
+	// WatchHeartbeat set the watcher called after the heartbeat refreshed , it is called at most
+	// once a second , because the heartbeat time is in second
+	WatchHeartbeat(watcher func())
+}
+
+// Heartbeat is the heartbeat time of connection , it implements the heartbeat methods of Connect
+// and HeartbeatWatcher , so the implement of Connect can embed it , it should be refreshed before
+// used
+type Heartbeat struct {
+	last    atomic.Int64
+	watcher atomic.Value
+}
+
+func (h *Heartbeat) ReFlushHeartBeatTime() {
+	now := time.Now().Unix()
+	if h.last.Swap(now) == now {
+		return
+	}
+	if watcher, ok := h.watcher.Load().(func()); ok {
+		watcher()
+	}
+}
+
+func (h *Heartbeat) GetLastHeartBeatTime() int64 {
+	return h.last.Load()
+}
+
+func (h *Heartbeat) WatchHeartbeat(watcher func()) {
+	h.watcher.Store(watcher)
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"time"
+
+	"github.com/mongofs/sim/pkg/metrics"
+	"go.uber.org/atomic"
+)
+
+// Metrics record the message written by connections , the fields are created by the
+// registry of server , a nil Metrics or nil field record nothing
+type Metrics struct {
+	// MessagesSent is the number of messages written to the client
+	MessagesSent *metrics.Counter
+	// BytesSent is the number of bytes written to the client
+	BytesSent *metrics.Counter
+	// MessagesDropped is the number of messages refused by ErrConnectionIsWeak
+	MessagesDropped *metrics.Counter
+	// WriteLatency is the time spent by WriteMessage
+	WriteLatency *metrics.Histogram
+
+	// the number of messages waiting in the queue of connections by priority
+	queued [PriorityHigh + 1]atomic.Int64
+}
+
+// Queued record the number of messages in the queue of priority is changed by delta
+func (m *Metrics) Queued(priority Priority, delta int) {
+	if m == nil || delta == 0 {
+		return
+	}
+	m.queued[priority].Add(int64(delta))
+}
+
+// QueueDepth return the number of messages waiting in the queue of priority
+func (m *Metrics) QueueDepth(priority Priority) int64 {
+	if m == nil {
+		return 0
+	}
+	return m.queued[priority].Load()
+}
+
+// Sent record a message of size is written in spend time
+func (m *Metrics) Sent(size int, spend time.Duration) {
+	if m == nil {
+		return
+	}
+	m.MessagesSent.Inc()
+	m.BytesSent.Add(float64(size))
+	m.WriteLatency.Observe(spend.Seconds())
+}
+
+// Dropped record a message is dropped because the connection is weak
+func (m *Metrics) Dropped() {
+	if m == nil {
+		return
+	}
+	m.MessagesDropped.Inc()
+}
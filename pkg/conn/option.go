@@ -16,6 +16,7 @@ package conn
 import (
 	"errors"
 	"go.uber.org/atomic"
+	"time"
 )
 
 type MessageType uint
@@ -43,6 +44,8 @@ var (
 	ErrBufferParam = errors.New("conn  buffer param is wrong err , the value must bigger the 1")
 	// For connection  Message Type param
 	ErrMessageTypeParam = errors.New("conn  MessageType param is wrong err , the value must be 1 or 2")
+	// For connection option is nil
+	ErrOptionIsNil = errors.New("conn  option is nil")
 )
 
 type Option struct {
@@ -50,6 +53,25 @@ type Option struct {
 	MessageType           MessageType // Message type
 	ConnectionWriteBuffer int         // connection write buffer
 	ConnectionReadBuffer  int         // connection read buffer
+	Metrics               *Metrics    // metrics of connection , it is set by server
+
+	// Done is closed when the server is shut down , the connection closed after it is not sent to
+	// the signal channel , it is set by server
+	Done <-chan struct{}
+
+	// Backpressure decide what to do when the buffer is full , the new message is dropped when it
+	// is nil
+	Backpressure *Backpressure
+
+	// PingInterval is the interval of sending ping frame , the heartbeat is refreshed by the pong
+	// and any message of client , so the application don't need to refresh it . It only works on
+	// the transport which support ping , and it is turned off when it is zero
+	PingInterval time.Duration
+
+	// Handshake is the policy of websocket handshake , such as the origins allowed and the
+	// subprotocols supported . When it is nil , only the origin of the same host is allowed , set
+	// the AllowedOrigins to "*" to allow the cross-origin requests
+	Handshake *Handshake
 }
 
 func DefaultOption() *Option {
@@ -71,8 +93,15 @@ func SetOption(option *Option) error {
 	return nil
 }
 
+// ValidateOption check the option is legal or not
+func ValidateOption(option *Option) error {
+	return validate(option)
+}
+
 func validate(option *Option) error {
-	if option.Buffer < 1 {
+	if option == nil {
+		return ErrOptionIsNil
+	} else if option.Buffer < 1 {
 		return ErrBufferParam
 	} else if option.ConnectionReadBuffer < 1 {
 		return ErrConnReadBufferParam
@@ -80,9 +109,15 @@ func validate(option *Option) error {
 		return ErrConnWriteBufferParam
 	} else if option.MessageType != MessageTypeText && option.MessageType != MessageTypeBinary {
 		return ErrMessageTypeParam
-	} else {
-		return nil
+	} else if option.Backpressure != nil {
+		if err := option.Backpressure.validate(); err != nil {
+			return err
+		}
 	}
+	if option.Handshake != nil {
+		return option.Handshake.validate()
+	}
+	return nil
 }
 
 // counter message wrapper add a counter for message , the counter is for record
@@ -0,0 +1,340 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BackpressurePolicy decide what to do when the send queue of connection is full , the queue is
+// treated as full when it is 70% used
+type BackpressurePolicy int
+
+const (
+	// BackpressureDropNewest drop the message sent , Send returns ErrConnectionIsWeak , this is the
+	// default policy
+	BackpressureDropNewest BackpressurePolicy = iota
+
+	// BackpressureDropOldest drop the oldest message in queue to make room for the new one
+	BackpressureDropOldest
+
+	// BackpressureBlock block the Send until the queue has room or the Timeout , the message is
+	// dropped when timeout . It only applies to the message delivered to one user , see
+	// SendWithBlock , the message sent by bucket is dropped as BackpressureDropNewest , so the
+	// bucket is never blocked by a slow connection
+	BackpressureBlock
+
+	// BackpressureCoalesce replace the message in queue with the same key by the new one , so only
+	// the latest message of each key is sent , the message with empty key is never replaced . The
+	// new message is dropped when the queue is full and there is no message of the same key
+	BackpressureCoalesce
+
+	// BackpressureDisconnect drop the new message , and close the connection after MaxDrops
+	// consecutive drops , Send returns ErrConnectionIsSlow when the connection is closed
+	BackpressureDisconnect
+)
+
+const (
+	DefaultBlockTimeout = time.Second
+	DefaultMaxDrops     = 1 << 4 // 16
+)
+
+var (
+	ErrBackpressureParam = errors.New("conn backpressure param is wrong err , the Key of coalesce must be set")
+	// the connection is closed by BackpressureDisconnect
+	ErrConnectionIsSlow = errors.New("connection is too slow")
+)
+
+type Backpressure struct {
+	Policy BackpressurePolicy
+	// Timeout the time limit of BackpressureBlock
+	Timeout time.Duration
+	// Key return the key of message for BackpressureCoalesce
+	Key func(data []byte) string
+	// MaxDrops the consecutive drops of BackpressureDisconnect
+	MaxDrops int
+	// Hook is called when the policy triggered , dropped is the message dropped or replaced , it
+	// is called in the goroutine of Send , so it should not block
+	Hook func(cli Connect, policy BackpressurePolicy, dropped []byte)
+}
+
+func (b *Backpressure) validate() error {
+	if b.Policy == BackpressureCoalesce && b.Key == nil {
+		return ErrBackpressureParam
+	}
+	return nil
+}
+
+type queueItem struct {
+	key  string
+	data []byte
+}
+
+// Queue is the send queue of connection , the Backpressure decide what to do when it is full .
+// The queue has two lanes , the message of high lane is popped first , and the high lane is not
+// limited by the capacity , so it should only be used by the critical messages
+type Queue struct {
+	mu       sync.Mutex
+	items    []queueItem
+	high     [][]byte
+	capacity int
+	bp       Backpressure
+	metrics  *Metrics
+	// the consecutive drops
+	drops int
+	// trigger is called with the message dropped without lock
+	trigger func(dropped []byte)
+	// ready is notified when message pushed , space is closed and replaced when message popped
+	ready  chan struct{}
+	space  chan struct{}
+	closed bool
+}
+
+// NewQueue create the send queue , if bp is nil , BackpressureDropNewest will be used , trigger
+// is called when the policy triggered , the dropped messages and the depth of queue are recorded
+// by metrics
+func NewQueue(capacity int, bp *Backpressure, metrics *Metrics, trigger func(dropped []byte)) *Queue {
+	q := &Queue{
+		capacity: capacity,
+		metrics:  metrics,
+		trigger:  trigger,
+		ready:    make(chan struct{}, 1),
+		space:    make(chan struct{}),
+	}
+	if bp != nil {
+		q.bp = *bp
+	}
+	if q.bp.Timeout <= 0 {
+		q.bp.Timeout = DefaultBlockTimeout
+	}
+	if q.bp.MaxDrops <= 0 {
+		q.bp.MaxDrops = DefaultMaxDrops
+	}
+	return q
+}
+
+// full judge the queue is full , it must be called with lock
+func (q *Queue) full() bool {
+	return len(q.items)*10 > q.capacity*7
+}
+
+// PushPriority put the message to the lane of priority , the message of high priority is never
+// dropped unless the queue is closed
+func (q *Queue) PushPriority(data []byte, priority Priority) error {
+	if priority != PriorityHigh {
+		return q.Push(data)
+	}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrConnectionIsClosed
+	}
+	q.high = append(q.high, data)
+	q.metrics.Queued(PriorityHigh, 1)
+	q.mu.Unlock()
+	q.notify()
+	return nil
+}
+
+// Push put the message to the normal lane , the error is returned when the message is dropped ,
+// it never blocks , BackpressureBlock is treated as BackpressureDropNewest
+func (q *Queue) Push(data []byte) error {
+	return q.push(data, false)
+}
+
+// PushBlock is the Push waiting for the room of queue when the policy is BackpressureBlock
+func (q *Queue) PushBlock(data []byte) error {
+	return q.push(data, true)
+}
+
+func (q *Queue) push(data []byte, block bool) error {
+	var key string
+	if q.bp.Policy == BackpressureCoalesce {
+		key = q.bp.Key(data)
+	}
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrConnectionIsClosed
+	}
+	if key != "" {
+		for i := range q.items {
+			if q.items[i].key == key {
+				dropped := q.items[i].data
+				q.items[i].data = data
+				q.drops = 0
+				q.mu.Unlock()
+				q.fire(dropped)
+				return nil
+			}
+		}
+	}
+	var dropped []byte
+	if q.full() {
+		switch q.bp.Policy {
+		case BackpressureDropOldest:
+			dropped = q.items[0].data
+			q.pop()
+		case BackpressureBlock:
+			if !block {
+				break
+			}
+			if err := q.wait(); err != nil {
+				q.mu.Unlock()
+				return err
+			}
+		}
+	}
+	if q.full() {
+		q.drops++
+		slow := q.bp.Policy == BackpressureDisconnect && q.drops >= q.bp.MaxDrops
+		q.mu.Unlock()
+		q.fire(data)
+		if slow {
+			return ErrConnectionIsSlow
+		}
+		return ErrConnectionIsWeak
+	}
+	q.items = append(q.items, queueItem{key: key, data: data})
+	q.drops = 0
+	if dropped == nil {
+		q.metrics.Queued(PriorityNormal, 1)
+	}
+	q.mu.Unlock()
+	q.notify()
+	if dropped != nil {
+		q.fire(dropped)
+	}
+	return nil
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// wait for the room of queue until timeout , it must be called with lock
+func (q *Queue) wait() error {
+	timer := time.NewTimer(q.bp.Timeout)
+	defer timer.Stop()
+	for q.full() {
+		space := q.space
+		q.mu.Unlock()
+		select {
+		case <-space:
+		case <-timer.C:
+			q.mu.Lock()
+			return nil
+		}
+		q.mu.Lock()
+		if q.closed {
+			return ErrConnectionIsClosed
+		}
+	}
+	return nil
+}
+
+func (q *Queue) fire(dropped []byte) {
+	q.metrics.Dropped()
+	if q.trigger != nil {
+		q.trigger(dropped)
+	}
+}
+
+// Pop return the first message of high lane , if the high lane is empty , the first message of
+// normal lane is returned , it returns false when the queue is empty
+func (q *Queue) Pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.high) != 0 {
+		data := q.high[0]
+		q.high[0] = nil
+		q.high = q.high[1:]
+		q.metrics.Queued(PriorityHigh, -1)
+		return data, true
+	}
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	data := q.items[0].data
+	q.pop()
+	q.metrics.Queued(PriorityNormal, -1)
+	return data, true
+}
+
+// PopAll return all the messages in queue , the messages of high lane are in the front
+func (q *Queue) PopAll() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.high)+len(q.items) == 0 {
+		return nil
+	}
+	res := make([][]byte, 0, len(q.high)+len(q.items))
+	res = append(res, q.high...)
+	for _, item := range q.items {
+		res = append(res, item.data)
+	}
+	q.metrics.Queued(PriorityHigh, -len(q.high))
+	q.metrics.Queued(PriorityNormal, -len(q.items))
+	q.high, q.items = nil, q.items[:0]
+	q.signal()
+	return res
+}
+
+// pop remove the first message , it must be called with lock
+func (q *Queue) pop() {
+	q.items[0] = queueItem{}
+	q.items = q.items[1:]
+	q.signal()
+}
+
+// signal wake up the Push blocked , it must be called with lock
+func (q *Queue) signal() {
+	if q.bp.Policy == BackpressureBlock && !q.closed {
+		close(q.space)
+		q.space = make(chan struct{})
+	}
+}
+
+// Ready is notified when message pushed , the receiver should pop until the queue is empty
+func (q *Queue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Len return the number of messages in the lane of priority
+func (q *Queue) Len(priority Priority) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if priority == PriorityHigh {
+		return len(q.high)
+	}
+	return len(q.items)
+}
+
+// Close refuse the new message and wake up the Push blocked , the messages left are discarded
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.space)
+		q.metrics.Queued(PriorityHigh, -len(q.high))
+		q.metrics.Queued(PriorityNormal, -len(q.items))
+		q.high, q.items = nil, nil
+	}
+}
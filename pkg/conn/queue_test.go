@@ -0,0 +1,124 @@
+package conn
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		name    string
+		bp      *Backpressure
+		push    []string
+		want    []string
+		dropped []string
+		err     error // the error of the last push
+	}{
+		{name: "drop newest", push: []string{"1", "2", "3"}, want: []string{"1", "2"}, dropped: []string{"3"}, err: ErrConnectionIsWeak},
+		{name: "drop oldest", bp: &Backpressure{Policy: BackpressureDropOldest}, push: []string{"1", "2", "3"}, want: []string{"2", "3"}, dropped: []string{"1"}},
+		{name: "block without wait", bp: &Backpressure{Policy: BackpressureBlock, Timeout: time.Hour}, push: []string{"1", "2", "3"}, want: []string{"1", "2"}, dropped: []string{"3"}, err: ErrConnectionIsWeak},
+		{
+			name: "coalesce",
+			bp: &Backpressure{Policy: BackpressureCoalesce, Key: func(data []byte) string {
+				return strings.Split(string(data), "=")[0]
+			}},
+			push:    []string{"BTC=1", "ETH=1", "BTC=2", "BTC=3"},
+			want:    []string{"BTC=3", "ETH=1"},
+			dropped: []string{"BTC=1", "BTC=2"},
+		},
+		{name: "disconnect", bp: &Backpressure{Policy: BackpressureDisconnect, MaxDrops: 2}, push: []string{"1", "2", "3", "4"}, want: []string{"1", "2"}, dropped: []string{"3", "4"}, err: ErrConnectionIsSlow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dropped []string
+			// the queue is full when it has 2 messages
+			q := NewQueue(2, tt.bp, nil, func(data []byte) { dropped = append(dropped, string(data)) })
+			var err error
+			for _, data := range tt.push {
+				err = q.Push([]byte(data))
+			}
+			if err != tt.err {
+				t.Fatalf("Push() error = '%v', wantErr '%v'", err, tt.err)
+			}
+			var got []string
+			for _, data := range q.PopAll() {
+				got = append(got, string(data))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") || strings.Join(dropped, ",") != strings.Join(tt.dropped, ",") {
+				t.Fatalf("queue = %v , dropped = %v , want %v and %v", got, dropped, tt.want, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestQueue_Block(t *testing.T) {
+	q := NewQueue(2, &Backpressure{Policy: BackpressureBlock, Timeout: time.Second}, nil, nil)
+	q.Push([]byte("1"))
+	q.Push([]byte("2"))
+	pushed := make(chan error, 1)
+	go func() { pushed <- q.PushBlock([]byte("3")) }()
+	select {
+	case <-pushed:
+		t.Fatal("Push() should be blocked when the queue is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if data, _ := q.Pop(); string(data) != "1" {
+		t.Fatalf("Pop() = %s , want 1", data)
+	}
+	if err := <-pushed; err != nil {
+		t.Fatal(err)
+	}
+	go func() { pushed <- q.PushBlock([]byte("4")) }()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	if err := <-pushed; err != ErrConnectionIsClosed {
+		t.Fatalf("Push() error = '%v', wantErr '%v'", err, ErrConnectionIsClosed)
+	}
+}
+
+func TestQueue_BlockTimeout(t *testing.T) {
+	var dropped []string
+	q := NewQueue(2, &Backpressure{Policy: BackpressureBlock, Timeout: time.Millisecond}, nil, func(data []byte) {
+		dropped = append(dropped, string(data))
+	})
+	q.Push([]byte("1"))
+	q.Push([]byte("2"))
+	if err := q.PushBlock([]byte("3")); err != ErrConnectionIsWeak {
+		t.Fatalf("PushBlock() error = '%v', wantErr '%v'", err, ErrConnectionIsWeak)
+	}
+	if strings.Join(dropped, ",") != "3" {
+		t.Fatalf("dropped = %v , want [3]", dropped)
+	}
+}
+
+func TestQueue_Priority(t *testing.T) {
+	metrics := &Metrics{}
+	q := NewQueue(2, nil, metrics, nil)
+	q.Push([]byte("1"))
+	q.Push([]byte("2"))
+	if err := q.Push([]byte("3")); err != ErrConnectionIsWeak {
+		t.Fatalf("Push() error = '%v', wantErr '%v'", err, ErrConnectionIsWeak)
+	}
+	// the high lane is not limited by the capacity
+	for _, data := range []string{"h1", "h2"} {
+		if err := q.PushPriority([]byte(data), PriorityHigh); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if metrics.QueueDepth(PriorityHigh) != 2 || metrics.QueueDepth(PriorityNormal) != 2 {
+		t.Fatalf("QueueDepth() = %v and %v , want 2 and 2", metrics.QueueDepth(PriorityHigh), metrics.QueueDepth(PriorityNormal))
+	}
+	var got []string
+	for data, ok := q.Pop(); ok; data, ok = q.Pop() {
+		got = append(got, string(data))
+	}
+	if strings.Join(got, ",") != "h1,h2,1,2" {
+		t.Fatalf("queue = %v , want the high lane first", got)
+	}
+	q.PushPriority([]byte("h3"), PriorityHigh)
+	q.Close()
+	if metrics.QueueDepth(PriorityHigh) != 0 || metrics.QueueDepth(PriorityNormal) != 0 {
+		t.Fatalf("QueueDepth() = %v and %v after closed , want 0", metrics.QueueDepth(PriorityHigh), metrics.QueueDepth(PriorityNormal))
+	}
+}
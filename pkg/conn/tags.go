@@ -0,0 +1,66 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"sync"
+
+	"github.com/mongofs/sim/pkg/label"
+)
+
+// TagSet is the tags of connection , the value is the handler returned by label manager , it
+// implements the tag methods of Connect , so the implement of Connect can embed it . The zero
+// value is ready to use
+type TagSet struct {
+	tagLock sync.RWMutex
+	tags    map[string]label.ForClient
+}
+
+func (t *TagSet) HaveTags(tags []string) bool {
+	t.tagLock.RLock()
+	defer t.tagLock.RUnlock()
+	for _, tag := range tags {
+		if _, ok := t.tags[tag]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *TagSet) SetTag(tag string, fc label.ForClient) {
+	t.tagLock.Lock()
+	defer t.tagLock.Unlock()
+	if t.tags == nil {
+		t.tags = map[string]label.ForClient{}
+	}
+	t.tags[tag] = fc
+}
+
+func (t *TagSet) DelTag(tag string) (label.ForClient, bool) {
+	t.tagLock.Lock()
+	defer t.tagLock.Unlock()
+	fc, ok := t.tags[tag]
+	delete(t.tags, tag)
+	return fc, ok
+}
+
+func (t *TagSet) Tags() []string {
+	t.tagLock.RLock()
+	defer t.tagLock.RUnlock()
+	res := make([]string, 0, len(t.tags))
+	for tag := range t.tags {
+		res = append(res, tag)
+	}
+	return res
+}
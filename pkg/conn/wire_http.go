@@ -0,0 +1,348 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 对于不能使用websocket 的客户端（比如代理会去掉upgrade 头）提供了两种基于http 的降级方案：
+// SSE 负责服务端推送，long-polling 通过轮询获取消息，两者上行的消息都是通过单独的http 请求
+// 投递到 Deliver ，然后由 ReadMessage 交给连接，所以和websocket 一样走 HandleReceive
+
+// DefaultPollExpire is the default time limit between two polls
+const DefaultPollExpire = time.Minute
+
+var (
+	// the ResponseWriter can not flush , so the SSE can not work
+	ErrStreamingNotSupported = errors.New("conn : the response writer is not a flusher")
+	// the client has not polled for a long time
+	ErrPollExpired = errors.New("conn : the long-polling session is expired")
+)
+
+// SSEEventBinary is the event of binary message , the data is encoded by base64 , because the
+// data of event is text line , the "\r" and the bytes invalid in UTF-8 will be broken
+const SSEEventBinary = "binary"
+
+// SSEWire is the wire of Server-Sent Events , the message is written as the data of event , the
+// message which has more than one line is written as multiple data lines . When the message type
+// is binary , the message is written as SSEEventBinary
+type SSEWire struct {
+	mu      sync.Mutex
+	w       io.Writer
+	flusher http.Flusher
+	closed  bool
+	binary  bool
+
+	inbound chan []byte
+	done    chan struct{}
+}
+
+// NewSSEWire write the header of event stream , the handler should not return until Done
+func NewSSEWire(w http.ResponseWriter, messageType MessageType) (*SSEWire, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingNotSupported
+	}
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// disable the buffer of nginx
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &SSEWire{
+		w:       w,
+		flusher: flusher,
+		binary:  messageType == MessageTypeBinary,
+		inbound: make(chan []byte),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// WriteEvent write an event , the event is omitted when it is empty
+func (s *SSEWire) WriteEvent(event string, data []byte) error {
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.Bytes())
+}
+
+// Keepalive write a comment , so the proxy will not close the idle stream
+func (s *SSEWire) Keepalive() error {
+	return s.write([]byte(": keepalive\n\n"))
+}
+
+func (s *SSEWire) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrConnectionIsClosed
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *SSEWire) ReadMessage() ([]byte, error) {
+	select {
+	case data := <-s.inbound:
+		return data, nil
+	case <-s.done:
+		return nil, ErrConnectionIsClosed
+	}
+}
+
+func (s *SSEWire) WriteMessage(data []byte) error {
+	if s.binary {
+		return s.WriteEvent(SSEEventBinary, []byte(base64.StdEncoding.EncodeToString(data)))
+	}
+	return s.WriteEvent("", data)
+}
+
+// WriteClose write the close event , the data is "{code} {reason}"
+func (s *SSEWire) WriteClose(code int, reason string) error {
+	return s.WriteEvent("close", []byte(strconv.Itoa(code)+" "+reason))
+}
+
+// Close wait for the writing finished , the ResponseWriter will not be used after Close
+func (s *SSEWire) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	return nil
+}
+
+// Deliver hand the message sent by client to the connection
+func (s *SSEWire) Deliver(ctx context.Context, data []byte) error {
+	return deliverInbound(ctx, s.inbound, s.done, data)
+}
+
+// Done is closed when the wire is closed
+func (s *SSEWire) Done() <-chan struct{} {
+	return s.done
+}
+
+// PollResult is the result of a poll request
+type PollResult struct {
+	Messages [][]byte
+	// Closed means the connection is shutting down , the client should not poll again
+	Closed bool
+	Code   int
+	Reason string
+}
+
+// PollWire is the wire of long-polling , the message is kept until the client polls , when
+// the pending messages reach the capacity , the WriteMessage blocks , so the buffer of
+// connection will be full and the connection become weak just like a slow websocket client
+type PollWire struct {
+	mu       sync.Mutex
+	pending  [][]byte
+	capacity int
+	// changed is closed and replaced when the state changed
+	changed chan struct{}
+
+	closing     bool
+	closeSent   bool
+	closeCode   int
+	closeReason string
+	closed      bool
+
+	// the session is expired when there is no poll in expire
+	expire   time.Duration
+	lastPoll time.Time
+	polling  int
+
+	inbound chan []byte
+	done    chan struct{}
+}
+
+// NewPollWire create the wire of long-polling , capacity is the max number of messages waiting
+// to be polled , expire is the time limit between two polls
+func NewPollWire(capacity int, expire time.Duration) *PollWire {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if expire <= 0 {
+		expire = DefaultPollExpire
+	}
+	return &PollWire{
+		capacity: capacity,
+		changed:  make(chan struct{}),
+		expire:   expire,
+		lastPoll: time.Now(),
+		inbound:  make(chan []byte),
+		done:     make(chan struct{}),
+	}
+}
+
+// signal wake up the waiters , it must be called with lock
+func (p *PollWire) signal() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Poll wait for the messages until wait timeout , the result is empty when timeout
+func (p *PollWire) Poll(ctx context.Context, wait time.Duration) (*PollResult, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	p.mu.Lock()
+	p.polling++
+	defer func() {
+		p.lastPoll = time.Now()
+		p.polling--
+		p.mu.Unlock()
+	}()
+	for {
+		if p.closed {
+			return nil, ErrConnectionIsClosed
+		}
+		if len(p.pending) > 0 || p.closing {
+			res := &PollResult{Messages: p.pending, Closed: p.closing, Code: p.closeCode, Reason: p.closeReason}
+			p.pending = nil
+			p.closeSent = p.closing
+			p.signal()
+			return res, nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			p.mu.Lock()
+			return &PollResult{}, nil
+		case <-ctx.Done():
+			p.mu.Lock()
+			return nil, ctx.Err()
+		}
+		p.mu.Lock()
+	}
+}
+
+// wait wait for the cond is true or the wire closed , it must be called with lock
+func (p *PollWire) wait(cond func() bool) error {
+	for !cond() {
+		if p.closed {
+			return ErrConnectionIsClosed
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-p.done:
+		}
+		p.mu.Lock()
+	}
+	return nil
+}
+
+func (p *PollWire) ReadMessage() ([]byte, error) {
+	ticker := time.NewTicker(p.expire)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-p.inbound:
+			return data, nil
+		case <-p.done:
+			return nil, ErrConnectionIsClosed
+		case <-ticker.C:
+			if p.expired() {
+				return nil, ErrPollExpired
+			}
+		}
+	}
+}
+
+func (p *PollWire) expired() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.polling == 0 && time.Since(p.lastPoll) > p.expire
+}
+
+func (p *PollWire) WriteMessage(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.wait(func() bool { return p.closed || len(p.pending) < p.capacity }); err != nil {
+		return err
+	}
+	if p.closed {
+		return ErrConnectionIsClosed
+	}
+	p.pending = append(p.pending, data)
+	p.signal()
+	return nil
+}
+
+// WriteClose tell the client the connection is closing , it returns after the client polled
+// all the messages and the close information
+func (p *PollWire) WriteClose(code int, reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closing, p.closeCode, p.closeReason = true, code, reason
+	p.signal()
+	return p.wait(func() bool { return p.closeSent })
+}
+
+func (p *PollWire) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+		p.signal()
+	}
+	return nil
+}
+
+// Deliver hand the message sent by client to the connection
+func (p *PollWire) Deliver(ctx context.Context, data []byte) error {
+	return deliverInbound(ctx, p.inbound, p.done, data)
+}
+
+// Done is closed when the wire is closed
+func (p *PollWire) Done() <-chan struct{} {
+	return p.done
+}
+
+func deliverInbound(ctx context.Context, inbound chan<- []byte, done <-chan struct{}, data []byte) error {
+	select {
+	case inbound <- data:
+		return nil
+	case <-done:
+		return ErrConnectionIsClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollWire(t *testing.T) {
+	wire := NewPollWire(1, time.Minute)
+	if res, err := wire.Poll(context.Background(), 10*time.Millisecond); err != nil || len(res.Messages) != 0 {
+		t.Fatalf("Poll() = %v , %v , want empty", res, err)
+	}
+	if err := wire.WriteMessage([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	// the pending messages reach the capacity , so the write is blocked until polled
+	written := make(chan error, 1)
+	go func() { written <- wire.WriteMessage([]byte("second")) }()
+	select {
+	case <-written:
+		t.Fatal("WriteMessage should be blocked when the wire is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if res, _ := wire.Poll(context.Background(), time.Second); len(res.Messages) != 1 || string(res.Messages[0]) != "first" {
+		t.Fatalf("Poll() = %q , want first", res.Messages)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := wire.Poll(context.Background(), time.Second); len(res.Messages) != 1 || string(res.Messages[0]) != "second" {
+		t.Fatalf("Poll() = %q , want second", res.Messages)
+	}
+	// the close returns after the client polled the close information
+	go func() { written <- wire.WriteClose(1001, "bye") }()
+	if res, _ := wire.Poll(context.Background(), time.Second); !res.Closed || res.Code != 1001 || res.Reason != "bye" {
+		t.Fatalf("Poll() = %+v , want the close information", res)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	wire.Close()
+	if _, err := wire.ReadMessage(); err != ErrConnectionIsClosed {
+		t.Fatalf("ReadMessage() error = '%v', wantErr '%v'", err, ErrConnectionIsClosed)
+	}
+}
+
+func TestSSEWire_Binary(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	wire, err := NewSSEWire(recorder, MessageTypeBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte{'a', '\r', '\n', 'b', 0xff, 0xfe, '\n'}
+	if err := wire.WriteMessage(message); err != nil {
+		t.Fatal(err)
+	}
+	// the event is "event: binary\ndata: {base64}\n\n"
+	lines := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n\n"), "\n")
+	if len(lines) != 2 || lines[0] != "event: "+SSEEventBinary || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("the event written is %q", recorder.Body.String())
+	}
+	if data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(lines[1], "data: ")); err != nil || !bytes.Equal(data, message) {
+		t.Fatalf("decoded %v , %v , want %v", data, err, message)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+)
+
+// tcp 的帧格式非常简单，每一帧由4个字节的大端长度和对应长度的内容组成，没有关闭帧，连接关闭即下线，
+// 对于不方便实现websocket 的设备（比如一些IOT 设备）可以直接使用这个协议接入
+
+const (
+	// DefaultMaxFrameSize is the default max length of the frame sent by client
+	DefaultMaxFrameSize = 1 << 20
+	frameHeaderSize     = 4
+)
+
+// the length of frame is bigger than the limit
+var ErrFrameTooLarge = errors.New("conn : the frame is too large")
+
+type tcpWire struct {
+	con          net.Conn
+	reader       *bufio.Reader
+	maxFrameSize int
+}
+
+// NewTCPWire wrap the tcp connection as Wire , the frame bigger than maxFrameSize will close
+// the connection , if maxFrameSize <= 0 , DefaultMaxFrameSize will be used
+func NewTCPWire(con net.Conn, reader *bufio.Reader, maxFrameSize int) Wire {
+	if maxFrameSize <= 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	if reader == nil {
+		reader = bufio.NewReader(con)
+	}
+	return &tcpWire{con: con, reader: reader, maxFrameSize: maxFrameSize}
+}
+
+func (t *tcpWire) ReadMessage() ([]byte, error) {
+	return ReadFrame(t.reader, t.maxFrameSize)
+}
+
+func (t *tcpWire) WriteMessage(data []byte) error {
+	return WriteFrame(t.con, data)
+}
+
+// WriteClose do nothing , the tcp protocol has no close frame
+func (t *tcpWire) WriteClose(code int, reason string) error {
+	return nil
+}
+
+func (t *tcpWire) Close() error {
+	return t.con.Close()
+}
+
+// ReadFrame read a length-prefixed frame from r , the client can use it to read the message
+func ReadFrame(r io.Reader, maxFrameSize int) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if maxFrameSize > 0 && length > uint32(maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteFrame write data as a length-prefixed frame to w , the client can use it to send message
+func WriteFrame(w io.Writer, data []byte) error {
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	buffers := net.Buffers{header, data}
+	_, err := buffers.WriteTo(w)
+	return err
+}
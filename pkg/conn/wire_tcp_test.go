@@ -0,0 +1,41 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package conn
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	for _, message := range []string{"hello", "", "world"} {
+		if err := WriteFrame(&buf, []byte(message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"hello", "", "world"} {
+		data, err := ReadFrame(&buf, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Fatalf("ReadFrame() got %q , want %q", data, want)
+		}
+	}
+	WriteFrame(&buf, []byte("too large frame"))
+	if _, err := ReadFrame(&buf, 8); err != ErrFrameTooLarge {
+		t.Fatalf("ReadFrame() error = '%v', wantErr '%v'", err, ErrFrameTooLarge)
+	}
+}
@@ -0,0 +1,381 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// dispatch 是上行消息的异步分发，连接读到的消息不再直接调用业务处理函数，而是放入有界的工作池中，
+// 这样业务处理慢不会阻塞连接的读取。工作池有两种模式：
+//   - 共享模式：每个连接有自己的信箱，有消息的信箱被任意空闲的 worker 取走，按顺序处理完之后释放
+//   - 分片模式：按照用户标识哈希到固定的 worker ，同一个用户的所有连接的消息都由同一个 worker 处理
+// 两种模式都可以保证同一个连接的消息按顺序处理。队列满了之后的处理方式由 Overflow 决定
+
+// Mode is the way of messages assigned to workers
+type Mode int
+
+const (
+	// ModeShared the message of connection can be handled by any worker , the connection has its
+	// own queue , and the QueueSize is the limit of each connection
+	ModeShared Mode = iota
+	// ModeSharded the message is handled by the worker chosen by the identification , each worker
+	// has its own queue , and the QueueSize is the limit of each worker
+	ModeSharded
+)
+
+// Overflow is what to do when the queue is full
+type Overflow int
+
+const (
+	// OverflowBlock block the goroutine submitting the message until the queue has space , it
+	// pushes back the client by the tcp flow control . The goroutine is the reader of connection
+	// on the gorilla backend , but it is the worker shared by many connections on the netpoll
+	// backend , so use OverflowDrop or OverflowDisconnect with the netpoll backend
+	OverflowBlock Overflow = iota
+	// OverflowDrop drop the message
+	OverflowDrop
+	// OverflowDisconnect drop the message and close the connection
+	OverflowDisconnect
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case OverflowDrop:
+		return "drop"
+	case OverflowDisconnect:
+		return "disconnect"
+	}
+	return "block"
+}
+
+const (
+	DefaultWorkers   = 1 << 6 // 64
+	DefaultQueueSize = 1 << 6 // 64
+)
+
+var (
+	ErrQueueIsFull  = errors.New("dispatch : the queue is full")
+	ErrPoolIsClosed = errors.New("dispatch : the pool is closed")
+)
+
+type Option struct {
+	Mode      Mode     // Mode default is ModeShared
+	Workers   int      // Workers the number of goroutines handling messages
+	QueueSize int      // QueueSize the max messages waiting , see Mode
+	Overflow  Overflow // Overflow default is OverflowBlock
+}
+
+func DefaultOption() *Option {
+	return &Option{
+		Mode:      ModeShared,
+		Workers:   DefaultWorkers,
+		QueueSize: DefaultQueueSize,
+		Overflow:  OverflowBlock,
+	}
+}
+
+func (o *Option) fix() *Option {
+	res := *o
+	if res.Workers <= 0 {
+		res.Workers = DefaultWorkers
+	}
+	if res.QueueSize <= 0 {
+		res.QueueSize = DefaultQueueSize
+	}
+	return &res
+}
+
+// Handler handle the message in worker
+type Handler func(cli conn.Connect, data []byte)
+
+type task struct {
+	cli  conn.Connect
+	data []byte
+}
+
+// mailbox is the queue of connection in shared mode , it is put in the ready queue when it
+// has messages and no worker is handling it , so that only one worker handle it at the same time
+type mailbox struct {
+	cli       conn.Connect
+	items     [][]byte
+	scheduled bool
+}
+
+// Pool is the bounded worker pool
+type Pool struct {
+	opt     *Option
+	handler Handler
+
+	// overflow is called when the message is refused because the queue is full
+	overflow func(cli conn.Connect, data []byte)
+
+	// shared mode , space is signaled when the mailbox has space , work is signaled when the
+	// mailbox is put in ready . Each mailbox is in ready at most once , so ready is bounded by
+	// the connections , and putting mailbox in it never blocks
+	mu    sync.Mutex
+	space *sync.Cond
+	work  *sync.Cond
+	boxes map[conn.Connect]*mailbox
+	ready []*mailbox
+
+	// sharded mode
+	shards []chan task
+
+	closed  bool
+	done    chan struct{}
+	workers sync.WaitGroup
+
+	pending, busy atomic.Int64
+}
+
+// NewPool create the pool and start the workers , overflow is called when the message is refused
+// because of the queue is full , it can be nil
+func NewPool(opt *Option, handler Handler, overflow func(cli conn.Connect, data []byte)) *Pool {
+	if opt == nil {
+		opt = DefaultOption()
+	}
+	p := &Pool{
+		opt:      opt.fix(),
+		handler:  handler,
+		overflow: overflow,
+		done:     make(chan struct{}),
+	}
+	p.space = sync.NewCond(&p.mu)
+	p.work = sync.NewCond(&p.mu)
+	if p.opt.Mode == ModeSharded {
+		p.shards = make([]chan task, p.opt.Workers)
+		for i := range p.shards {
+			p.shards[i] = make(chan task, p.opt.QueueSize)
+			p.workers.Add(1)
+			go p.shard(p.shards[i])
+		}
+		return p
+	}
+	p.boxes = map[conn.Connect]*mailbox{}
+	for i := 0; i < p.opt.Workers; i++ {
+		p.workers.Add(1)
+		go p.share()
+	}
+	return p
+}
+
+// Submit put the message into the queue of connection , the error is returned when the message
+// is refused
+func (p *Pool) Submit(cli conn.Connect, data []byte) error {
+	var err error
+	if p.opt.Mode == ModeSharded {
+		err = p.submitSharded(cli, data)
+	} else {
+		err = p.submitShared(cli, data)
+	}
+	if err == ErrQueueIsFull {
+		if p.opt.Overflow == OverflowDisconnect {
+			cli.Close("dispatch queue is full")
+		}
+		if p.overflow != nil {
+			p.overflow(cli, data)
+		}
+	}
+	return err
+}
+
+func (p *Pool) submitSharded(cli conn.Connect, data []byte) error {
+	h := fnv.New32a()
+	h.Write([]byte(cli.Identification()))
+	shard := p.shards[h.Sum32()%uint32(len(p.shards))]
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolIsClosed
+	}
+	// the pending is increased before unlock , so Close can wait for it
+	p.pending.Inc()
+	p.mu.Unlock()
+	t := task{cli: cli, data: data}
+	if p.opt.Overflow == OverflowBlock {
+		select {
+		case shard <- t:
+			return nil
+		case <-p.done:
+		}
+		p.pending.Dec()
+		return ErrPoolIsClosed
+	}
+	select {
+	case shard <- t:
+		return nil
+	default:
+	}
+	p.pending.Dec()
+	return ErrQueueIsFull
+}
+
+func (p *Pool) submitShared(cli conn.Connect, data []byte) error {
+	p.mu.Lock()
+	var box *mailbox
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return ErrPoolIsClosed
+		}
+		// the mailbox may be released by worker during waiting , so look up it every time
+		if box = p.boxes[cli]; box == nil {
+			box = &mailbox{cli: cli}
+			p.boxes[cli] = box
+		}
+		if len(box.items) < p.opt.QueueSize {
+			break
+		}
+		if p.opt.Overflow != OverflowBlock {
+			p.mu.Unlock()
+			return ErrQueueIsFull
+		}
+		p.space.Wait()
+	}
+	box.items = append(box.items, data)
+	p.pending.Inc()
+	if !box.scheduled {
+		box.scheduled = true
+		p.ready = append(p.ready, box)
+		p.work.Signal()
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Pool) shard(tasks chan task) {
+	defer p.workers.Done()
+	for {
+		select {
+		case t := <-tasks:
+			p.handle(t.cli, t.data)
+		case <-p.done:
+			// handle the messages left
+			for {
+				select {
+				case t := <-tasks:
+					p.handle(t.cli, t.data)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// share take the mailbox from ready and handle it , the mailboxes left are handled after the
+// pool is closed
+func (p *Pool) share() {
+	defer p.workers.Done()
+	for {
+		p.mu.Lock()
+		for len(p.ready) == 0 && !p.closed {
+			p.work.Wait()
+		}
+		if len(p.ready) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		box := p.ready[0]
+		p.ready[0] = nil
+		p.ready = p.ready[1:]
+		p.mu.Unlock()
+		p.drain(box)
+	}
+}
+
+// drain handle the messages of mailbox in order , the mailbox is released when it is empty
+func (p *Pool) drain(box *mailbox) {
+	for {
+		p.mu.Lock()
+		if len(box.items) == 0 {
+			box.scheduled = false
+			delete(p.boxes, box.cli)
+			p.mu.Unlock()
+			// wake up the submit waiting for the mailbox released
+			p.space.Broadcast()
+			return
+		}
+		data := box.items[0]
+		box.items[0] = nil
+		box.items = box.items[1:]
+		p.mu.Unlock()
+		p.space.Broadcast()
+		p.handle(box.cli, data)
+	}
+}
+
+// handle call the handler , the connection is closed when the handler panic , the same as the
+// message handled in the reader of connection
+func (p *Pool) handle(cli conn.Connect, data []byte) {
+	p.busy.Inc()
+	defer func() {
+		if err := recover(); err != nil {
+			logging.Log.Error("dispatch ", zap.String("ID", cli.Identification()), zap.Any("PANIC", err), zap.Stack("STACK"))
+			cli.Close("dispatch panic")
+		}
+		p.busy.Dec()
+		p.pending.Dec()
+	}()
+	p.handler(cli, data)
+}
+
+// Pending return the number of messages waiting or being handled
+func (p *Pool) Pending() int64 {
+	return p.pending.Load()
+}
+
+// Busy return the number of workers handling message
+func (p *Pool) Busy() int64 {
+	return p.busy.Load()
+}
+
+// Workers return the number of workers
+func (p *Pool) Workers() int {
+	return p.opt.Workers
+}
+
+// Close refuse the new message , and wait for the messages left handled or the ctx done
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+	p.space.Broadcast()
+	p.work.Broadcast()
+	close(p.done)
+	finished := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
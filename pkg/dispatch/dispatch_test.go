@@ -0,0 +1,172 @@
+package dispatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+type mockConn struct {
+	conn.Connect
+	id     string
+	closed bool
+}
+
+func (m *mockConn) Identification() string {
+	return m.id
+}
+
+func (m *mockConn) Close(reason string) {
+	m.closed = true
+}
+
+func TestPool_Order(t *testing.T) {
+	for _, mode := range []Mode{ModeShared, ModeSharded} {
+		t.Run(fmt.Sprint(mode), func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				received = map[conn.Connect][]int{}
+			)
+			p := NewPool(&Option{Mode: mode, Workers: 4, QueueSize: 8}, func(cli conn.Connect, data []byte) {
+				mu.Lock()
+				received[cli] = append(received[cli], int(data[0]))
+				mu.Unlock()
+			}, nil)
+			clients := []*mockConn{{id: "steven"}, {id: "mike"}, {id: "steven"}}
+			var wg sync.WaitGroup
+			for _, cli := range clients {
+				wg.Add(1)
+				go func(cli *mockConn) {
+					defer wg.Done()
+					for i := 0; i < 100; i++ {
+						if err := p.Submit(cli, []byte{byte(i)}); err != nil {
+							t.Error(err)
+						}
+					}
+				}(cli)
+			}
+			wg.Wait()
+			if err := p.Close(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			for _, cli := range clients {
+				got := received[cli]
+				if len(got) != 100 {
+					t.Fatalf("%v received %v messages , want 100", cli.id, len(got))
+				}
+				for i, v := range got {
+					if v != i {
+						t.Fatalf("%v received %v at %v , the order is broken", cli.id, v, i)
+					}
+				}
+			}
+			if p.Pending() != 0 {
+				t.Fatalf("Pending() = %v , want 0", p.Pending())
+			}
+		})
+	}
+}
+
+func TestPool_Overflow(t *testing.T) {
+	for _, mode := range []Mode{ModeShared, ModeSharded} {
+		t.Run(fmt.Sprint(mode), func(t *testing.T) {
+			release := make(chan struct{})
+			var overflow int
+			p := NewPool(&Option{Mode: mode, Workers: 1, QueueSize: 1, Overflow: OverflowDisconnect}, func(cli conn.Connect, data []byte) {
+				<-release
+			}, func(cli conn.Connect, data []byte) { overflow++ })
+			cli := &mockConn{id: "steven"}
+			// the first message is being handled , and the second is waiting in queue
+			p.Submit(cli, []byte("1"))
+			for p.Busy() != 1 {
+				time.Sleep(time.Millisecond)
+			}
+			p.Submit(cli, []byte("2"))
+			if err := p.Submit(cli, []byte("3")); err != ErrQueueIsFull || overflow != 1 || !cli.closed {
+				t.Fatalf("Submit() error = '%v' , overflow = %v , closed = %v", err, overflow, cli.closed)
+			}
+			close(release)
+			p.Close(context.Background())
+			if err := p.Submit(cli, []byte("4")); err != ErrPoolIsClosed {
+				t.Fatalf("Submit() error = '%v', wantErr '%v'", err, ErrPoolIsClosed)
+			}
+		})
+	}
+}
+
+func TestPool_Block(t *testing.T) {
+	release := make(chan struct{})
+	p := NewPool(&Option{Workers: 1, QueueSize: 1}, func(cli conn.Connect, data []byte) {
+		<-release
+	}, nil)
+	cli := &mockConn{id: "steven"}
+	p.Submit(cli, []byte("1"))
+	p.Submit(cli, []byte("2"))
+	submitted := make(chan error, 1)
+	go func() { submitted <- p.Submit(cli, []byte("3")) }()
+	select {
+	case <-submitted:
+		t.Fatal("Submit() should be blocked when the queue is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if err := <-submitted; err != nil {
+		t.Fatal(err)
+	}
+	p.Close(context.Background())
+}
+
+func TestPool_Panic(t *testing.T) {
+	for _, mode := range []Mode{ModeShared, ModeSharded} {
+		t.Run(fmt.Sprint(mode), func(t *testing.T) {
+			var handled int
+			p := NewPool(&Option{Mode: mode, Workers: 1}, func(cli conn.Connect, data []byte) {
+				if string(data) == "panic" {
+					panic("handler panic")
+				}
+				handled++
+			}, nil)
+			bad, good := &mockConn{id: "steven"}, &mockConn{id: "mike"}
+			p.Submit(bad, []byte("panic"))
+			p.Submit(good, []byte("hello"))
+			p.Close(context.Background())
+			if !bad.closed || good.closed || handled != 1 {
+				t.Fatalf("closed = %v , %v , handled = %v , only the connection panic should be closed", bad.closed, good.closed, handled)
+			}
+			if p.Pending() != 0 {
+				t.Fatalf("Pending() = %v , want 0", p.Pending())
+			}
+		})
+	}
+}
+
+func TestPool_ManyConnections(t *testing.T) {
+	release := make(chan struct{})
+	p := NewPool(&Option{Workers: 1, QueueSize: 1, Overflow: OverflowDrop}, func(cli conn.Connect, data []byte) {
+		<-release
+	}, nil)
+	// the mailboxes waiting are far more than Workers*QueueSize , the submit should not block
+	submitted := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := p.Submit(&mockConn{id: fmt.Sprint(i)}, []byte("hello")); err != nil {
+				t.Error(err)
+			}
+		}
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+		t.Fatal("Submit() is blocked by the mailboxes of other connections")
+	}
+	close(release)
+	p.Close(context.Background())
+	if p.Pending() != 0 {
+		t.Fatalf("Pending() = %v , want 0", p.Pending())
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package envelope
+
+import (
+	"encoding/binary"
+)
+
+// BinaryCodec encode the envelope in the compact binary format :
+//
+//	| type 1 byte | timestamp 8 bytes big endian | id length uvarint | id | method length uvarint | method | payload |
+//
+// the payload is the rest of frame , so it can be any bytes
+type BinaryCodec struct{}
+
+// the length of type and timestamp
+const binaryHeader = 1 + 8
+
+func (BinaryCodec) Encode(e *Envelope) ([]byte, error) {
+	if !e.Type.Valid() {
+		return nil, ErrUnknownType
+	}
+	res := make([]byte, binaryHeader, binaryHeader+2*binary.MaxVarintLen64+len(e.ID)+len(e.Method)+len(e.Payload))
+	res[0] = byte(e.Type)
+	binary.BigEndian.PutUint64(res[1:binaryHeader], uint64(e.Timestamp))
+	res = appendString(res, e.ID)
+	res = appendString(res, e.Method)
+	return append(res, e.Payload...), nil
+}
+
+func (BinaryCodec) Decode(data []byte) (*Envelope, error) {
+	if len(data) < binaryHeader+2 {
+		return nil, ErrBadEnvelope
+	}
+	ty := Type(data[0])
+	if !ty.Valid() {
+		return nil, ErrUnknownType
+	}
+	e := &Envelope{Type: ty, Timestamp: int64(binary.BigEndian.Uint64(data[1:binaryHeader]))}
+	rest, ok := readString(data[binaryHeader:], &e.ID)
+	if !ok {
+		return nil, ErrBadEnvelope
+	}
+	if rest, ok = readString(rest, &e.Method); !ok {
+		return nil, ErrBadEnvelope
+	}
+	if len(rest) > 0 {
+		e.Payload = append([]byte(nil), rest...)
+	}
+	return e, nil
+}
+
+// appendString append the length of s in uvarint and s
+func appendString(dst []byte, s string) []byte {
+	var length [binary.MaxVarintLen64]byte
+	dst = append(dst, length[:binary.PutUvarint(length[:], uint64(len(s)))]...)
+	return append(dst, s...)
+}
+
+// readString read the string written by appendString , and return the rest of data
+func readString(data []byte, s *string) ([]byte, bool) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data)-n) {
+		return nil, false
+	}
+	*s = string(data[n : n+int(length)])
+	return data[n+int(length):], true
+}
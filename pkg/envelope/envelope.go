@@ -0,0 +1,114 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package envelope
+
+import (
+	"errors"
+	"time"
+)
+
+// envelope 是可选的消息信封协议，每一条消息都带有类型、id、时间戳和负载，编码格式是可以替换的，
+// 默认提供了便于调试的JSON 格式和紧凑的二进制格式。心跳、确认、订阅标签、取消订阅、令牌续期和错误
+// 是内置的控制消息，由服务端自己处理，只有业务消息才会交给 Hooker ；请求和响应用于在连接上进行rpc
+// 调用，响应和请求的id 相同，调用失败时对端回复相同id 的错误消息
+
+// Type is the type of envelope
+type Type uint8
+
+const (
+	// TypeMessage is the application message , it is the only type handed to the hooker
+	TypeMessage Type = iota + 1
+	// TypeHeartbeat refresh the heartbeat time of connection , the server replies the heartbeat
+	TypeHeartbeat
+	// TypeAck is the ack of message , the ID is the id of message acked
+	TypeAck
+	// TypeSubscribe add the connection to the label , the payload is the tag
+	TypeSubscribe
+	// TypeUnsubscribe remove the connection from the label , the payload is the tag
+	TypeUnsubscribe
+	// TypeError tell the other side the message with ID is failed , the payload is the reason
+	TypeError
+	// TypeRequest call the Method of the other side , the response carries the same ID
+	TypeRequest
+	// TypeResponse is the result of request
+	TypeResponse
+	// TypeAuth refresh the token of connection before it expired , the payload is the new token ,
+	// the server replies the ack
+	TypeAuth
+)
+
+var typeNames = map[Type]string{
+	TypeMessage:     "message",
+	TypeHeartbeat:   "heartbeat",
+	TypeAck:         "ack",
+	TypeSubscribe:   "subscribe",
+	TypeUnsubscribe: "unsubscribe",
+	TypeError:       "error",
+	TypeRequest:     "request",
+	TypeResponse:    "response",
+	TypeAuth:        "auth",
+}
+
+func (t Type) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Valid return false when the type is unknown
+func (t Type) Valid() bool {
+	_, ok := typeNames[t]
+	return ok
+}
+
+// Control return true when the type is the built-in control message
+func (t Type) Control() bool {
+	return t.Valid() && t != TypeMessage
+}
+
+var (
+	// ErrBadEnvelope the frame can not be decoded as an envelope
+	ErrBadEnvelope = errors.New("envelope : bad envelope")
+	// ErrUnknownType the type of envelope is unknown
+	ErrUnknownType = errors.New("envelope : unknown type")
+)
+
+// Envelope is the message with type , id and timestamp
+type Envelope struct {
+	Type Type
+	// ID is set by the sender , the reply of control message carries the same id
+	ID string
+	// Method is the method called by request , it is empty for other types
+	Method string
+	// Timestamp is the unix milliseconds when the envelope created
+	Timestamp int64
+	Payload   []byte
+}
+
+// New create the envelope with the current timestamp
+func New(ty Type, id string, payload []byte) *Envelope {
+	return &Envelope{Type: ty, ID: id, Timestamp: time.Now().UnixNano() / int64(time.Millisecond), Payload: payload}
+}
+
+// Error create the error envelope replied to the message with id
+func Error(id string, err error) *Envelope {
+	return New(TypeError, id, []byte(err.Error()))
+}
+
+// Codec encode and decode the envelope , the implement must be safe for concurrent use
+type Codec interface {
+	Encode(e *Envelope) ([]byte, error)
+	Decode(data []byte) (*Envelope, error)
+}
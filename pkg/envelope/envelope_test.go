@@ -0,0 +1,50 @@
+package envelope
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSONCodec{}, "binary": BinaryCodec{}} {
+		t.Run(name, func(t *testing.T) {
+			for _, e := range []*Envelope{
+				New(TypeMessage, "1", []byte("hello\nworld")),
+				New(TypeHeartbeat, "", nil),
+				New(TypeSubscribe, "subscribe-1", []byte("room_2018")),
+				Error("2", ErrBadEnvelope),
+				{Type: TypeRequest, ID: "3", Method: "view.report", Timestamp: 1650000000000, Payload: []byte(`{"page":1}`)},
+			} {
+				data, err := codec.Encode(e)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := codec.Decode(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Type != e.Type || got.ID != e.ID || got.Method != e.Method || got.Timestamp != e.Timestamp || !bytes.Equal(got.Payload, e.Payload) {
+					t.Fatalf("Decode() = %+v , want %+v", got, e)
+				}
+			}
+			if _, err := codec.Encode(&Envelope{Type: 100}); err != ErrUnknownType {
+				t.Fatalf("Encode() error = '%v', wantErr '%v'", err, ErrUnknownType)
+			}
+			if _, err := codec.Decode([]byte("bad")); err == nil {
+				t.Fatal("Decode() should fail with the bad frame")
+			}
+		})
+	}
+}
+
+func TestBinaryCodec_Decode(t *testing.T) {
+	data, _ := BinaryCodec{}.Encode(New(TypeMessage, "id", []byte("payload")))
+	// the length of id is bigger than the frame
+	data[binaryHeader] = 100
+	if _, err := (BinaryCodec{}).Decode(data); err != ErrBadEnvelope {
+		t.Fatalf("Decode() error = '%v', wantErr '%v'", err, ErrBadEnvelope)
+	}
+	if _, err := (BinaryCodec{}).Decode([]byte{100, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); err != ErrUnknownType {
+		t.Fatalf("Decode() error = '%v', wantErr '%v'", err, ErrUnknownType)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package envelope
+
+import (
+	"encoding/json"
+)
+
+// JSONCodec encode the envelope as json , the type is written as its name , for example
+// {"type":"message","id":"1","ts":1650000000000,"payload":"hello"} , the payload should be text
+type JSONCodec struct{}
+
+type jsonEnvelope struct {
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	Method    string `json:"method,omitempty"`
+	Timestamp int64  `json:"ts,omitempty"`
+	Payload   string `json:"payload,omitempty"`
+}
+
+var typeValues = func() map[string]Type {
+	res := make(map[string]Type, len(typeNames))
+	for ty, name := range typeNames {
+		res[name] = ty
+	}
+	return res
+}()
+
+func (JSONCodec) Encode(e *Envelope) ([]byte, error) {
+	if !e.Type.Valid() {
+		return nil, ErrUnknownType
+	}
+	return json.Marshal(&jsonEnvelope{Type: e.Type.String(), ID: e.ID, Method: e.Method, Timestamp: e.Timestamp, Payload: string(e.Payload)})
+}
+
+func (JSONCodec) Decode(data []byte) (*Envelope, error) {
+	var res jsonEnvelope
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, ErrBadEnvelope
+	}
+	ty, ok := typeValues[res.Type]
+	if !ok {
+		return nil, ErrUnknownType
+	}
+	e := &Envelope{Type: ty, ID: res.ID, Method: res.Method, Timestamp: res.Timestamp}
+	if res.Payload != "" {
+		e.Payload = []byte(res.Payload)
+	}
+	return e, nil
+}
@@ -38,7 +38,8 @@ type Manager interface {
 	// 去协助调配label的增删改查
 	AddClient(tag string, client Client) (ForClient, error)
 
-	// List 获取当前存在的label ，获取label 列表信息
+	// List 获取当前存在的label ，获取label 列表信息，按照label 名称排序分页，page 从1 开始，limit
+	// 小于等于0 的时候返回全部
 	List(limit, page int) []*LabelInfo
 
 	// LabelInfo 获取具体label相信信息
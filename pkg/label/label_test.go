@@ -127,3 +127,27 @@ func TestTarget_Balance(t *testing.T) {
 		fmt.Println("------------------after shrinks to 4 group",tg.distribute())
 	})
 }
+
+const maxInt = int(^uint(0) >> 1)
+
+func TestManager_List(t *testing.T) {
+	Convey("测试label 列表分页", t, func() {
+		mg := NewManager()
+		for i := 0; i < 5; i++ {
+			_, err := mg.AddClient(fmt.Sprintf("label_%d", i), &MockClient{token: "1111"})
+			So(err, ShouldBeNil)
+		}
+		So(len(mg.List(0, 0)), ShouldEqual, 5)
+		page := mg.List(2, 2)
+		So(len(page), ShouldEqual, 2)
+		So(page[0].Name == "label_2" && page[1].Name == "label_3", ShouldBeTrue)
+		So(len(mg.List(2, 3)), ShouldEqual, 1)
+		So(len(mg.List(2, 4)), ShouldEqual, 0)
+		So(len(mg.List(maxInt, 2)), ShouldEqual, 0)
+		So(len(mg.List(maxInt, 1)), ShouldEqual, 5)
+		So(len(mg.List(maxInt/2+1, maxInt/2+1)), ShouldEqual, 0)
+		info, err := mg.LabelInfo("label_0")
+		So(err, ShouldBeNil)
+		So(info.Online, ShouldEqual, 1)
+	})
+}
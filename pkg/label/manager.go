@@ -16,6 +16,7 @@ package label
 import (
 	"context"
 	"math"
+	"sort"
 	"sync"
 	"time"
 
@@ -43,19 +44,22 @@ func Add (label string ,cli Client)(ForClient,error){
 }
 
 
+// NewManager create a label manager , every server should own its manager , the first
+// manager created will be used by the package function Add
 func NewManager() Manager {
-	if m ==nil {
-		m = &manager{
-			mp:        map[string]Label{},
-			rw:        &sync.RWMutex{},
-			limit:     DefaultCapacity,
-			watchTime: 20,
-			expansion: make(chan Label, 5),
-			shrinks:   make(chan Label, 5),
-			balance:   make(chan Label, 5),
-		}
+	res := &manager{
+		mp:        map[string]Label{},
+		rw:        &sync.RWMutex{},
+		limit:     DefaultCapacity,
+		watchTime: 20,
+		expansion: make(chan Label, 5),
+		shrinks:   make(chan Label, 5),
+		balance:   make(chan Label, 5),
+	}
+	if m == nil {
+		m = res
 	}
-	return m
+	return res
 }
 
 // Run 将target的需要长时间运行的内容返回出去执行
@@ -71,14 +75,14 @@ func (s *manager) AddClient(tag string, client Client) (ForClient, error) {
 }
 
 func (s *manager) List(limit, page int) []*LabelInfo {
-	return s.list()
+	return s.list(limit, page)
 }
 
 func (s *manager) LabelInfo(label string) (*LabelInfo, error) {
 	if label == "" {
 		return nil, errors.ErrBadParam
 	}
-	return s.LabelInfo(label)
+	return s.info(label)
 }
 
 func (s *manager) BroadCastByLabel(tc map[string][]byte) ([]string, error) {
@@ -108,18 +112,41 @@ func (s *manager) add(tag string, client Client) (ForClient, error) {
 		if err != nil {
 			return nil, err
 		}
+		ctag.Add(client)
 		s.mp[tag] = ctag
 		res = ctag
 	}
 	return res, nil
 }
 
-func (s *manager) list() []*LabelInfo {
+// list return the labels in the order of name , the page start from 1 , if the limit is
+// not bigger than 0 , all the labels will be returned
+func (s *manager) list(limit, page int) []*LabelInfo {
 	s.rw.RLock()
 	defer s.rw.RUnlock()
+	names := make([]string, 0, len(s.mp))
+	for name := range s.mp {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if limit > 0 {
+		if page < 1 {
+			page = 1
+		}
+		// the page and limit come from the request , check the page before multiplying , so the
+		// start will not overflow
+		if len(names) == 0 || page-1 > (len(names)-1)/limit {
+			return nil
+		}
+		start, end := (page-1)*limit, len(names)
+		if limit < end-start {
+			end = start + limit
+		}
+		names = names[start:end]
+	}
 	var res []*LabelInfo
-	for _, v := range s.mp {
-		res = append(res, v.Info())
+	for _, name := range names {
+		res = append(res, s.mp[name].Info())
 	}
 	return res
 }
@@ -146,6 +173,9 @@ func (s *manager) broadcast(cont []byte, tags ...string) (res []string) {
 					min = temN
 					mintg = v
 				}
+			} else {
+				// the intersection is empty when any of label is not exist
+				return
 			}
 		}
 		res = append(res, mintg.BroadCast(cont, tags...)...)
@@ -180,7 +210,8 @@ func (s *manager) monitor(ctx context.Context) error {
 	for {
 		select {
 		case <- ticker.C:
-			s.rw.RLock()
+			// the label should destroy will be deleted from the map , so use the write lock
+			s.rw.Lock()
 			for k, r := range s.mp {
 				st := r.Status()
 				switch st {
@@ -197,7 +228,7 @@ func (s *manager) monitor(ctx context.Context) error {
 					r.Destroy()
 				}
 			}
-			s.rw.RUnlock()
+			s.rw.Unlock()
 		case <- ctx.Done():
 			goto loop
 		}
@@ -116,6 +116,16 @@ func InitZapLogger(bug bool, ops ...OptionFunc) *log {
 	Log.Logger = zlog
 	return Log
 }
+// Infof log the message with template in info level
+func Infof(template string, args ...interface{}) {
+	Log.Sugar().Infof(template, args...)
+}
+
+// Error log the args in error level
+func Error(args ...interface{}) {
+	Log.Sugar().Error(args...)
+}
+
 func getZapEncoder() zapcore.EncoderConfig {
 	return zapcore.EncoderConfig{
 		TimeKey:        "time",
@@ -0,0 +1,367 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// metrics 是一个没有外部依赖的指标收集器，输出格式兼容prometheus 的text exposition format ，
+// 可以直接被prometheus 抓取。所有的指标类型的方法都支持nil 接收者，这样使用方在没有开启指标的
+// 时候不需要做额外的判断
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// DefaultBuckets is the default buckets of histogram , the unit is second
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry hold the metrics and write them in text exposition format
+type Registry struct {
+	rw         sync.RWMutex
+	names      map[string]bool
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.rw.Lock()
+	defer r.rw.Unlock()
+	if r.names[name] {
+		panic("metrics : duplicate metric name " + name)
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// Write write all the metrics to w in the order of register
+func (r *Registry) Write(w io.Writer) {
+	r.rw.RLock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.rw.RUnlock()
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	buf.Flush()
+}
+
+// Handler return the http handler to expose the metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+type desc struct {
+	name, help, typ string
+	labels          []string
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+}
+
+// ====================================== Counter ===============================
+
+// Counter is a value only goes up
+type Counter struct {
+	value atomic.Float64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	if c == nil || v < 0 {
+		return
+	}
+	c.value.Add(v)
+}
+
+func (c *Counter) Value() float64 {
+	if c == nil {
+		return 0
+	}
+	return c.value.Load()
+}
+
+// CounterVec is a set of counters with the same name and different label values
+type CounterVec struct {
+	desc
+	rw       sync.RWMutex
+	children map[string]*Counter
+	values   map[string][]string
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	res := &CounterVec{
+		desc:     desc{name: name, help: help, typ: typeCounter, labels: labels},
+		children: map[string]*Counter{},
+		values:   map[string][]string{},
+	}
+	r.register(name, res)
+	return res
+}
+
+// With return the counter of the label values , the number of values should be the same
+// as the labels
+func (v *CounterVec) With(values ...string) *Counter {
+	if v == nil {
+		return nil
+	}
+	key := strings.Join(values, "\xff")
+	v.rw.RLock()
+	c, ok := v.children[key]
+	v.rw.RUnlock()
+	if ok {
+		return c
+	}
+	v.rw.Lock()
+	defer v.rw.Unlock()
+	if c, ok = v.children[key]; !ok {
+		c = &Counter{}
+		v.children[key] = c
+		v.values[key] = values
+	}
+	return c
+}
+
+func (v *CounterVec) write(w io.Writer) {
+	v.header(w)
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, labelPairs(v.labels, v.values[key]), formatFloat(v.children[key].Value()))
+	}
+}
+
+// ====================================== Gauge ===============================
+
+// Gauge is a value can go up and down
+type Gauge struct {
+	value atomic.Float64
+}
+
+func (g *Gauge) Set(v float64) {
+	if g == nil {
+		return
+	}
+	g.value.Store(v)
+}
+
+func (g *Gauge) Add(v float64) {
+	if g == nil {
+		return
+	}
+	g.value.Add(v)
+}
+
+func (g *Gauge) Value() float64 {
+	if g == nil {
+		return 0
+	}
+	return g.value.Load()
+}
+
+type gauge struct {
+	desc
+	gauge *Gauge
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	res := &gauge{desc: desc{name: name, help: help, typ: typeGauge}, gauge: &Gauge{}}
+	r.register(name, res)
+	return res.gauge
+}
+
+func (g *gauge) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.gauge.Value()))
+}
+
+// gaugeFunc collect the values when the metrics is scraped , the key of the map returned is
+// the value of label
+type gaugeFunc struct {
+	desc
+	fn func() map[string]float64
+}
+
+// NewGaugeFunc register a gauge whose value is collected by fn when scraped
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &gaugeFunc{
+		desc: desc{name: name, help: help, typ: typeGauge},
+		fn:   func() map[string]float64 { return map[string]float64{"": fn()} },
+	})
+}
+
+// NewGaugeVecFunc register a set of gauges whose values are collected by fn when scraped ,
+// the key of the map returned by fn is the value of label
+func (r *Registry) NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(name, &gaugeFunc{desc: desc{name: name, help: help, typ: typeGauge, labels: []string{label}}, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w)
+	values := g.fn()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var pairs string
+		if len(g.labels) != 0 {
+			pairs = labelPairs(g.labels, []string{key})
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, pairs, formatFloat(values[key]))
+	}
+}
+
+// ====================================== Histogram ===============================
+
+// Histogram count the observed values in buckets
+type Histogram struct {
+	desc
+	buckets []float64
+	counts  []atomic.Uint64
+	sum     atomic.Float64
+	count   atomic.Uint64
+}
+
+// NewHistogram register a histogram , the DefaultBuckets is used when buckets is empty
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	res := &Histogram{
+		desc:    desc{name: name, help: help, typ: typeHistogram},
+		buckets: buckets,
+		counts:  make([]atomic.Uint64, len(buckets)),
+	}
+	r.register(name, res)
+	return res
+}
+
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	idx := sort.SearchFloat64s(h.buckets, v)
+	if idx < len(h.buckets) {
+		h.counts[idx].Inc()
+	}
+	h.sum.Add(v)
+	h.count.Inc()
+}
+
+// Count return the number of observed values
+func (h *Histogram) Count() uint64 {
+	if h == nil {
+		return 0
+	}
+	return h.count.Load()
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.header(w)
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), cumulative)
+	}
+	count := h.count.Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum.Load()))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, count)
+}
+
+// ====================================== format ===============================
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labelPairs(labels, values []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for i, label := range labels {
+		var value string
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, label+"=\""+escapeLabel(value)+"\"")
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	sent := r.NewCounter("sent_total", "The number of sent messages.")
+	upgrades := r.NewCounterVec("upgrades_total", "The number of upgrades.", "result")
+	online := r.NewGauge("online", "The number of online.")
+	r.NewGaugeVecFunc("bucket_online", "The online of bucket.", "bucket", func() map[string]float64 {
+		return map[string]float64{"bucket_1": 2, "bucket_0": 1}
+	})
+	latency := r.NewHistogram("latency_seconds", "The latency.", []float64{0.1, 1})
+
+	sent.Add(3)
+	sent.Add(-1)
+	upgrades.With("success").Inc()
+	upgrades.With("fail\"ure").Add(2)
+	online.Set(5)
+	online.Add(-1)
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(2)
+
+	var buf bytes.Buffer
+	r.Write(&buf)
+	want := `# HELP sent_total The number of sent messages.
+# TYPE sent_total counter
+sent_total 3
+# HELP upgrades_total The number of upgrades.
+# TYPE upgrades_total counter
+upgrades_total{result="fail\"ure"} 2
+upgrades_total{result="success"} 1
+# HELP online The number of online.
+# TYPE online gauge
+online 4
+# HELP bucket_online The online of bucket.
+# TYPE bucket_online gauge
+bucket_online{bucket="bucket_0"} 1
+bucket_online{bucket="bucket_1"} 2
+# HELP latency_seconds The latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.55
+latency_seconds_count 3
+`
+	if buf.String() != want {
+		t.Fatalf("Write() got \n%v\nwant \n%v", buf.String(), want)
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("sent_total", "The number of sent messages.").Inc()
+	recorder := httptest.NewRecorder()
+	r.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected content type %v", recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(recorder.Body.String(), "sent_total 1\n") {
+		t.Fatalf("unexpected body %v", recorder.Body.String())
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var (
+		c *Counter
+		g *Gauge
+		h *Histogram
+		v *CounterVec
+	)
+	c.Inc()
+	g.Set(1)
+	h.Observe(1)
+	v.With("a").Inc()
+	if c.Value() != 0 || g.Value() != 0 || h.Count() != 0 {
+		t.Fatal("nil metrics should record nothing")
+	}
+}
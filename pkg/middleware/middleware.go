@@ -0,0 +1,115 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// middleware 是上行消息处理链，每个中间件包裹下一个处理函数，可以在消息交给业务之前或者之后做一些
+// 通用的事情，比如异常恢复、刷新心跳、限制消息大小、链路追踪以及耗时统计。所有传输方式收到的消息
+// 都会经过同一条处理链，第一个中间件在最外层
+
+// Handler handle the message received by connection
+type Handler func(cli conn.Connect, data []byte)
+
+// Middleware wrap the next handler , it can do something before or after the next handler , or
+// stop the message by not calling the next handler
+type Middleware func(next Handler) Handler
+
+// Chain wrap the handler by middlewares , the first middleware is the outermost one
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// Recovery recover the panic of next handler and log it with the stack , the connection keeps
+// working after the panic , if close is true , the connection is closed
+func Recovery(close bool) Middleware {
+	return func(next Handler) Handler {
+		return func(cli conn.Connect, data []byte) {
+			defer func() {
+				if err := recover(); err != nil {
+					logging.Log.Error("recovery", zap.String("ID", cli.Identification()), zap.Any("PANIC", err), zap.Stack("STACK"))
+					if close {
+						cli.Close("panic in handler")
+					}
+				}
+			}()
+			next(cli, data)
+		}
+	}
+}
+
+// Heartbeat refresh the heartbeat time of connection when any message received , so the client
+// don't need to send the heartbeat when it is active
+func Heartbeat() Middleware {
+	return func(next Handler) Handler {
+		return func(cli conn.Connect, data []byte) {
+			cli.ReFlushHeartBeatTime()
+			next(cli, data)
+		}
+	}
+}
+
+// MaxSize drop the message larger than limit , exceeded is called with the message dropped if it
+// is not nil
+func MaxSize(limit int, exceeded func(cli conn.Connect, data []byte)) Middleware {
+	return func(next Handler) Handler {
+		return func(cli conn.Connect, data []byte) {
+			if len(data) > limit {
+				logging.Log.Warn("max size", zap.String("ID", cli.Identification()), zap.Int("SIZE", len(data)), zap.Int("LIMIT", limit))
+				if exceeded != nil {
+					exceeded(cli, data)
+				}
+				return
+			}
+			next(cli, data)
+		}
+	}
+}
+
+// Trace log each message in debug level with a trace id , the logs of the same message can be
+// found by the TRACE_ID
+func Trace() Middleware {
+	var seq atomic.Uint64
+	return func(next Handler) Handler {
+		return func(cli conn.Connect, data []byte) {
+			id, start := seq.Inc(), time.Now()
+			logging.Log.Debug("trace receive", zap.Uint64("TRACE_ID", id), zap.String("ID", cli.Identification()),
+				zap.String("DEVICE", cli.Device()), zap.Int("SIZE", len(data)))
+			next(cli, data)
+			logging.Log.Debug("trace handled", zap.Uint64("TRACE_ID", id), zap.String("ID", cli.Identification()),
+				zap.Duration("SPEND", time.Since(start)))
+		}
+	}
+}
+
+// Timing report the time spent by the next handler , the panic of next handler is not reported
+func Timing(observe func(cli conn.Connect, spend time.Duration)) Middleware {
+	return func(next Handler) Handler {
+		return func(cli conn.Connect, data []byte) {
+			start := time.Now()
+			next(cli, data)
+			observe(cli, time.Since(start))
+		}
+	}
+}
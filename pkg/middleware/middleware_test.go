@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+type mockConn struct {
+	conn.Connect
+	heartbeat int
+	closed    string
+}
+
+func (m *mockConn) Identification() string {
+	return "steven"
+}
+
+func (m *mockConn) Device() string {
+	return ""
+}
+
+func (m *mockConn) ReFlushHeartBeatTime() {
+	m.heartbeat++
+}
+
+func (m *mockConn) Close(reason string) {
+	m.closed = reason
+}
+
+func TestChain(t *testing.T) {
+	var trace []string
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(cli conn.Connect, data []byte) {
+				trace = append(trace, name)
+				next(cli, data)
+			}
+		}
+	}
+	var spend time.Duration = -1
+	handler := Chain(func(cli conn.Connect, data []byte) {
+		trace = append(trace, string(data))
+	}, Timing(func(cli conn.Connect, d time.Duration) { spend = d }), mark("first"), mark("second"), Trace())
+	handler(&mockConn{}, []byte("handler"))
+	if strings.Join(trace, ",") != "first,second,handler" || spend < 0 {
+		t.Fatalf("trace = %v , spend = %v", trace, spend)
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	var (
+		handled  []string
+		exceeded int
+	)
+	handler := Chain(func(cli conn.Connect, data []byte) {
+		if string(data) == "panic" {
+			panic("handler panic")
+		}
+		handled = append(handled, string(data))
+	}, Recovery(true), Heartbeat(), MaxSize(5, func(cli conn.Connect, data []byte) { exceeded++ }))
+	cli := &mockConn{}
+	handler(cli, []byte("hello"))
+	handler(cli, []byte("too large"))
+	if len(handled) != 1 || exceeded != 1 || cli.heartbeat != 2 {
+		t.Fatalf("handled = %v , exceeded = %v , heartbeat = %v", handled, exceeded, cli.heartbeat)
+	}
+	handler(cli, []byte("panic"))
+	if cli.closed == "" {
+		t.Fatal("the connection should be closed after panic")
+	}
+}
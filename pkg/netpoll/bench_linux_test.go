@@ -0,0 +1,140 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mongofs/sim/pkg/conn"
+	"go.uber.org/atomic"
+)
+
+// the number of connections of benchmark
+const benchConnections = 1000
+
+// BenchmarkBackend compare the memory and throughput of gorilla and netpoll , the clients are
+// the same in both cases , so the difference of bytes/conn and goroutines/conn is made by server .
+// run : go test -run none -bench Backend -benchmem ./pkg/netpoll
+func BenchmarkBackend(b *testing.B) {
+	for _, backend := range []string{"gorilla", "netpoll"} {
+		b.Run(backend, func(b *testing.B) {
+			benchBackend(b, backend)
+		})
+	}
+}
+
+func benchBackend(b *testing.B, backend string) {
+	var poller *Poller
+	if backend == "netpoll" {
+		p, err := NewPoller(nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer p.Close()
+		poller = p
+	}
+	sig := make(chan conn.Connect, benchConnections)
+	conns := make(chan conn.Connect, benchConnections)
+	receive := func(cli conn.Connect, data []byte) {}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		option := conn.DefaultOption()
+		option.Buffer = 1 << 10
+		var (
+			cli conn.Connect
+			err error
+		)
+		if poller != nil {
+			cli, err = poller.Upgrade("bench", "", sig, w, r, receive, option)
+		} else {
+			cli, err = conn.NewConn("bench", "", sig, w, r, receive, option)
+		}
+		if err == nil {
+			conns <- cli
+		}
+	}))
+	defer server.Close()
+
+	before := memory()
+	goroutines := runtime.NumGoroutine()
+
+	var (
+		received atomic.Int64
+		clients  []*websocket.Conn
+		servers  []conn.Connect
+		wg       sync.WaitGroup
+	)
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	for i := 0; i < benchConnections; i++ {
+		client, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		clients = append(clients, client)
+		servers = append(servers, <-conns)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, _, err := client.ReadMessage(); err != nil {
+					return
+				}
+				received.Inc()
+			}
+		}()
+	}
+	// wait for the goroutine of http server exit after hijacked
+	time.Sleep(100 * time.Millisecond)
+	bytesPerConn := float64(memory()-before) / benchConnections
+	goroutinesPerConn := float64(runtime.NumGoroutine()-goroutines-benchConnections) / benchConnections
+
+	message := []byte(fmt.Sprintf("%0128d", 0))
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		for _, cli := range servers {
+			for cli.Send(message) == conn.ErrConnectionIsWeak {
+				runtime.Gosched()
+			}
+		}
+	}
+	for received.Load() < int64(b.N*benchConnections) {
+		time.Sleep(time.Millisecond)
+	}
+	b.StopTimer()
+	// the metrics reported before ResetTimer are cleared , so report them here
+	b.ReportMetric(float64(b.N*benchConnections)/time.Since(start).Seconds(), "msg/s")
+	b.ReportMetric(bytesPerConn, "bytes/conn")
+	b.ReportMetric(goroutinesPerConn, "server-goroutines/conn")
+
+	for _, client := range clients {
+		client.Close()
+	}
+	wg.Wait()
+}
+
+// memory return the memory used by heap and stack after gc
+func memory() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse + stats.StackInuse
+}
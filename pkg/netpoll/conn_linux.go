@@ -0,0 +1,467 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// the size of buffer used by read , it is borrowed from pool and returned after read , so the
+// idle connection hold no read buffer
+const readBufferSize = 8 << 10
+
+var readBufferPool = sync.Pool{New: func() interface{} {
+	buf := make([]byte, readBufferSize)
+	return &buf
+}}
+
+// the client send the close frame , the connection is closed after the reply is written
+var errPeerClosed = errors.New("netpoll : closed by peer")
+
+// wsConn is the websocket connection managed by poller , it has no goroutine , the read and
+// write are done by the worker when the event happen or message sent . The worker running the
+// connection is the only one who use the fd , include closing it , so the fd is never used
+// after it is reused by other connection
+type wsConn struct {
+	conn.TagSet
+	conn.AttributeSet
+	conn.Heartbeat
+
+	poller         *Poller
+	netConn        net.Conn
+	fd             int
+	identification string
+	device         string
+	notify         chan<- conn.Connect
+	done           <-chan struct{}
+	receive        conn.Receive
+	opcode         byte
+	maxMessageSize int
+	metrics        *conn.Metrics
+
+	status     atomic.Int32
+	closeChan  chan struct{}
+	once       sync.Once
+	closing    atomic.Bool
+	closeCause atomic.String
+
+	// running is set when the connection is handed to worker , pending means there is event
+	// happened during running , so the worker should run again
+	running, pending, readable atomic.Bool
+
+	// the state of read , only accessed by the worker
+	in         []byte
+	fragment   []byte
+	fragmentOp byte
+	peerClosed bool
+
+	// the message waiting to be written , control is the encoded control frames
+	queue       *conn.Queue
+	mu          sync.Mutex
+	control     []byte
+	closeCode   int
+	closeReason string
+
+	// the state of write , only accessed by the worker
+	out        []byte
+	batch      []int
+	batchStart time.Time
+	closeSent  bool
+
+	shutdownOnce sync.Once
+	drainedOnce  sync.Once
+	drained      chan struct{}
+
+	// pingInterval is the interval of ping , the timer hold no goroutine when it is waiting ,
+	// the heartbeat is refreshed by any frame when the ping is turned on
+	pingInterval time.Duration
+	pingTimer    *time.Timer
+}
+
+func newConn(p *Poller, netConn net.Conn, fd int, Id, device string, sig chan<- conn.Connect, receive conn.Receive, option *conn.Option) *wsConn {
+	c := &wsConn{
+		poller:         p,
+		netConn:        netConn,
+		fd:             fd,
+		identification: Id,
+		device:         device,
+		notify:         sig,
+		done:           option.Done,
+		receive:        receive,
+		opcode:         opText,
+		maxMessageSize: p.opt.MaxMessageSize,
+		metrics:        option.Metrics,
+		closeChan:      make(chan struct{}),
+		drained:        make(chan struct{}),
+	}
+	if option.MessageType == conn.MessageTypeBinary {
+		c.opcode = opBinary
+	}
+	bp := option.Backpressure
+	c.queue = conn.NewQueue(option.Buffer, bp, option.Metrics, func(dropped []byte) {
+		if bp != nil && bp.Hook != nil {
+			bp.Hook(c, bp.Policy, dropped)
+		}
+	})
+	c.status.Store(conn.StatusConnectionRunning)
+	c.ReFlushHeartBeatTime()
+	if option.PingInterval > 0 {
+		c.pingInterval = option.PingInterval
+		c.mu.Lock()
+		c.pingTimer = time.AfterFunc(c.pingInterval, c.ping)
+		c.mu.Unlock()
+	}
+	return c
+}
+
+// ping enqueue the ping frame and rearm the timer , the frame is written by worker
+func (c *wsConn) ping() {
+	if c.status.Load() != conn.StatusConnectionRunning {
+		return
+	}
+	c.mu.Lock()
+	c.control = appendFrame(c.control, opPing, nil)
+	if c.pingTimer != nil {
+		c.pingTimer.Reset(c.pingInterval)
+	}
+	c.mu.Unlock()
+	c.schedule()
+}
+
+func (c *wsConn) Identification() string {
+	return c.identification
+}
+
+func (c *wsConn) Device() string {
+	return c.device
+}
+
+func (c *wsConn) Send(data []byte) error {
+	return c.SendPriority(data, conn.PriorityNormal)
+}
+
+// SendPriority put the message to the lane of priority , the high lane is written first
+func (c *wsConn) SendPriority(data []byte, priority conn.Priority) error {
+	if c.status.Load() != conn.StatusConnectionRunning {
+		return conn.ErrConnectionIsClosed
+	}
+	return c.pushed(c.queue.PushPriority(data, priority))
+}
+
+// SendBlock is the Send waiting for the room of queue when the policy is BackpressureBlock
+func (c *wsConn) SendBlock(data []byte) error {
+	if c.status.Load() != conn.StatusConnectionRunning {
+		return conn.ErrConnectionIsClosed
+	}
+	return c.pushed(c.queue.PushBlock(data))
+}
+
+// pushed handle the error of pushing message to the queue , the worker is scheduled when pushed
+func (c *wsConn) pushed(err error) error {
+	if err != nil {
+		if err == conn.ErrConnectionIsSlow {
+			c.Close("slow consumer")
+		}
+		return err
+	}
+	c.schedule()
+	return nil
+}
+
+// Close mark the connection is closing , the fd is closed by the worker
+func (c *wsConn) Close(reason string) {
+	if c.closing.CAS(false, true) {
+		c.closeCause.Store(reason)
+		c.status.Store(conn.StatusConnectionClosed)
+		c.schedule()
+	}
+}
+
+func (c *wsConn) Shutdown(ctx context.Context, code int, reason string) error {
+	c.shutdownOnce.Do(func() {
+		c.mu.Lock()
+		c.closeCode, c.closeReason = code, reason
+		c.mu.Unlock()
+		c.status.CAS(conn.StatusConnectionRunning, conn.StatusConnectionDraining)
+		c.schedule()
+	})
+	select {
+	case <-c.drained:
+		c.Close("shutdown")
+		return nil
+	case <-c.closeChan:
+		// the connection is closed by other reason during draining , the messages left are lost
+		select {
+		case <-c.drained:
+			return nil
+		default:
+			return conn.ErrConnectionIsClosed
+		}
+	case <-ctx.Done():
+		c.Close("shutdown forced")
+		return ctx.Err()
+	}
+}
+
+// schedule hand the connection to worker , if the connection is running on worker , the worker
+// will run it again after finished
+func (c *wsConn) schedule() {
+	c.pending.Store(true)
+	if c.running.CAS(false, true) {
+		c.poller.submit(c.run)
+	}
+}
+
+func (c *wsConn) run() {
+	for {
+		c.pending.Store(false)
+		c.process()
+		c.running.Store(false)
+		if !c.pending.Load() || !c.running.CAS(false, true) {
+			return
+		}
+	}
+}
+
+func (c *wsConn) process() {
+	defer func() {
+		if err := recover(); err != nil {
+			logging.Log.Error("netpoll process", zap.String("ID", c.identification), zap.Any("PANIC", err))
+			c.close("panic")
+		}
+	}()
+	select {
+	case <-c.closeChan:
+		return
+	default:
+	}
+	if c.closing.Load() {
+		c.close(c.closeCause.Load())
+		return
+	}
+	if c.readable.Swap(false) {
+		if err := c.read(); err != nil {
+			c.close("read", err)
+			return
+		}
+	}
+	if err := c.flush(); err != nil {
+		c.close("write", err)
+	}
+}
+
+// read read the data until EAGAIN and handle the whole frames , the incomplete frame is kept
+func (c *wsConn) read() error {
+	buf := readBufferPool.Get().(*[]byte)
+	var eof bool
+	for {
+		n, err := syscall.Read(c.fd, *buf)
+		if n > 0 {
+			c.in = append(c.in, (*buf)[:n]...)
+		}
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				break
+			}
+			readBufferPool.Put(buf)
+			return err
+		}
+		if n == 0 {
+			eof = true
+			break
+		}
+		if n < len(*buf) {
+			// the socket buffer is empty , the next data will trigger a new event
+			break
+		}
+	}
+	readBufferPool.Put(buf)
+
+	offset := 0
+	for !c.peerClosed {
+		f, n, err := parseFrame(c.in[offset:], c.maxMessageSize)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		offset += n
+		if err := c.handleFrame(f); err != nil {
+			return err
+		}
+	}
+	if offset == len(c.in) {
+		c.in = nil
+	} else if offset > 0 {
+		c.in = append([]byte(nil), c.in[offset:]...)
+	}
+	if eof && !c.peerClosed {
+		return io.EOF
+	}
+	return nil
+}
+
+func (c *wsConn) handleFrame(f frame) error {
+	if c.pingInterval > 0 {
+		// any frame of client proves the connection is alive
+		c.ReFlushHeartBeatTime()
+	}
+	switch f.opcode {
+	case opPing:
+		c.enqueueControl(opPong, f.payload)
+	case opPong:
+	case opClose:
+		payload := f.payload
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.enqueueControl(opClose, payload)
+		c.peerClosed = true
+	case opText, opBinary:
+		if c.fragment != nil {
+			return ErrProtocol
+		}
+		data := append([]byte(nil), f.payload...)
+		if !f.fin {
+			c.fragment, c.fragmentOp = data, f.opcode
+			return nil
+		}
+		c.receive(c, data)
+	case opContinuation:
+		if c.fragment == nil {
+			return ErrProtocol
+		}
+		if len(c.fragment)+len(f.payload) > c.maxMessageSize {
+			return ErrMessageTooLarge
+		}
+		c.fragment = append(c.fragment, f.payload...)
+		if f.fin {
+			data := c.fragment
+			c.fragment = nil
+			c.receive(c, data)
+		}
+	}
+	return nil
+}
+
+func (c *wsConn) enqueueControl(opcode byte, payload []byte) {
+	c.mu.Lock()
+	c.control = appendFrame(c.control, opcode, payload)
+	c.mu.Unlock()
+}
+
+// flush write the frames until EAGAIN , the rest will be written when the fd is writable . The
+// close frame is written after all the message are written when the connection is draining
+func (c *wsConn) flush() error {
+	for {
+		if len(c.out) == 0 {
+			c.finishBatch()
+			if c.peerClosed {
+				return errPeerClosed
+			}
+			if c.closeSent {
+				c.drainedOnce.Do(func() { close(c.drained) })
+				return nil
+			}
+			c.mu.Lock()
+			c.out = append(c.out, c.control...)
+			c.control = nil
+			for _, data := range c.queue.PopAll() {
+				c.out = appendFrame(c.out, c.opcode, data)
+				c.batch = append(c.batch, len(data))
+			}
+			if len(c.out) == 0 && c.status.Load() == conn.StatusConnectionDraining {
+				c.out = appendFrame(c.out, opClose, closePayload(c.closeCode, c.closeReason))
+				c.closeSent = true
+			}
+			c.mu.Unlock()
+			if len(c.out) == 0 {
+				c.out = nil
+				return nil
+			}
+			c.batchStart = time.Now()
+		}
+		n, err := syscall.Write(c.fd, c.out)
+		if n > 0 {
+			c.out = c.out[n:]
+		}
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				// wait for the fd is writable
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (c *wsConn) finishBatch() {
+	if len(c.batch) == 0 {
+		return
+	}
+	spend := time.Since(c.batchStart)
+	for _, size := range c.batch {
+		c.metrics.Sent(size, spend)
+	}
+	c.batch = c.batch[:0]
+}
+
+// close release the connection , it must be called by the worker running the connection
+func (c *wsConn) close(cause string, err ...error) {
+	c.once.Do(func() {
+		c.status.Store(conn.StatusConnectionClosed)
+		c.poller.remove(c.fd, c)
+		close(c.closeChan)
+		c.queue.Close()
+		c.mu.Lock()
+		if c.pingTimer != nil {
+			c.pingTimer.Stop()
+			c.pingTimer = nil
+		}
+		c.mu.Unlock()
+		if err := c.netConn.Close(); err != nil {
+			logging.Log.Error("close ", zap.String("ID", c.identification), zap.Error(err))
+		}
+		c.in, c.out, c.fragment, c.batch = nil, nil, nil, nil
+		// the worker should not be blocked by the bucket
+		go func() {
+			select {
+			case c.notify <- c:
+			case <-c.done:
+			}
+		}()
+		logging.Log.Info("close", zap.String("ID", c.identification), zap.String("OFFLINE_CAUSE", cause))
+	})
+}
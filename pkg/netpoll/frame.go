@@ -0,0 +1,125 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"encoding/binary"
+)
+
+// websocket 帧的编解码，见 RFC 6455 5.2 ，客户端发送的帧必须带掩码，服务端发送的帧不带掩码
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xa
+
+	finalBit = 0x80
+	maskBit  = 0x80
+
+	// the max payload length of control frame
+	maxControlPayload = 125
+)
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func isControl(opcode byte) bool {
+	return opcode&0x8 != 0
+}
+
+// parseFrame parse a client frame from buf , n is the number of bytes consumed , n is 0 when
+// the buf is not a whole frame . The payload is unmasked in place , so it refers to the buf
+func parseFrame(buf []byte, maxSize int) (f frame, n int, err error) {
+	if len(buf) < 2 {
+		return f, 0, nil
+	}
+	b0, b1 := buf[0], buf[1]
+	if b0&0x70 != 0 {
+		// the extension is not supported , so the rsv bits must be 0
+		return f, 0, ErrProtocol
+	}
+	if b1&maskBit == 0 {
+		return f, 0, ErrProtocol
+	}
+	f.fin, f.opcode = b0&finalBit != 0, b0&0xf
+	switch f.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return f, 0, ErrProtocol
+	}
+	offset, length := 2, uint64(b1&0x7f)
+	switch length {
+	case 126:
+		if len(buf) < offset+2 {
+			return f, 0, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[offset:]))
+		offset += 2
+	case 127:
+		if len(buf) < offset+8 {
+			return f, 0, nil
+		}
+		length = binary.BigEndian.Uint64(buf[offset:])
+		offset += 8
+	}
+	if isControl(f.opcode) && (!f.fin || length > maxControlPayload) {
+		return f, 0, ErrProtocol
+	}
+	if length > uint64(maxSize) {
+		return f, 0, ErrMessageTooLarge
+	}
+	if len(buf) < offset+4+int(length) {
+		return f, 0, nil
+	}
+	mask := buf[offset : offset+4]
+	offset += 4
+	f.payload = buf[offset : offset+int(length)]
+	for i := range f.payload {
+		f.payload[i] ^= mask[i&3]
+	}
+	return f, offset + int(length), nil
+}
+
+// appendFrame append a server frame to dst
+func appendFrame(dst []byte, opcode byte, payload []byte) []byte {
+	length := len(payload)
+	dst = append(dst, finalBit|opcode)
+	switch {
+	case length <= 125:
+		dst = append(dst, byte(length))
+	case length <= 0xffff:
+		dst = append(dst, 126, byte(length>>8), byte(length))
+	default:
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(length))
+		dst = append(append(dst, 127), size[:]...)
+	}
+	return append(dst, payload...)
+}
+
+// closePayload make the payload of close frame
+func closePayload(code int, reason string) []byte {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return append(payload, reason...)
+}
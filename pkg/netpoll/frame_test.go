@@ -0,0 +1,78 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"bytes"
+	"testing"
+)
+
+// clientFrame make a masked frame as client
+func clientFrame(fin bool, opcode byte, payload []byte) []byte {
+	frame := appendFrame(nil, opcode, payload)
+	if !fin {
+		frame[0] &^= finalBit
+	}
+	header := frame[:len(frame)-len(payload)]
+	mask := []byte{1, 2, 3, 4}
+	res := append(append([]byte(nil), header...), mask...)
+	res[1] |= maskBit
+	for i, b := range payload {
+		res = append(res, b^mask[i&3])
+	}
+	return res
+}
+
+func TestParseFrame(t *testing.T) {
+	large := bytes.Repeat([]byte("a"), 70000)
+	tests := []struct {
+		name    string
+		buf     []byte
+		max     int
+		opcode  byte
+		payload []byte
+		n       int
+		err     error
+	}{
+		{name: "text", buf: clientFrame(true, opText, []byte("hello")), max: 10, opcode: opText, payload: []byte("hello"), n: 11},
+		{name: "16 bit length", buf: clientFrame(true, opBinary, large[:300]), max: 1000, opcode: opBinary, payload: large[:300], n: 308},
+		{name: "64 bit length", buf: clientFrame(true, opBinary, large), max: 1 << 20, opcode: opBinary, payload: large, n: 70014},
+		{name: "incomplete", buf: clientFrame(true, opText, []byte("hello"))[:8], max: 10},
+		{name: "too large", buf: clientFrame(true, opText, []byte("hello")), max: 4, err: ErrMessageTooLarge},
+		{name: "not masked", buf: appendFrame(nil, opText, []byte("hello")), max: 10, err: ErrProtocol},
+		{name: "fragmented control", buf: clientFrame(false, opPing, nil), max: 10, err: ErrProtocol},
+		{name: "unknown opcode", buf: clientFrame(true, 0x3, nil), max: 10, err: ErrProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, n, err := parseFrame(tt.buf, tt.max)
+			if err != tt.err {
+				t.Fatalf("parseFrame() error = '%v', wantErr '%v'", err, tt.err)
+			}
+			if n != tt.n {
+				t.Fatalf("parseFrame() n = %v , want %v", n, tt.n)
+			}
+			if n != 0 && (f.opcode != tt.opcode || !bytes.Equal(f.payload, tt.payload)) {
+				t.Fatalf("parseFrame() got opcode %v , payload length %v", f.opcode, len(f.payload))
+			}
+		})
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// the example of RFC 6455 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("acceptKey() got %v", got)
+	}
+}
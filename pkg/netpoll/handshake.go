@@ -0,0 +1,80 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// the GUID used to compute the Sec-WebSocket-Accept , see RFC 6455 1.3
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// checkHandshake check the request is a legal websocket handshake and return the key
+func checkHandshake(r *http.Request) (string, error) {
+	if r.Method != http.MethodGet {
+		return "", ErrBadHandshake
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return "", ErrBadHandshake
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		return "", ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if key == "" {
+		return "", ErrBadHandshake
+	}
+	return key, nil
+}
+
+func headerContains(header http.Header, name, value string) bool {
+	for _, v := range header[http.CanonicalHeaderKey(name)] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// handshakeResponse return the response of handshake , protocol is the subprotocol negotiated ,
+// and the header is added to the response
+func handshakeResponse(key, protocol string, header http.Header) []byte {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	b.WriteString(acceptKey(key))
+	b.WriteString("\r\n")
+	if protocol != "" {
+		b.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+	for name, values := range header {
+		for _, value := range values {
+			// the line break in header would split the response
+			b.WriteString(name + ": " + strings.NewReplacer("\r", " ", "\n", " ").Replace(value) + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
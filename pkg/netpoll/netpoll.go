@@ -0,0 +1,75 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"errors"
+	"runtime"
+)
+
+// netpoll 是基于epoll 的事件循环实现的websocket 连接，也就是 conn/connect.go 中提到的 snetpoll ，
+// gorilla 的实现中每个连接都需要两个常驻的goroutine 以及读写缓冲，连接数量上来之后goroutine 的栈
+// 会占用大量的内存。这里握手和帧的编解码都是自己实现的，连接建立后将fd 注册到epoll ，空闲的连接不
+// 占用任何goroutine ，读写缓冲也只在有数据的时候才会分配，可读或者可写的事件触发之后，由一个固定
+// 大小的worker 池来完成读写，同一个连接同一时间只会在一个worker 上处理，所以消息的顺序是有保证的。
+// 需要注意的是 HandleReceive 是在worker 中调用的，不要在里面做耗时的操作，否则会影响其他连接
+//
+// 目前只支持linux ，其他平台 NewPoller 会返回 ErrNotSupported
+
+const (
+	DefaultQueue          = 1 << 12
+	DefaultMaxMessageSize = 1 << 20
+)
+
+var (
+	// the platform is not linux
+	ErrNotSupported = errors.New("netpoll : the platform is not supported")
+	// the poller is closed
+	ErrPollerClosed = errors.New("netpoll : the poller is closed")
+	// the connection can not get the fd , for example tls connection
+	ErrNotSyscallConn = errors.New("netpoll : the connection is not a syscall connection")
+
+	// the handshake request is not a legal websocket request
+	ErrBadHandshake = errors.New("netpoll : bad websocket handshake")
+	// the frame sent by client violate the protocol
+	ErrProtocol = errors.New("netpoll : websocket protocol error")
+	// the message sent by client is bigger than MaxMessageSize
+	ErrMessageTooLarge = errors.New("netpoll : message is too large")
+)
+
+type Option struct {
+	Workers        int // Workers the number of goroutines to read and write , default is the number of cpu
+	Queue          int // Queue the initial capacity of task queue of workers , it grows with the connections ready
+	MaxMessageSize int // MaxMessageSize the max size of message sent by client
+}
+
+func DefaultOption() *Option {
+	return &Option{
+		Workers:        runtime.NumCPU(),
+		Queue:          DefaultQueue,
+		MaxMessageSize: DefaultMaxMessageSize,
+	}
+}
+
+func (o *Option) fix() {
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+	if o.Queue <= 0 {
+		o.Queue = DefaultQueue
+	}
+	if o.MaxMessageSize <= 0 {
+		o.MaxMessageSize = DefaultMaxMessageSize
+	}
+}
@@ -0,0 +1,260 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+const (
+	// the events of connection , the connection is registered in edge triggered mode , so the
+	// worker need read and write until EAGAIN
+	epollET     = 1 << 31
+	connEvents  = syscall.EPOLLIN | syscall.EPOLLOUT | syscall.EPOLLRDHUP | epollET
+	closeEvents = syscall.EPOLLERR | syscall.EPOLLHUP | syscall.EPOLLRDHUP
+
+	// the time limit of epoll wait , the event loop check the poller is closed or not after timeout
+	waitTimeout = 100
+	maxEvents   = 1 << 10
+)
+
+// Poller is the event loop of connections , there is only one goroutine waiting the events of
+// all the connections , and the read and write are done by workers
+type Poller struct {
+	epfd   int
+	opt    *Option
+	closed atomic.Bool
+	quit   chan struct{}
+
+	// tasks is the queue of connections waiting for workers , each connection is in it at most
+	// once , so it is bounded by the connections , and submit never blocks or creates goroutine
+	mu    sync.Mutex
+	ready *sync.Cond
+	tasks []func()
+
+	rw    sync.RWMutex
+	conns map[int]*wsConn
+
+	wg sync.WaitGroup
+}
+
+// NewPoller create the poller and start the event loop and workers , if option is nil the
+// DefaultOption will be used
+func NewPoller(option *Option) (*Poller, error) {
+	if option == nil {
+		option = DefaultOption()
+	}
+	option.fix()
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &Poller{
+		epfd:  epfd,
+		opt:   option,
+		tasks: make([]func(), 0, option.Queue),
+		quit:  make(chan struct{}),
+		conns: map[int]*wsConn{},
+	}
+	p.ready = sync.NewCond(&p.mu)
+	p.wg.Add(option.Workers + 1)
+	for i := 0; i < option.Workers; i++ {
+		go p.worker()
+	}
+	go p.loop()
+	return p, nil
+}
+
+// Upgrade finish the websocket handshake of the request , then the connection is managed by
+// poller , the parameters are the same as conn.NewConn
+func (p *Poller) Upgrade(Id, device string, sig chan<- conn.Connect, w http.ResponseWriter, r *http.Request, receive conn.Receive, option *conn.Option) (conn.Connect, error) {
+	if p.closed.Load() {
+		return nil, ErrPollerClosed
+	}
+	key, err := checkHandshake(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	if option == nil {
+		option = conn.DefaultOption()
+	}
+	handshake := option.HandshakePolicy()
+	if !handshake.AllowOrigin(r) {
+		http.Error(w, conn.ErrOriginNotAllowed.Error(), http.StatusForbidden)
+		return nil, conn.ErrOriginNotAllowed
+	}
+	protocol, header := handshake.Negotiate(r), handshake.Header
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, ErrNotSyscallConn.Error(), http.StatusInternalServerError)
+		return nil, ErrNotSyscallConn
+	}
+	netConn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := netConn.(syscall.Conn)
+	if !ok {
+		netConn.Close()
+		return nil, ErrNotSyscallConn
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	fd := -1
+	if err := raw.Control(func(s uintptr) { fd = int(s) }); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if handshake != nil && handshake.Timeout > 0 {
+		netConn.SetWriteDeadline(time.Now().Add(handshake.Timeout))
+	}
+	if _, err := netConn.Write(handshakeResponse(key, protocol, header)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if handshake != nil && handshake.Timeout > 0 {
+		netConn.SetWriteDeadline(time.Time{})
+	}
+	c := newConn(p, netConn, fd, Id, device, sig, receive, option)
+	if protocol != "" {
+		c.SetAttribute(conn.AttrSubprotocol, protocol)
+	}
+	// the client may send frames right after the handshake , they are buffered by http server
+	if n := brw.Reader.Buffered(); n > 0 {
+		buffered, _ := brw.Reader.Peek(n)
+		c.in = append(c.in, buffered...)
+	}
+	p.rw.Lock()
+	p.conns[fd] = c
+	p.rw.Unlock()
+	if err := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{Events: connEvents, Fd: int32(fd)}); err != nil {
+		p.remove(fd, c)
+		netConn.Close()
+		return nil, err
+	}
+	// read the buffered frames and the data arrived before registered
+	c.readable.Store(true)
+	c.schedule()
+	return c, nil
+}
+
+// Close stop the event loop and workers , the connections should be closed before
+func (p *Poller) Close() error {
+	if !p.closed.CAS(false, true) {
+		return ErrPollerClosed
+	}
+	close(p.quit)
+	// wake up the workers waiting for tasks
+	p.mu.Lock()
+	p.ready.Broadcast()
+	p.mu.Unlock()
+	p.wg.Wait()
+	return syscall.Close(p.epfd)
+}
+
+// Online return the number of connections managed by poller
+func (p *Poller) Online() int {
+	p.rw.RLock()
+	defer p.rw.RUnlock()
+	return len(p.conns)
+}
+
+func (p *Poller) remove(fd int, c *wsConn) {
+	p.rw.Lock()
+	if p.conns[fd] == c {
+		delete(p.conns, fd)
+		syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+	}
+	p.rw.Unlock()
+}
+
+// submit hand the task to workers , the task is run by a new goroutine after the poller closed ,
+// so the connections left can still be closed
+func (p *Poller) submit(task func()) {
+	p.mu.Lock()
+	if p.closed.Load() {
+		p.mu.Unlock()
+		go task()
+		return
+	}
+	p.tasks = append(p.tasks, task)
+	p.mu.Unlock()
+	p.ready.Signal()
+}
+
+func (p *Poller) worker() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for len(p.tasks) == 0 && !p.closed.Load() {
+			p.ready.Wait()
+		}
+		if p.closed.Load() {
+			p.mu.Unlock()
+			return
+		}
+		task := p.tasks[0]
+		p.tasks[0] = nil
+		p.tasks = p.tasks[1:]
+		p.mu.Unlock()
+		task()
+	}
+}
+
+func (p *Poller) loop() {
+	defer p.wg.Done()
+	defer func() {
+		if err := recover(); err != nil {
+			logging.Log.Error("netpoll loop", zap.Any("PANIC", err))
+		}
+	}()
+	events := make([]syscall.EpollEvent, maxEvents)
+	for !p.closed.Load() {
+		n, err := syscall.EpollWait(p.epfd, events, waitTimeout)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			logging.Log.Error("netpoll loop", zap.Error(err))
+			return
+		}
+		p.rw.RLock()
+		for i := 0; i < n; i++ {
+			c, ok := p.conns[int(events[i].Fd)]
+			if !ok {
+				continue
+			}
+			if events[i].Events&(syscall.EPOLLIN|closeEvents) != 0 {
+				c.readable.Store(true)
+			}
+			c.schedule()
+		}
+		p.rw.RUnlock()
+	}
+}
@@ -0,0 +1,252 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+type testServer struct {
+	*httptest.Server
+	poller *Poller
+	sig    chan conn.Connect
+	conns  chan conn.Connect
+}
+
+// newTestServer create a server which upgrade the request by poller , receive is called in worker ,
+// the option of connection can be modified by options
+func newTestServer(t testing.TB, receive conn.Receive, options ...func(option *conn.Option)) *testServer {
+	poller, err := NewPoller(&Option{Workers: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := &testServer{poller: poller, sig: make(chan conn.Connect, 1024), conns: make(chan conn.Connect, 1024)}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		option := conn.DefaultOption()
+		option.Buffer = 1 << 10
+		for _, o := range options {
+			o(option)
+		}
+		cli, err := poller.Upgrade(r.URL.Query().Get("id"), "", ts.sig, w, r, receive, option)
+		if err != nil {
+			return
+		}
+		ts.conns <- cli
+	}))
+	t.Cleanup(func() {
+		ts.Server.Close()
+		poller.Close()
+	})
+	return ts
+}
+
+func (ts *testServer) dial(t testing.TB, id string) (*websocket.Conn, conn.Connect) {
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "?id=" + id
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cli := <-ts.conns:
+		return client, cli
+	case <-time.After(time.Second):
+		t.Fatal("the connection is not upgraded")
+	}
+	return nil, nil
+}
+
+func TestPoller_Echo(t *testing.T) {
+	ts := newTestServer(t, func(cli conn.Connect, data []byte) {
+		cli.Send(data)
+	})
+	client, cli := ts.dial(t, "steven")
+	defer client.Close()
+	if cli.Identification() != "steven" {
+		t.Fatalf("Identification() got %v", cli.Identification())
+	}
+	pong := make(chan string, 1)
+	client.SetPongHandler(func(data string) error {
+		pong <- data
+		return nil
+	})
+	// the large message is fragmented by the client
+	large := bytes.Repeat([]byte("sim"), 10000)
+	for _, message := range [][]byte{[]byte("hello"), large} {
+		if err := client.WriteMessage(websocket.TextMessage, message); err != nil {
+			t.Fatal(err)
+		}
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, message) {
+			t.Fatalf("echo got %v bytes , want %v bytes", len(data), len(message))
+		}
+	}
+	if err := client.WriteControl(websocket.PingMessage, []byte("ping"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// the pong handler is called by ReadMessage
+	go client.ReadMessage()
+	select {
+	case data := <-pong:
+		if data != "ping" {
+			t.Fatalf("pong got %v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pong is not received")
+	}
+}
+
+func TestPoller_Ping(t *testing.T) {
+	ts := newTestServer(t, func(cli conn.Connect, data []byte) {}, func(option *conn.Option) {
+		option.PingInterval = 10 * time.Millisecond
+	})
+	client, _ := ts.dial(t, "steven")
+	defer client.Close()
+	ping := make(chan struct{}, 16)
+	client.SetPingHandler(func(data string) error {
+		ping <- struct{}{}
+		return nil
+	})
+	go client.ReadMessage()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ping:
+		case <-time.After(time.Second):
+			t.Fatal("ping is not received")
+		}
+	}
+}
+
+func TestPoller_Handshake(t *testing.T) {
+	ts := newTestServer(t, func(cli conn.Connect, data []byte) {}, func(option *conn.Option) {
+		option.Handshake = &conn.Handshake{
+			AllowedOrigins: []string{"https://*.example.com"},
+			Subprotocols:   []string{"sim.v2", "sim.v1"},
+			Header:         http.Header{"X-Server": []string{"sim"}},
+			Timeout:        time.Second,
+		}
+	})
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "?id=steven"
+	if _, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.com"}}); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Dial() from the origin not allowed = %v", err)
+	}
+	dialer := &websocket.Dialer{Subprotocols: []string{"sim.v1", "sim.v2"}}
+	client, resp, err := dialer.Dial(url, http.Header{"Origin": []string{"https://chat.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	cli := <-ts.conns
+	if client.Subprotocol() != "sim.v2" || conn.StringAttribute(cli, conn.AttrSubprotocol) != "sim.v2" {
+		t.Fatalf("subprotocol = %q and %q , want sim.v2", client.Subprotocol(), conn.StringAttribute(cli, conn.AttrSubprotocol))
+	}
+	if resp.Header.Get("X-Server") != "sim" {
+		t.Fatal("the header of handshake response is not set")
+	}
+}
+
+func TestPoller_Close(t *testing.T) {
+	ts := newTestServer(t, func(cli conn.Connect, data []byte) {})
+	client, cli := ts.dial(t, "steven")
+	client.Close()
+	select {
+	case closed := <-ts.sig:
+		if closed != cli {
+			t.Fatal("the closed connection is not the same")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the connection is not closed after client closed")
+	}
+	if err := cli.Send([]byte("hello")); err != conn.ErrConnectionIsClosed {
+		t.Fatalf("Send() error = '%v', wantErr '%v'", err, conn.ErrConnectionIsClosed)
+	}
+	if ts.poller.Online() != 0 {
+		t.Fatalf("Online() got %v", ts.poller.Online())
+	}
+}
+
+func TestPoller_Shutdown(t *testing.T) {
+	ts := newTestServer(t, func(cli conn.Connect, data []byte) {})
+	client, cli := ts.dial(t, "steven")
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		if err := cli.Send([]byte("message")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan error, 1)
+	go func() { done <- cli.Shutdown(context.Background(), 4000, "bye") }()
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 3; i++ {
+		if _, data, err := client.ReadMessage(); err != nil || string(data) != "message" {
+			t.Fatalf("client received %q , err %v", data, err)
+		}
+	}
+	_, _, err := client.ReadMessage()
+	if ce, ok := err.(*websocket.CloseError); !ok || ce.Code != 4000 || ce.Text != "bye" {
+		t.Fatalf("client should receive the close frame , got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPoller_Submit(t *testing.T) {
+	p, err := NewPoller(&Option{Workers: 1, Queue: 1, MaxMessageSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	block := make(chan struct{})
+	p.submit(func() { <-block })
+	before := runtime.NumGoroutine()
+	var got []int
+	done := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		i := i
+		p.submit(func() {
+			got = append(got, i)
+			if i == 99 {
+				close(done)
+			}
+		})
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("submit should not create goroutines , before %d after %d", before, after)
+	}
+	close(block)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the tasks queued are not run")
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("the tasks are run out of order , got %v", got)
+		}
+	}
+}
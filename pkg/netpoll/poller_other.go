@@ -0,0 +1,42 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package netpoll
+
+import (
+	"net/http"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// Poller is only supported on linux
+type Poller struct{}
+
+func NewPoller(option *Option) (*Poller, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *Poller) Upgrade(Id, device string, sig chan<- conn.Connect, w http.ResponseWriter, r *http.Request, receive conn.Receive, option *conn.Option) (conn.Connect, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *Poller) Close() error {
+	return ErrNotSupported
+}
+
+func (p *Poller) Online() int {
+	return 0
+}
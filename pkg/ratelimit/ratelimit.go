@@ -0,0 +1,311 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimit
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/mongofs/sim/pkg/conn"
+	"go.uber.org/atomic"
+)
+
+// ratelimit 是上行消息的限流实现，基于令牌桶，分别限制每秒的消息数量和字节数量。限流有两个维度：
+// 单个连接和单个用户，用户的所有连接共享同一个用户维度的令牌桶。超过限制的消息根据 Action 进行处理，
+// 可以直接丢弃，丢弃并给客户端发送警告，或者断开连接
+
+// Action is what to do when the message exceeds the limit
+type Action int
+
+const (
+	// ActionDrop drop the message silently
+	ActionDrop Action = iota
+	// ActionWarn drop the message and send the warning frame to client , at most once in
+	// WarnInterval for each connection
+	ActionWarn
+	// ActionDisconnect drop the message and close the connection with the close code
+	ActionDisconnect
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionWarn:
+		return "warn"
+	case ActionDisconnect:
+		return "disconnect"
+	}
+	return "drop"
+}
+
+// Scope is the dimension of the limit exceeded
+type Scope string
+
+const (
+	ScopeConnection Scope = "connection"
+	ScopeUser       Scope = "user"
+)
+
+const (
+	DefaultWarning     = "rate limit exceeded"
+	DefaultCloseCode   = websocket.ClosePolicyViolation
+	DefaultCloseReason = "rate limit exceeded"
+
+	// WarnInterval the min interval of warnings sent to a connection , it is the window the rates
+	// of Limit are counted in , so the client flooding can't make the warnings pile up
+	WarnInterval = time.Second
+)
+
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Limit is the token bucket setting , the zero value of rate means unlimited . The burst is the
+// max tokens can be saved , it is the rate when it is zero , pay attention that the message
+// larger than ByteBurst is always refused
+type Limit struct {
+	Messages     float64 // Messages the messages allowed per second
+	MessageBurst int     // MessageBurst the max messages allowed at once
+	Bytes        float64 // Bytes the bytes allowed per second
+	ByteBurst    int     // ByteBurst the max bytes allowed at once
+}
+
+// Unlimited return true when neither the messages nor the bytes is limited
+func (l Limit) Unlimited() bool {
+	return l.Messages <= 0 && l.Bytes <= 0
+}
+
+// Quota is the limit of a connection and the user it belongs to
+type Quota struct {
+	Connection Limit // Connection the limit of each connection
+	User       Limit // User the limit shared by all the connections of user
+}
+
+type Option struct {
+	Quota
+	Action      Action // Action the action when the message exceeds the limit
+	Warning     []byte // Warning the frame sent to client when the action is ActionWarn
+	CloseCode   int    // CloseCode the code of close frame when the action is ActionDisconnect
+	CloseReason string // CloseReason the reason of close frame when the action is ActionDisconnect
+}
+
+func DefaultOption() *Option {
+	return &Option{
+		Action:      ActionDrop,
+		Warning:     []byte(DefaultWarning),
+		CloseCode:   DefaultCloseCode,
+		CloseReason: DefaultCloseReason,
+	}
+}
+
+func (o *Option) fix() *Option {
+	res := *o
+	if res.Warning == nil {
+		res.Warning = []byte(DefaultWarning)
+	}
+	if res.CloseCode == 0 {
+		res.CloseCode = DefaultCloseCode
+	}
+	if res.CloseReason == "" {
+		res.CloseReason = DefaultCloseReason
+	}
+	return &res
+}
+
+// Bucket is the token bucket , it is safe for concurrent use
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket create the bucket which is full , the burst is the rate when it is not positive
+func NewBucket(rate float64, burst int) *Bucket {
+	b := &Bucket{rate: rate, burst: float64(burst)}
+	if b.burst <= 0 {
+		b.burst = rate
+	}
+	b.tokens = b.burst
+	b.last = time.Now()
+	return b
+}
+
+// Allow take n tokens from bucket , it returns false when the tokens are not enough
+func (b *Bucket) Allow(n int) bool {
+	return b.allowAt(n, time.Now())
+}
+
+func (b *Bucket) allowAt(n int, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Limiter limit the messages and bytes , the nil Limiter allow everything
+type Limiter struct {
+	messages, bytes *Bucket
+}
+
+// NewLimiter create the limiter by limit , it returns nil when the limit is unlimited
+func NewLimiter(limit Limit) *Limiter {
+	if limit.Unlimited() {
+		return nil
+	}
+	l := &Limiter{}
+	if limit.Messages > 0 {
+		l.messages = NewBucket(limit.Messages, limit.MessageBurst)
+	}
+	if limit.Bytes > 0 {
+		l.bytes = NewBucket(limit.Bytes, limit.ByteBurst)
+	}
+	return l
+}
+
+// Allow check a message of size , the message token is not returned when the bytes is exceeded
+func (l *Limiter) Allow(size int) bool {
+	if l == nil {
+		return true
+	}
+	if l.messages != nil && !l.messages.Allow(1) {
+		return false
+	}
+	return l.bytes == nil || l.bytes.Allow(size)
+}
+
+type user struct {
+	limiter *Limiter
+	refs    int
+}
+
+type entry struct {
+	conn *Limiter
+	user *user
+	// disconnected is set when the connection is disconnected by ActionDisconnect
+	disconnected atomic.Bool
+	// warned is the unix nano of the last warning sent by ActionWarn
+	warned atomic.Int64
+}
+
+// Manager keep the limiters of connections and users , the limiter of user is removed when all
+// the connections of user are detached
+type Manager struct {
+	opt *Option
+
+	conns sync.Map // conn.Connect => *entry
+
+	mu    sync.Mutex
+	users map[string]*user
+}
+
+func NewManager(opt *Option) *Manager {
+	if opt == nil {
+		opt = DefaultOption()
+	}
+	return &Manager{opt: opt.fix(), users: map[string]*user{}}
+}
+
+// Option return the option of manager
+func (m *Manager) Option() *Option {
+	return m.opt
+}
+
+// Attach create the limiters of connection , if quota is nil , the quota of option will be used ,
+// the limiter of user is created by the first connection of user
+func (m *Manager) Attach(cli conn.Connect, quota *Quota) {
+	if quota == nil {
+		quota = &m.opt.Quota
+	}
+	identification := cli.Identification()
+	m.mu.Lock()
+	u, ok := m.users[identification]
+	if !ok {
+		u = &user{limiter: NewLimiter(quota.User)}
+		m.users[identification] = u
+	}
+	u.refs++
+	m.mu.Unlock()
+	m.conns.Store(cli, &entry{conn: NewLimiter(quota.Connection), user: u})
+}
+
+// Detach remove the limiters of connection , it is safe to detach the connection not attached
+func (m *Manager) Detach(cli conn.Connect) {
+	v, ok := m.conns.LoadAndDelete(cli)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u := v.(*entry).user
+	if u.refs--; u.refs == 0 {
+		delete(m.users, cli.Identification())
+	}
+}
+
+// Allow check the message of size received by connection , it returns the scope exceeded and
+// false when the message should be refused , the connection not attached is not limited
+func (m *Manager) Allow(cli conn.Connect, size int) (Scope, bool) {
+	v, ok := m.conns.Load(cli)
+	if !ok {
+		return "", true
+	}
+	e := v.(*entry)
+	if !e.conn.Allow(size) {
+		return ScopeConnection, false
+	}
+	if !e.user.limiter.Allow(size) {
+		return ScopeUser, false
+	}
+	return "", true
+}
+
+// Warn judge the warning should be sent to the connection or not , at most one warning is sent
+// in WarnInterval , the connection not attached is never warned
+func (m *Manager) Warn(cli conn.Connect) bool {
+	return m.warnAt(cli, time.Now())
+}
+
+func (m *Manager) warnAt(cli conn.Connect, now time.Time) bool {
+	v, ok := m.conns.Load(cli)
+	if !ok {
+		return false
+	}
+	e := v.(*entry)
+	last := e.warned.Load()
+	if last != 0 && now.Sub(time.Unix(0, last)) < WarnInterval {
+		return false
+	}
+	return e.warned.CAS(last, now.UnixNano())
+}
+
+// Disconnect mark the connection is disconnected by ActionDisconnect , it returns true only for
+// the first time , so the connection is closed once no matter how many messages exceed the limit
+func (m *Manager) Disconnect(cli conn.Connect) bool {
+	v, ok := m.conns.Load(cli)
+	if !ok {
+		return false
+	}
+	return v.(*entry).disconnected.CAS(false, true)
+}
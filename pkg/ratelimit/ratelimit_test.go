@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+type mockConn struct {
+	conn.Connect
+	id string
+}
+
+func (m *mockConn) Identification() string {
+	return m.id
+}
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(10, 2)
+	now := b.last
+	for i, want := range []bool{true, true, false} {
+		if got := b.allowAt(1, now); got != want {
+			t.Fatalf("the %v Allow() = %v , want %v", i, got, want)
+		}
+	}
+	// one token is refilled in 100ms
+	if !b.allowAt(1, now.Add(100*time.Millisecond)) || b.allowAt(1, now.Add(100*time.Millisecond)) {
+		t.Fatal("only one token should be refilled")
+	}
+	// the tokens can't exceed the burst
+	if b.allowAt(3, now.Add(time.Hour)) {
+		t.Fatal("the request larger than burst should be refused")
+	}
+}
+
+func TestManager(t *testing.T) {
+	m := NewManager(&Option{Quota: Quota{
+		Connection: Limit{Bytes: 0.001, ByteBurst: 10},
+		User:       Limit{Messages: 0.001, MessageBurst: 2},
+	}})
+	first, second, stranger := &mockConn{id: "steven"}, &mockConn{id: "steven"}, &mockConn{id: "mike"}
+	m.Attach(first, nil)
+	m.Attach(second, nil)
+	if _, ok := m.Allow(first, 11); ok {
+		t.Fatal("the message larger than the bytes of connection should be refused")
+	}
+	if _, ok := m.Allow(first, 5); !ok {
+		t.Fatal("the first message should be allowed")
+	}
+	if _, ok := m.Allow(second, 5); !ok {
+		t.Fatal("the second message should be allowed")
+	}
+	// the messages of user are shared by connections
+	if scope, ok := m.Allow(second, 5); ok || scope != ScopeUser {
+		t.Fatalf("Allow() = %v , %v , want the user limit exceeded", scope, ok)
+	}
+	if _, ok := m.Allow(stranger, 100); !ok {
+		t.Fatal("the connection not attached should not be limited")
+	}
+	now := time.Now()
+	if !m.warnAt(first, now) || m.warnAt(first, now.Add(WarnInterval/2)) || !m.warnAt(first, now.Add(WarnInterval)) {
+		t.Fatal("the warning should be sent once in WarnInterval")
+	}
+	if m.warnAt(stranger, now) {
+		t.Fatal("the connection not attached should not be warned")
+	}
+	if !m.Disconnect(first) || m.Disconnect(first) {
+		t.Fatal("Disconnect() should be true only for the first time")
+	}
+	if m.Disconnect(stranger) {
+		t.Fatal("Disconnect() should be false for the connection not attached")
+	}
+	m.Detach(first)
+	m.Detach(second)
+	m.Detach(stranger)
+	if len(m.users) != 0 {
+		t.Fatalf("the limiter of user should be removed , got %v", len(m.users))
+	}
+}
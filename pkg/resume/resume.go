@@ -0,0 +1,272 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resume
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// resume 是断线续传的实现，每个用户有一个会话，会话中的每一条下行消息都会分配一个单调递增的序号，
+// 并保存在一个有界的环形缓冲区中。客户端断线重连的时候带上会话id 和最后收到的序号，服务端只补发
+// 缺失的那部分消息；如果会话已经过期，或者缺失的消息已经被环形缓冲区淘汰，服务端会通知客户端进行
+// 全量同步。用户所有连接都断开之后，会话会继续保留 TTL 的时间，这期间发送给用户的消息同样会被记录
+
+const (
+	DefaultCapacity = 1 << 7 // 128
+	DefaultTTL      = 30 * time.Second
+)
+
+type Option struct {
+	Capacity int           // Capacity the max number of messages kept for each session
+	TTL      time.Duration // TTL the time to keep the session after all the connections of user closed
+	Codec    Codec         // Codec the format of message and control frame , default is prefix codec
+}
+
+func DefaultOption() *Option {
+	return &Option{
+		Capacity: DefaultCapacity,
+		TTL:      DefaultTTL,
+		Codec:    PrefixCodec{},
+	}
+}
+
+func (o *Option) fix() *Option {
+	res := *o
+	if res.Capacity <= 0 {
+		res.Capacity = DefaultCapacity
+	}
+	if res.TTL <= 0 {
+		res.TTL = DefaultTTL
+	}
+	if res.Codec == nil {
+		res.Codec = PrefixCodec{}
+	}
+	return &res
+}
+
+// Codec wrap the outbound message with sequence , and create the control frame which tell the
+// client the session it belongs to
+type Codec interface {
+	// Wrap put the sequence into the message , the result will be sent to client
+	Wrap(seq uint64, data []byte) []byte
+
+	// Control is the first frame sent to the connection , if resync is true , the client should
+	// do a full resync , because the messages after its last sequence are lost , seq is the
+	// sequence the client should continue from
+	Control(id string, seq uint64, resync bool) []byte
+}
+
+// PrefixCodec is the default codec , the message is "#seq:{seq}\n{data}" , the control frame is
+// "#session:{id}:{seq}" when resumed and "#resync:{id}:{seq}" when the client should resync
+type PrefixCodec struct{}
+
+func (PrefixCodec) Wrap(seq uint64, data []byte) []byte {
+	res := make([]byte, 0, 26+len(data))
+	res = append(res, "#seq:"...)
+	res = strconv.AppendUint(res, seq, 10)
+	res = append(res, '\n')
+	return append(res, data...)
+}
+
+func (PrefixCodec) Control(id string, seq uint64, resync bool) []byte {
+	prefix := "#session:"
+	if resync {
+		prefix = "#resync:"
+	}
+	res := append([]byte(prefix), id...)
+	res = append(res, ':')
+	return strconv.AppendUint(res, seq, 10)
+}
+
+// Cursor is presented by the reconnecting client , ID is the session id and Last is the last
+// sequence it received , the zero Cursor means a new connection without any state
+type Cursor struct {
+	ID   string
+	Last uint64
+}
+
+// session is the outbound sequence and replay ring of a user
+type session struct {
+	id  string
+	seq uint64
+	// ring keep the last messages , the sequence of ring[(head+i)%cap] is seq-len+1+i
+	ring [][]byte
+	head int
+	size int
+	// the number of connections online , the session is expired when it is zero for TTL
+	online    int
+	offlineAt time.Time
+}
+
+func (s *session) push(frame []byte) {
+	if s.size < len(s.ring) {
+		s.ring[(s.head+s.size)%len(s.ring)] = frame
+		s.size++
+		return
+	}
+	s.ring[s.head] = frame
+	s.head = (s.head + 1) % len(s.ring)
+}
+
+// since return the frames after last , it returns false when some of them are dropped
+func (s *session) since(last uint64) ([][]byte, bool) {
+	if last >= s.seq {
+		return nil, true
+	}
+	if s.seq-last > uint64(s.size) {
+		return nil, false
+	}
+	n := int(s.seq - last)
+	res := make([][]byte, 0, n)
+	for i := s.size - n; i < s.size; i++ {
+		res = append(res, s.ring[(s.head+i)%len(s.ring)])
+	}
+	return res, true
+}
+
+// Manager keep the sessions of users , it is safe for concurrent use
+type Manager struct {
+	mu       sync.Mutex
+	opt      *Option
+	sessions map[string]*session
+}
+
+func NewManager(opt *Option) *Manager {
+	if opt == nil {
+		opt = DefaultOption()
+	}
+	return &Manager{opt: opt.fix(), sessions: map[string]*session{}}
+}
+
+// Attach is called when a connection of user registered , the session is created when it is not
+// existed or expired . It returns the control frame should be sent to the connection first , and
+// the sequence to resend from , the messages after it should be resent by Since
+func (m *Manager) Attach(identification string, cursor Cursor) (control []byte, from uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.get(identification, time.Now())
+	if sess == nil {
+		sess = &session{id: newID(), ring: make([][]byte, m.opt.Capacity)}
+		m.sessions[identification] = sess
+	}
+	sess.online++
+	if cursor.ID != "" && cursor.ID == sess.id && cursor.Last <= sess.seq {
+		if _, ok := sess.since(cursor.Last); ok {
+			return m.opt.Codec.Control(sess.id, cursor.Last, false), cursor.Last
+		}
+	}
+	// the new connection without state just continue from the current sequence , and the
+	// connection with unknown session or too old sequence should do a full resync
+	return m.opt.Codec.Control(sess.id, sess.seq, cursor.ID != ""), sess.seq
+}
+
+// Detach is called when a connection of user closed , the session is kept TTL after the last
+// connection closed
+func (m *Manager) Detach(identification string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.sessions[identification]
+	if !ok || sess.online == 0 {
+		return
+	}
+	sess.online--
+	if sess.online == 0 {
+		sess.offlineAt = time.Now()
+	}
+}
+
+// Record assign the sequence to the message and keep it in the ring , send is called with the
+// wrapped frame under the lock , so the frames are sent in the order of sequence . It returns
+// false when the user has no session , the message is not recorded
+func (m *Manager) Record(identification string, data []byte, send func(frame []byte)) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.get(identification, time.Now())
+	if sess == nil {
+		return false
+	}
+	sess.seq++
+	frame := m.opt.Codec.Wrap(sess.seq, data)
+	sess.push(frame)
+	if send != nil {
+		send(frame)
+	}
+	return true
+}
+
+// Since return the frames after last and the sequence of the last frame , if some of them are
+// dropped from the ring , the control frame of resync is returned instead , the client should do
+// a full resync and continue from the current sequence . It returns false when the session is
+// not existed
+func (m *Manager) Since(identification string, last uint64) ([][]byte, uint64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess := m.get(identification, time.Now())
+	if sess == nil {
+		return nil, 0, false
+	}
+	frames, ok := sess.since(last)
+	if !ok {
+		return [][]byte{m.opt.Codec.Control(sess.id, sess.seq, true)}, sess.seq, true
+	}
+	return frames, sess.seq, true
+}
+
+// Sweep remove the expired sessions , it returns the number of sessions removed
+func (m *Manager) Sweep() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var (
+		counter int
+		now     = time.Now()
+	)
+	for identification := range m.sessions {
+		if m.get(identification, now) == nil {
+			counter++
+		}
+	}
+	return counter
+}
+
+// Len return the number of sessions
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// get return the session of user , the expired session is removed , it must be called with lock
+func (m *Manager) get(identification string, now time.Time) *session {
+	sess, ok := m.sessions[identification]
+	if !ok {
+		return nil
+	}
+	if sess.online == 0 && now.Sub(sess.offlineAt) > m.opt.TTL {
+		delete(m.sessions, identification)
+		return nil
+	}
+	return sess
+}
+
+func newID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
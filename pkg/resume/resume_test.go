@@ -0,0 +1,74 @@
+package resume
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	m := NewManager(&Option{Capacity: 3, TTL: 50 * time.Millisecond})
+	control, from := m.Attach("steven", Cursor{})
+	if from != 0 || string(control[:9]) != "#session:" {
+		t.Fatalf("Attach() = %q , %v", control, from)
+	}
+	id := string(control[9 : len(control)-2])
+	var sent []string
+	for i := 1; i <= 4; i++ {
+		m.Record("steven", []byte(strconv.Itoa(i)), func(frame []byte) { sent = append(sent, string(frame)) })
+	}
+	if len(sent) != 4 || sent[3] != "#seq:4\n4" {
+		t.Fatalf("sent %q", sent)
+	}
+	m.Detach("steven")
+	// the messages sent during the disconnection are recorded
+	m.Record("steven", []byte("5"), nil)
+
+	tests := []struct {
+		name    string
+		cursor  Cursor
+		control string
+		frames  []string
+	}{
+		{name: "resume", cursor: Cursor{ID: id, Last: 3}, control: "#session:" + id + ":3", frames: []string{"#seq:4\n4", "#seq:5\n5"}},
+		{name: "gap too old", cursor: Cursor{ID: id, Last: 1}, control: "#resync:" + id + ":5"},
+		{name: "unknown session", cursor: Cursor{ID: "unknown", Last: 3}, control: "#resync:" + id + ":5"},
+		{name: "new connection", cursor: Cursor{}, control: "#session:" + id + ":5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control, from := m.Attach("steven", tt.cursor)
+			defer m.Detach("steven")
+			if string(control) != tt.control {
+				t.Fatalf("Attach() control = %q , want %q", control, tt.control)
+			}
+			frames, seq, ok := m.Since("steven", from)
+			if !ok || seq != 5 || len(frames) != len(tt.frames) {
+				t.Fatalf("Since() = %q , %v , %v", frames, seq, ok)
+			}
+			for i := range frames {
+				if string(frames[i]) != tt.frames[i] {
+					t.Fatalf("Since() = %q , want %q", frames, tt.frames)
+				}
+			}
+		})
+	}
+
+	// the ring is overwritten during resuming , the client should resync
+	_, from = m.Attach("steven", Cursor{ID: id, Last: 4})
+	for i := 6; i <= 9; i++ {
+		m.Record("steven", []byte(strconv.Itoa(i)), nil)
+	}
+	if frames, _, _ := m.Since("steven", from); len(frames) != 1 || string(frames[0]) != "#resync:"+id+":9" {
+		t.Fatalf("Since() = %q , want resync", frames)
+	}
+	m.Detach("steven")
+
+	time.Sleep(60 * time.Millisecond)
+	if m.Sweep() != 1 || m.Len() != 0 {
+		t.Fatal("the expired session is not removed")
+	}
+	if m.Record("steven", []byte("10"), nil) {
+		t.Fatal("the message should not be recorded when the session expired")
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// DefaultMaxInflight is the max requests of a connection served at the same time
+const DefaultMaxInflight = 1 << 4 // 16
+
+var (
+	// ErrTooManyRequests the requests of connection served at the same time exceed the limit
+	ErrTooManyRequests = errors.New("rpc : too many requests in flight")
+	// ErrInternal the handler of method panic
+	ErrInternal = errors.New("rpc : internal error")
+)
+
+// session is the requests of connection being served , the ctx is canceled when the connection
+// closed
+type session struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running int
+}
+
+// Inflight limit the requests of each connection served at the same time , and cancel the
+// context of them when the connection closed , it is safe for concurrent use
+type Inflight struct {
+	max   int
+	mu    sync.Mutex
+	conns map[conn.Connect]*session
+}
+
+// NewInflight create the Inflight , if max is not positive , DefaultMaxInflight is used
+func NewInflight(max int) *Inflight {
+	if max <= 0 {
+		max = DefaultMaxInflight
+	}
+	return &Inflight{max: max, conns: map[conn.Connect]*session{}}
+}
+
+// Acquire take a place of the connection , the ctx returned is derived from parent and canceled
+// when the connection canceled , release must be called when the request finished .
+// ErrTooManyRequests is returned when the connection has no place
+func (f *Inflight) Acquire(parent context.Context, cli conn.Connect) (context.Context, func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.conns[cli]
+	if !ok {
+		ctx, cancel := context.WithCancel(parent)
+		s = &session{ctx: ctx, cancel: cancel}
+		f.conns[cli] = s
+	}
+	if s.running >= f.max {
+		return nil, nil, ErrTooManyRequests
+	}
+	s.running++
+	var once sync.Once
+	return s.ctx, func() { once.Do(func() { f.release(cli, s) }) }, nil
+}
+
+func (f *Inflight) release(cli conn.Connect, s *session) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s.running--
+	// the session is removed when it is idle , so the closed connection leave nothing
+	if s.running == 0 && f.conns[cli] == s {
+		delete(f.conns, cli)
+		s.cancel()
+	}
+}
+
+// Cancel cancel the context of requests of the connection , it returns the number of requests
+// being served
+func (f *Inflight) Cancel(cli conn.Connect) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.conns[cli]
+	if !ok {
+		return 0
+	}
+	delete(f.conns, cli)
+	s.cancel()
+	return s.running
+}
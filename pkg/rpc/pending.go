@@ -0,0 +1,140 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"go.uber.org/atomic"
+)
+
+// DefaultTimeout is the time limit of Call when the ctx has no deadline
+const DefaultTimeout = 10 * time.Second
+
+// call is the request waiting for response
+type call struct {
+	cli     conn.Connect
+	done    chan struct{}
+	payload []byte
+	err     error
+}
+
+// Pending keep the calls waiting for response , it is safe for concurrent use
+type Pending struct {
+	mu    sync.Mutex
+	seq   atomic.Uint64
+	calls map[string]*call
+	// the ids of calls of each connection , so the calls can be canceled when the connection closed
+	conns map[conn.Connect]map[string]struct{}
+}
+
+func NewPending() *Pending {
+	return &Pending{calls: map[string]*call{}, conns: map[conn.Connect]map[string]struct{}{}}
+}
+
+// Call send the request by send with a new id , and wait for the response resolved by Resolve ,
+// the call is failed when the ctx is done or the connection is canceled
+func (p *Pending) Call(ctx context.Context, cli conn.Connect, send func(id string) error) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultTimeout)
+		defer cancel()
+	}
+	id := strconv.FormatUint(p.seq.Inc(), 10)
+	c := &call{cli: cli, done: make(chan struct{})}
+	p.mu.Lock()
+	p.calls[id] = c
+	ids, ok := p.conns[cli]
+	if !ok {
+		ids = map[string]struct{}{}
+		p.conns[cli] = ids
+	}
+	ids[id] = struct{}{}
+	p.mu.Unlock()
+	defer p.remove(id)
+
+	if err := send(id); err != nil {
+		return nil, err
+	}
+	select {
+	case <-c.done:
+		return c.payload, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Resolve finish the call with the response of connection , it returns false when the call is
+// not existed or not belong to the connection
+func (p *Pending) Resolve(cli conn.Connect, id string, payload []byte, err error) bool {
+	p.mu.Lock()
+	c, ok := p.calls[id]
+	if !ok || c.cli != cli {
+		p.mu.Unlock()
+		return false
+	}
+	p.delete(id, c)
+	p.mu.Unlock()
+	c.payload, c.err = payload, err
+	close(c.done)
+	return true
+}
+
+// Cancel fail all the calls of the connection with ErrConnectionClosed , it returns the number
+// of calls canceled
+func (p *Pending) Cancel(cli conn.Connect) int {
+	p.mu.Lock()
+	var canceled []*call
+	for id := range p.conns[cli] {
+		c := p.calls[id]
+		p.delete(id, c)
+		canceled = append(canceled, c)
+	}
+	p.mu.Unlock()
+	for _, c := range canceled {
+		c.err = ErrConnectionClosed
+		close(c.done)
+	}
+	return len(canceled)
+}
+
+// Len return the number of calls waiting for response
+func (p *Pending) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.calls)
+}
+
+func (p *Pending) remove(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.calls[id]; ok {
+		p.delete(id, c)
+	}
+}
+
+// delete remove the call , it must be called with lock
+func (p *Pending) delete(id string, c *call) {
+	delete(p.calls, id)
+	if ids, ok := p.conns[c.cli]; ok {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(p.conns, c.cli)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// rpc 是建立在连接上的请求/响应调用，请求和响应通过相同的id 关联。客户端可以调用服务端注册的方法，
+// 服务端也可以通过 Pending 调用客户端的方法并等待结果，连接关闭的时候，等待中的调用会立即返回错误。
+// 方法的处理函数可以是原始的字节，也可以是带类型的结构体，带类型的参数和结果使用JSON 编码
+
+var (
+	// ErrMethodNotFound the method is not registered
+	ErrMethodNotFound = errors.New("rpc : method not found")
+	// ErrMethodExists the method is registered already
+	ErrMethodExists = errors.New("rpc : method is registered")
+	// ErrBadHandler the handler is not the supported function
+	ErrBadHandler = errors.New("rpc : handler must be func(context.Context, conn.Connect, Request) (Response, error)")
+	// ErrConnectionClosed the connection is closed before the response arrived
+	ErrConnectionClosed = errors.New("rpc : the connection is closed")
+)
+
+// RemoteError is the error replied by the other side
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return "rpc : remote error : " + string(e)
+}
+
+// Handler is the raw handler of method , the payload is the payload of request , the result
+// will be the payload of response
+type Handler func(ctx context.Context, cli conn.Connect, payload []byte) ([]byte, error)
+
+// Router keep the methods registered , it is safe for concurrent use
+type Router struct {
+	rw      sync.RWMutex
+	methods map[string]Handler
+}
+
+func NewRouter() *Router {
+	return &Router{methods: map[string]Handler{}}
+}
+
+// Register register the handler of method , the handler can be Handler , or the typed function
+// func(context.Context, conn.Connect, Request) (Response, error) , the Request is decoded from
+// the payload by JSON , and the Response is encoded by JSON
+func (r *Router) Register(method string, handler interface{}) error {
+	h, err := handlerOf(handler)
+	if err != nil {
+		return err
+	}
+	r.rw.Lock()
+	defer r.rw.Unlock()
+	if _, ok := r.methods[method]; ok {
+		return ErrMethodExists
+	}
+	r.methods[method] = h
+	return nil
+}
+
+// Serve call the handler of method
+func (r *Router) Serve(ctx context.Context, cli conn.Connect, method string, payload []byte) ([]byte, error) {
+	r.rw.RLock()
+	h, ok := r.methods[method]
+	r.rw.RUnlock()
+	if !ok {
+		return nil, ErrMethodNotFound
+	}
+	return h(ctx, cli, payload)
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	connectType = reflect.TypeOf((*conn.Connect)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// handlerOf convert the typed function to Handler
+func handlerOf(handler interface{}) (Handler, error) {
+	switch h := handler.(type) {
+	case Handler:
+		return h, nil
+	case func(context.Context, conn.Connect, []byte) ([]byte, error):
+		return h, nil
+	}
+	fn := reflect.ValueOf(handler)
+	ty := fn.Type()
+	if ty.Kind() != reflect.Func || ty.NumIn() != 3 || ty.NumOut() != 2 || ty.In(0) != contextType ||
+		ty.In(1) != connectType || ty.Out(1) != errorType {
+		return nil, ErrBadHandler
+	}
+	request := ty.In(2)
+	return func(ctx context.Context, cli conn.Connect, payload []byte) ([]byte, error) {
+		// the pointer request is decoded to a new value , so the handler can modify it
+		var arg reflect.Value
+		if request.Kind() == reflect.Ptr {
+			arg = reflect.New(request.Elem())
+		} else {
+			arg = reflect.New(request)
+		}
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, arg.Interface()); err != nil {
+				return nil, err
+			}
+		}
+		if request.Kind() != reflect.Ptr {
+			arg = arg.Elem()
+		}
+		out := fn.Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(&cli).Elem(), arg})
+		if err, _ := out[1].Interface().(error); err != nil {
+			return nil, err
+		}
+		return json.Marshal(out[0].Interface())
+	}, nil
+}
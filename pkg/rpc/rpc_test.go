@@ -0,0 +1,148 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+type request struct {
+	A, B int
+}
+
+type response struct {
+	Sum int
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	if err := r.Register("sum", func(ctx context.Context, cli conn.Connect, req *request) (*response, error) {
+		if req.A < 0 {
+			return nil, errors.New("negative")
+		}
+		return &response{Sum: req.A + req.B}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("echo", func(ctx context.Context, cli conn.Connect, payload []byte) ([]byte, error) {
+		return payload, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register("echo", Handler(nil)); err != ErrMethodExists {
+		t.Fatalf("Register() error = '%v', wantErr '%v'", err, ErrMethodExists)
+	}
+	if err := r.Register("bad", func(req *request) error { return nil }); err != ErrBadHandler {
+		t.Fatalf("Register() error = '%v', wantErr '%v'", err, ErrBadHandler)
+	}
+
+	tests := []struct {
+		method  string
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{method: "sum", payload: `{"A":1,"B":2}`, want: `{"Sum":3}`},
+		{method: "sum", payload: `{"A":-1}`, wantErr: true},
+		{method: "sum", payload: `bad`, wantErr: true},
+		{method: "echo", payload: "hello", want: "hello"},
+		{method: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := r.Serve(context.Background(), nil, tt.method, []byte(tt.payload))
+		if (err != nil) != tt.wantErr || string(got) != tt.want {
+			t.Fatalf("Serve(%v , %v) = %s , %v", tt.method, tt.payload, got, err)
+		}
+	}
+}
+
+// fakeConn is the connection used as the key of pending calls
+type fakeConn struct {
+	conn.Connect
+}
+
+func TestPending(t *testing.T) {
+	p := NewPending()
+	cli, other := &fakeConn{}, &fakeConn{}
+	ids := make(chan string, 1)
+	send := func(id string) error {
+		ids <- id
+		return nil
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		payload, err := p.Call(context.Background(), cli, send)
+		if err == nil && string(payload) != "pong" {
+			err = errors.New("unexpected payload " + string(payload))
+		}
+		result <- err
+	}()
+	id := <-ids
+	if p.Resolve(other, id, []byte("pong"), nil) {
+		t.Fatal("the call should not be resolved by other connection")
+	}
+	p.Resolve(cli, id, []byte("pong"), nil)
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		_, err := p.Call(context.Background(), cli, send)
+		result <- err
+	}()
+	<-ids
+	if n := p.Cancel(cli); n != 1 {
+		t.Fatalf("Cancel() = %v , want 1", n)
+	}
+	if err := <-result; err != ErrConnectionClosed {
+		t.Fatalf("Call() error = '%v', wantErr '%v'", err, ErrConnectionClosed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Call(ctx, cli, send); err != context.DeadlineExceeded {
+		t.Fatalf("Call() error = '%v', wantErr '%v'", err, context.DeadlineExceeded)
+	}
+	<-ids
+	if p.Len() != 0 {
+		t.Fatalf("Len() = %v , the calls finished should be removed", p.Len())
+	}
+}
+
+func TestInflight(t *testing.T) {
+	f := NewInflight(2)
+	cli, other := &fakeConn{}, &fakeConn{}
+	ctx, release, err := f.Acquire(context.Background(), cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.Acquire(context.Background(), cli); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.Acquire(context.Background(), cli); err != ErrTooManyRequests {
+		t.Fatalf("Acquire() error = '%v', wantErr '%v'", err, ErrTooManyRequests)
+	}
+	if _, _, err := f.Acquire(context.Background(), other); err != nil {
+		t.Fatalf("the limit should be of each connection , Acquire() error = '%v'", err)
+	}
+	release()
+	release() // release twice should not free two places
+	if _, _, err := f.Acquire(context.Background(), cli); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := f.Acquire(context.Background(), cli); err != ErrTooManyRequests {
+		t.Fatalf("Acquire() error = '%v', wantErr '%v'", err, ErrTooManyRequests)
+	}
+	if n := f.Cancel(cli); n != 2 {
+		t.Fatalf("Cancel() = %v , want 2", n)
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("the context of requests should be canceled when the connection canceled")
+	}
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/zap"
+)
+
+// fileStore keep the messages of each user in a single file , so the messages will not lose
+// when the process restart . The file is made up of records , each record is 8 bytes expire
+// time , 4 bytes length and the content of message . The files of users who never come back are
+// swept every ttl
+type fileStore struct {
+	rw       sync.Mutex
+	dir      string
+	capacity int
+	ttl      time.Duration
+	closed   bool
+	done     chan struct{}
+}
+
+const fileSuffix = ".msg"
+
+func NewFileStore(dir string, capacity int, ttl time.Duration) (MessageStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	capacity, ttl = fix(capacity, ttl)
+	f := &fileStore{dir: dir, capacity: capacity, ttl: ttl, done: make(chan struct{})}
+	go f.sweep()
+	return f, nil
+}
+
+func (f *fileStore) Save(identification string, data []byte) error {
+	if identification == "" {
+		return ErrIdentificationIsEmpty
+	}
+	now := time.Now().UnixNano()
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.closed {
+		return ErrStoreIsClosed
+	}
+	path := f.path(identification)
+	messages, err := read(path)
+	if err != nil {
+		return err
+	}
+	messages = append(messages, next(messages, now+int64(f.ttl), data))
+	return write(path, filter(messages, f.capacity, now))
+}
+
+func (f *fileStore) Peek(identification string) ([]Message, error) {
+	now := time.Now().UnixNano()
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.closed {
+		return nil, ErrStoreIsClosed
+	}
+	messages, err := read(f.path(identification))
+	if err != nil {
+		return nil, err
+	}
+	return peek(messages, f.capacity, now), nil
+}
+
+func (f *fileStore) Ack(identification string, offset uint64) error {
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.closed {
+		return ErrStoreIsClosed
+	}
+	path := f.path(identification)
+	messages, err := read(path)
+	if err != nil {
+		return err
+	}
+	return write(path, ack(messages, offset))
+}
+
+func (f *fileStore) Pop(identification string) ([][]byte, error) {
+	now := time.Now().UnixNano()
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.closed {
+		return nil, ErrStoreIsClosed
+	}
+	path := f.path(identification)
+	messages, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var res [][]byte
+	for _, msg := range filter(messages, f.capacity, now) {
+		res = append(res, msg.data)
+	}
+	return res, nil
+}
+
+func (f *fileStore) Close() error {
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.done)
+	}
+	return nil
+}
+
+// the file name is the sha256 of identification , so any identification can be used and the
+// length of file name is fixed
+func (f *fileStore) path(identification string) string {
+	sum := sha256.Sum256([]byte(identification))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+fileSuffix)
+}
+
+// sweep remove the expired messages of all the files every ttl until the store closed
+func (f *fileStore) sweep() {
+	ticker := time.NewTicker(f.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			if err := f.clean(time.Now().UnixNano()); err != nil {
+				logging.Log.Warn("fileStore sweep", zap.String("DIR", f.dir), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (f *fileStore) clean(now int64) error {
+	f.rw.Lock()
+	defer f.rw.Unlock()
+	if f.closed {
+		return nil
+	}
+	dir, err := os.Open(f.dir)
+	if err != nil {
+		return err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		path := filepath.Join(f.dir, name)
+		messages, err := read(path)
+		if err != nil {
+			return err
+		}
+		if filtered := filter(messages, f.capacity, now); len(filtered) != len(messages) {
+			if err := write(path, filtered); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func read(path string) ([]message, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	var (
+		res    []message
+		reader = bufio.NewReader(file)
+		header = make([]byte, 12)
+	)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return res, nil
+			}
+			return nil, err
+		}
+		msg := message{
+			expire: int64(binary.BigEndian.Uint64(header[:8])),
+			data:   make([]byte, binary.BigEndian.Uint32(header[8:])),
+		}
+		if _, err := io.ReadFull(reader, msg.data); err != nil {
+			return nil, err
+		}
+		res = append(res, msg)
+	}
+}
+
+// write the messages to a temp file and rename it , so the file will not be broken when
+// the process crash during writing
+func write(path string, messages []message) error {
+	if len(messages) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	header := make([]byte, 12)
+	for _, msg := range messages {
+		binary.BigEndian.PutUint64(header[:8], uint64(msg.expire))
+		binary.BigEndian.PutUint32(header[8:], uint32(len(msg.data)))
+		if _, err = writer.Write(header); err != nil {
+			break
+		}
+		if _, err = writer.Write(msg.data); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
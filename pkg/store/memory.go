@@ -0,0 +1,115 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// memoryStore keep the messages in memory , the messages will lose when the process exit
+type memoryStore struct {
+	rw       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	users    map[string][]message
+	// the expired messages of users who never come back are cleaned every ttl
+	lastClean int64
+	closed    bool
+}
+
+func NewMemoryStore(capacity int, ttl time.Duration) MessageStore {
+	capacity, ttl = fix(capacity, ttl)
+	return &memoryStore{
+		capacity:  capacity,
+		ttl:       ttl,
+		users:     map[string][]message{},
+		lastClean: time.Now().UnixNano(),
+	}
+}
+
+func (m *memoryStore) Save(identification string, data []byte) error {
+	if identification == "" {
+		return ErrIdentificationIsEmpty
+	}
+	now := time.Now().UnixNano()
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	if m.closed {
+		return ErrStoreIsClosed
+	}
+	messages := m.users[identification]
+	messages = append(messages, next(messages, now+int64(m.ttl), data))
+	m.users[identification] = filter(messages, m.capacity, now)
+	if now-m.lastClean > int64(m.ttl) {
+		m.clean(now)
+	}
+	return nil
+}
+
+func (m *memoryStore) Pop(identification string) ([][]byte, error) {
+	now := time.Now().UnixNano()
+	m.rw.Lock()
+	messages, ok := m.users[identification]
+	delete(m.users, identification)
+	m.rw.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	var res [][]byte
+	for _, msg := range filter(messages, m.capacity, now) {
+		res = append(res, msg.data)
+	}
+	return res, nil
+}
+
+func (m *memoryStore) Peek(identification string) ([]Message, error) {
+	now := time.Now().UnixNano()
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	return peek(m.users[identification], m.capacity, now), nil
+}
+
+func (m *memoryStore) Ack(identification string, offset uint64) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	if m.closed {
+		return ErrStoreIsClosed
+	}
+	if messages := ack(m.users[identification], offset); len(messages) == 0 {
+		delete(m.users, identification)
+	} else {
+		m.users[identification] = messages
+	}
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+	m.closed = true
+	m.users = map[string][]message{}
+	return nil
+}
+
+func (m *memoryStore) clean(now int64) {
+	for identification, messages := range m.users {
+		if messages = filter(messages, m.capacity, now); len(messages) == 0 {
+			delete(m.users, identification)
+		} else {
+			m.users[identification] = messages
+		}
+	}
+	m.lastClean = now
+}
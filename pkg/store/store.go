@@ -0,0 +1,118 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// MessageStore 是离线消息的存储单元，当消息的目标用户不在线的时候，消息会被存放到这里，用户再次
+// 建立连接的时候会将消息按照顺序取出重新下发，每个用户存放的消息数量是有上限的，超过上限会丢弃最早
+// 的消息，同时消息也有过期时间，过期的消息不会再被下发
+type MessageStore interface {
+	// Save keep the message for the offline user
+	Save(identification string, message []byte) error
+
+	// Pop return the messages kept for the user in the order of Save , and remove them from
+	// the store , the expired messages will not be returned
+	Pop(identification string) ([][]byte, error)
+
+	// Peek return the messages kept for the user in the order of Save without removing them , the
+	// expired messages will not be returned . The messages delivered are removed by Ack , so the
+	// messages not delivered are still at the head of queue
+	Peek(identification string) ([]Message, error)
+
+	// Ack remove the messages of user whose Offset is not larger than offset
+	Ack(identification string, offset uint64) error
+
+	// Close release the resource of store
+	Close() error
+}
+
+const (
+	DefaultCapacity = 1 << 6 // 64
+	DefaultTTL      = 24 * time.Hour
+)
+
+var (
+	// the identification of user is empty
+	ErrIdentificationIsEmpty = errors.New("store : the identification is empty")
+	// the store is closed
+	ErrStoreIsClosed = errors.New("store : the store is closed")
+)
+
+// Message is the message returned by Peek , the Offset is increasing in the order of Save
+type Message struct {
+	Offset uint64
+	Data   []byte
+}
+
+// message is the message kept in store , the expire of a user is strictly increasing , so it
+// is used as the offset of message too
+type message struct {
+	expire int64 // unix nano
+	data   []byte
+}
+
+// next return the message saved after messages
+func next(messages []message, expire int64, data []byte) message {
+	if n := len(messages); n > 0 && messages[n-1].expire >= expire {
+		expire = messages[n-1].expire + 1
+	}
+	return message{expire: expire, data: data}
+}
+
+// peek convert the messages not expired to Message
+func peek(messages []message, capacity int, now int64) []Message {
+	var res []Message
+	for _, msg := range filter(messages, capacity, now) {
+		res = append(res, Message{Offset: uint64(msg.expire), Data: msg.data})
+	}
+	return res
+}
+
+// ack remove the messages whose offset is not larger than offset
+func ack(messages []message, offset uint64) []message {
+	i := 0
+	for i < len(messages) && uint64(messages[i].expire) <= offset {
+		i++
+	}
+	return messages[i:]
+}
+
+// fix the capacity and ttl , use the default value when the param is illegal
+func fix(capacity int, ttl time.Duration) (int, time.Duration) {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return capacity, ttl
+}
+
+// filter remove the expired messages and keep the last capacity messages
+func filter(messages []message, capacity int, now int64) []message {
+	res := messages[:0]
+	for _, msg := range messages {
+		if msg.expire > now {
+			res = append(res, msg)
+		}
+	}
+	if len(res) > capacity {
+		res = res[len(res)-capacity:]
+	}
+	return res
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testStores(t *testing.T, capacity int, ttl time.Duration) map[string]MessageStore {
+	file, err := NewFileStore(t.TempDir(), capacity, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]MessageStore{
+		"memory": NewMemoryStore(capacity, ttl),
+		"file":   file,
+	}
+}
+
+func TestMessageStore_Order(t *testing.T) {
+	for name, st := range testStores(t, 3, time.Minute) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				if err := st.Save("steven", []byte(fmt.Sprintf("message_%d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			st.Save("mike", []byte("message_mike"))
+			messages, err := st.Pop("steven")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// only the last 3 messages are kept
+			if len(messages) != 3 || string(messages[0]) != "message_2" || string(messages[2]) != "message_4" {
+				t.Fatalf("Pop() = %q", messages)
+			}
+			if messages, _ := st.Pop("steven"); len(messages) != 0 {
+				t.Fatalf("the messages should be removed after Pop , left %q", messages)
+			}
+			if messages, _ := st.Pop("mike"); len(messages) != 1 {
+				t.Fatalf("Pop() of mike = %q", messages)
+			}
+			if err := st.Save("", []byte("message")); err != ErrIdentificationIsEmpty {
+				t.Fatalf("Save() error = '%v', wantErr '%v'", err, ErrIdentificationIsEmpty)
+			}
+			st.Close()
+		})
+	}
+}
+
+func TestMessageStore_TTL(t *testing.T) {
+	for name, st := range testStores(t, 3, 20*time.Millisecond) {
+		t.Run(name, func(t *testing.T) {
+			st.Save("steven", []byte("expired"))
+			time.Sleep(30 * time.Millisecond)
+			st.Save("steven", []byte("alive"))
+			messages, err := st.Pop("steven")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 1 || string(messages[0]) != "alive" {
+				t.Fatalf("Pop() = %q", messages)
+			}
+		})
+	}
+}
+
+func TestMessageStore_PeekAck(t *testing.T) {
+	for name, st := range testStores(t, 3, time.Minute) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				st.Save("steven", []byte(fmt.Sprintf("message_%d", i)))
+			}
+			messages, err := st.Peek("steven")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(messages) != 3 || string(messages[0].Data) != "message_0" || messages[0].Offset >= messages[1].Offset {
+				t.Fatalf("Peek() = %+v", messages)
+			}
+			// the first message is delivered , and a new message is saved before ack
+			st.Save("steven", []byte("message_3"))
+			if err := st.Ack("steven", messages[0].Offset); err != nil {
+				t.Fatal(err)
+			}
+			left, err := st.Pop("steven")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(left) != 3 || string(left[0]) != "message_1" || string(left[2]) != "message_3" {
+				t.Fatalf("the messages not acked should be kept in order , got %q", left)
+			}
+			if messages, _ := st.Peek("mike"); len(messages) != 0 {
+				t.Fatalf("Peek() of mike = %+v", messages)
+			}
+			st.Close()
+		})
+	}
+}
+
+func TestFileStore_Sweep(t *testing.T) {
+	dir := t.TempDir()
+	st, err := NewFileStore(dir, 3, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	// the identification longer than the limit of file name can be saved
+	long := strings.Repeat("steven", 100)
+	for _, identification := range []string{"steven", long} {
+		if err := st.Save(identification, []byte("message")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if messages, err := st.Peek(long); err != nil || len(messages) != 1 {
+		t.Fatalf("Peek() = %v , %v", messages, err)
+	}
+	// the files of users never come back are removed after expired
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		files, err := filepath.Glob(filepath.Join(dir, "*"+fileSuffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the expired files are not swept , left %v", files)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package timewheel
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// timewheel 是分层的时间轮，用来管理大量的超时任务，比如连接的心跳超时、ack 的重发超时。每一层由
+// 一圈槽组成，第一层每个槽是一个 tick ，上一层每个槽是下一层转一圈的时间，层数按需增加。指针每个
+// tick 前进一格，执行第一层当前槽中的任务；下一层转完一圈的时候，上一层对应槽中的任务会被重新放入
+// 下层。添加、重置和取消任务都是 O(1) 的，不需要像定时扫描那样遍历所有的连接，也不会长时间持有锁
+
+const (
+	DefaultTick  = 100 * time.Millisecond
+	DefaultSlots = 1 << 6 // 64
+)
+
+// Timer is the task added to wheel , it can be reset or stopped before expired
+type Timer struct {
+	wheel *Wheel
+	fn    func()
+
+	// expiration is the tick when the timer expired , slot and elem is the position in wheel ,
+	// elem is nil when the timer is not in wheel
+	expiration uint64
+	slot       *list.List
+	elem       *list.Element
+	stopped    bool
+}
+
+// Stop remove the timer from wheel , the timer stopped can't be reset , it returns false when
+// the timer is expired or stopped
+func (t *Timer) Stop() bool {
+	if t == nil {
+		return false
+	}
+	w := t.wheel
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	t.stopped = true
+	return w.remove(t)
+}
+
+// Reset change the timer to expire after d , the timer expired can be reset too , it returns
+// false when the timer is stopped
+func (t *Timer) Reset(d time.Duration) bool {
+	if t == nil {
+		return false
+	}
+	w := t.wheel
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.stopped {
+		return false
+	}
+	w.remove(t)
+	t.expiration = w.current + w.ticks(d)
+	w.insert(t)
+	return true
+}
+
+// Wheel is the hierarchical time wheel , the task is run in the goroutine of Run , so the task
+// should not block for long
+type Wheel struct {
+	tick  time.Duration
+	slots int
+
+	mu      sync.Mutex
+	current uint64
+	levels  [][]*list.List
+	count   int
+}
+
+// New create the wheel , the precision of timer is tick , and the first level has slots
+func New(tick time.Duration, slots int) *Wheel {
+	if tick <= 0 {
+		tick = DefaultTick
+	}
+	if slots <= 1 {
+		slots = DefaultSlots
+	}
+	w := &Wheel{tick: tick, slots: slots}
+	w.addLevel()
+	return w
+}
+
+func (w *Wheel) addLevel() {
+	level := make([]*list.List, w.slots)
+	for i := range level {
+		level[i] = list.New()
+	}
+	w.levels = append(w.levels, level)
+}
+
+// ticks return the ticks of d , the timer expires in the first tick after d
+func (w *Wheel) ticks(d time.Duration) uint64 {
+	ticks := uint64((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	return ticks
+}
+
+// AfterFunc run fn after d
+func (w *Wheel) AfterFunc(d time.Duration, fn func()) *Timer {
+	t := &Timer{wheel: w, fn: fn}
+	w.mu.Lock()
+	t.expiration = w.current + w.ticks(d)
+	w.insert(t)
+	w.mu.Unlock()
+	return t
+}
+
+// insert put the timer into the level which can hold it , it must be called with lock . The
+// timer expired during cascading is put in the current slot of first level , so it is run in
+// the same tick
+func (w *Wheel) insert(t *Timer) {
+	if t.expiration < w.current {
+		t.expiration = w.current
+	}
+	delta, span, level := t.expiration-w.current, uint64(w.slots), 0
+	for delta >= span {
+		level++
+		span *= uint64(w.slots)
+	}
+	for level >= len(w.levels) {
+		w.addLevel()
+	}
+	// the slot of level is the expiration divided by the span of slot
+	slot := t.expiration
+	for i := 0; i < level; i++ {
+		slot /= uint64(w.slots)
+	}
+	t.slot = w.levels[level][slot%uint64(w.slots)]
+	t.elem = t.slot.PushBack(t)
+	w.count++
+}
+
+// remove take the timer out of wheel , it must be called with lock
+func (w *Wheel) remove(t *Timer) bool {
+	if t.elem == nil {
+		return false
+	}
+	t.slot.Remove(t.elem)
+	t.slot, t.elem = nil, nil
+	w.count--
+	return true
+}
+
+// Len return the number of timers waiting
+func (w *Wheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Advance move the pointer one tick and run the timers expired , it is called by Run every tick
+func (w *Wheel) Advance() {
+	w.mu.Lock()
+	w.current++
+	// cascade the timers of upper levels when the lower level finish a round
+	span := uint64(1)
+	for level := 1; level < len(w.levels); level++ {
+		span *= uint64(w.slots)
+		if w.current%span != 0 {
+			break
+		}
+		slot := w.levels[level][(w.current/span)%uint64(w.slots)]
+		for e := slot.Front(); e != nil; e = slot.Front() {
+			t := e.Value.(*Timer)
+			w.remove(t)
+			w.insert(t)
+		}
+	}
+	var expired []*Timer
+	slot := w.levels[0][w.current%uint64(w.slots)]
+	for e := slot.Front(); e != nil; e = slot.Front() {
+		t := e.Value.(*Timer)
+		w.remove(t)
+		expired = append(expired, t)
+	}
+	w.mu.Unlock()
+	// the timer may add , reset or stop other timers , so run them without lock
+	for _, t := range expired {
+		t.fn()
+	}
+}
+
+// Run advance the wheel every tick until the done is closed
+func (w *Wheel) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.Advance()
+		case <-done:
+			return
+		}
+	}
+}
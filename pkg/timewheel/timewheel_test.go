@@ -0,0 +1,84 @@
+package timewheel
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWheel(t *testing.T) {
+	w := New(time.Second, 4)
+	var (
+		fired  []int
+		delays = []int{1, 4, 6, 9}
+	)
+	for _, n := range delays {
+		n := n
+		w.AfterFunc(time.Duration(n)*time.Second, func() { fired = append(fired, n) })
+	}
+	stopped := w.AfterFunc(2*time.Second, func() { t.Fatal("the timer stopped should not fire") })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("Stop() should return true only once")
+	}
+	for tick := 1; tick <= 10; tick++ {
+		w.Advance()
+		want := 0
+		for _, n := range delays {
+			if n <= tick {
+				want++
+			}
+		}
+		if len(fired) != want {
+			t.Fatalf("%v timers fired at tick %v , want %v", len(fired), tick, want)
+		}
+	}
+	if w.Len() != 0 || len(fired) != 4 {
+		t.Fatalf("Len() = %v , fired = %v", w.Len(), fired)
+	}
+}
+
+func TestWheel_Hierarchy(t *testing.T) {
+	w := New(time.Second, 4)
+	rand.Seed(time.Now().UnixNano())
+	var (
+		tick  int
+		fired int
+	)
+	for i := 0; i < 1000; i++ {
+		want := 1 + rand.Intn(300)
+		w.AfterFunc(time.Duration(want)*time.Second, func() {
+			if tick != want {
+				t.Errorf("the timer of %v ticks fired at %v", want, tick)
+			}
+			fired++
+		})
+	}
+	// the timer reset at the tick 50 fires after the new duration
+	reset := w.AfterFunc(100*time.Second, func() {
+		if tick != 69 {
+			t.Errorf("the timer reset fired at %v , want 69", tick)
+		}
+		fired++
+	})
+	for tick = 1; tick <= 300; tick++ {
+		if tick == 50 && !reset.Reset(20*time.Second) {
+			t.Fatal("Reset() should return true")
+		}
+		w.Advance()
+	}
+	if fired != 1001 || w.Len() != 0 {
+		t.Fatalf("fired = %v , Len() = %v", fired, w.Len())
+	}
+}
+
+func BenchmarkWheel_Reset(b *testing.B) {
+	w := New(DefaultTick, DefaultSlots)
+	timers := make([]*Timer, 1<<16)
+	for i := range timers {
+		timers[i] = w.AfterFunc(time.Duration(i%240)*time.Second, func() {})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timers[i&(len(timers)-1)].Reset(240 * time.Second)
+	}
+}
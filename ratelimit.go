@@ -0,0 +1,93 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"context"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/envelope"
+	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/ratelimit"
+	"go.uber.org/zap"
+)
+
+// RateLimitHooker is optional , if the Hooker implement it , the quota of user can be different
+// from the option , return nil to use the quota of option
+type RateLimitHooker interface {
+	RateLimitHook(identification string) *ratelimit.Quota
+}
+
+// attachLimit create the limiters of the connection registered
+func (s *Server) attachLimit(cli conn.Connect) {
+	if s.limits == nil {
+		return
+	}
+	var quota *ratelimit.Quota
+	if hooker, ok := s.hooker.(RateLimitHooker); ok {
+		quota = hooker.RateLimitHook(cli.Identification())
+	}
+	s.limits.Attach(cli, quota)
+}
+
+func (s *Server) detachLimit(cli conn.Connect) {
+	if s.limits != nil {
+		s.limits.Detach(cli)
+	}
+}
+
+// allow check the message received by connection , the action of option is done when the
+// message exceeds the limit
+func (s *Server) allow(cli conn.Connect, data []byte) bool {
+	if s.limits == nil {
+		return true
+	}
+	scope, ok := s.limits.Allow(cli, len(data))
+	if ok {
+		return true
+	}
+	opt := s.limits.Option()
+	s.metrics.rateLimited.With(string(scope), opt.Action.String()).Inc()
+	switch opt.Action {
+	case ratelimit.ActionWarn:
+		if !s.limits.Warn(cli) {
+			// the connection has been warned in the interval
+			return false
+		}
+		warning := opt.Warning
+		if s.opt.Envelope != nil {
+			var err error
+			if warning, err = s.opt.Envelope.Encode(envelope.Error("", ratelimit.ErrRateLimited)); err != nil {
+				logging.Log.Warn("allow", zap.String("ID", cli.Identification()), zap.Error(err))
+				return false
+			}
+		}
+		// the warning is sent in the normal lane , so it is limited by the buffer of connection
+		if err := cli.Send(warning); err != nil {
+			logging.Log.Warn("allow", zap.String("ID", cli.Identification()), zap.Error(err))
+		}
+	case ratelimit.ActionDisconnect:
+		if !s.limits.Disconnect(cli) {
+			// the connection is shutting down
+			return false
+		}
+		// the receive is blocked by Shutdown , so it is done in another goroutine
+		go func() {
+			ctx, cancel := context.WithTimeout(s.ctx, s.opt.ShutdownTimeout)
+			defer cancel()
+			cli.Shutdown(ctx, opt.CloseCode, opt.CloseReason)
+		}()
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package sim
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/dispatch"
+	"github.com/mongofs/sim/pkg/ratelimit"
+	"github.com/mongofs/sim/pkg/resume"
+)
+
+// limitHook echo the message , and give the vip user a larger quota
+type limitHook struct {
+	echoHook
+}
+
+func (h *limitHook) RateLimitHook(identification string) *ratelimit.Quota {
+	if identification == "vip" {
+		return &ratelimit.Quota{Connection: ratelimit.Limit{Messages: 0.001, MessageBurst: 3}}
+	}
+	return nil
+}
+
+func TestServer_RateLimit(t *testing.T) {
+	option := ratelimit.DefaultOption()
+	option.Connection = ratelimit.Limit{Messages: 0.001, MessageBurst: 1}
+	option.Action = ratelimit.ActionWarn
+	s, err := NewServer(&limitHook{}, WithRateLimit(option))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	steven, vip := &MockConn{id: "steven"}, &MockConn{id: "vip"}
+	for _, cli := range []*MockConn{steven, vip} {
+		if ok, err := s.register(TransportWebSocket, cli, resume.Cursor{}, nil); !ok {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			s.handleReceive(cli, []byte("hello"))
+		}
+	}
+	// the warning is sent once in the interval
+	if got := steven.messages(); len(got) != 2 || string(got[1]) != ratelimit.DefaultWarning {
+		t.Fatalf("steven received %q , want one echo and one warning", got)
+	}
+	if got := vip.messages(); len(got) != 3 || string(got[2]) != "hello" {
+		t.Fatalf("vip received %q , want three echoes", got)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `sim_rate_limited_total{scope="connection",action="warn"} 2`; !strings.Contains(recorder.Body.String(), want) {
+		t.Fatalf("metrics should contain %q , got \n%v", want, recorder.Body.String())
+	}
+}
+
+func TestServer_RateLimitDisconnect(t *testing.T) {
+	option := ratelimit.DefaultOption()
+	option.Connection = ratelimit.Limit{Messages: 0.001, MessageBurst: 1}
+	option.Action = ratelimit.ActionDisconnect
+	hook := &echoHook{}
+	s, err := NewServer(hook, WithRateLimit(option), WithDispatch(&dispatch.Option{Workers: 1, QueueSize: 1, Overflow: dispatch.OverflowDrop}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	cli := &shutdownConn{MockConn: &MockConn{id: "steven"}}
+	if ok, err := s.register(TransportWebSocket, cli, resume.Cursor{}, nil); !ok {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		s.handleReceive(cli, []byte("hello"))
+	}
+	for i := 0; i < 100 && (cli.shutdownTimes() == 0 || len(cli.messages()) == 0); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if got := cli.shutdownTimes(); got != 1 {
+		t.Fatalf("Shutdown() is called %v times , want once", got)
+	}
+	// the messages refused are not queued by the pool
+	if got := cli.messages(); len(got) != 1 {
+		t.Fatalf("received %q , want one echo", got)
+	}
+	recorder := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if want := `sim_dispatch_overflow_total{policy="drop"} 0`; !strings.Contains(recorder.Body.String(), want) {
+		t.Fatalf("the pool should not overflow , got \n%v", recorder.Body.String())
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/mongofs/sim/pkg/resume"
+)
+
+// the query parameters of the reconnecting request , the client presents the session id and the
+// last sequence it received , the server resends the messages missed , both of them are sent to
+// the client by the control frame of resume.Codec
+const (
+	ResumeSessionParam  = "session_id"
+	ResumeSequenceParam = "last_seq"
+)
+
+// resumeCursor return the cursor presented by the request , the zero cursor is returned when
+// the request is not a reconnecting request
+func resumeCursor(r *http.Request) resume.Cursor {
+	query := r.URL.Query()
+	id := query.Get(ResumeSessionParam)
+	if id == "" {
+		return resume.Cursor{}
+	}
+	last, err := strconv.ParseUint(query.Get(ResumeSequenceParam), 10, 64)
+	if err != nil {
+		// the sequence is missing , all the messages kept in session will be resent , the
+		// client will be told to resync when some of them are dropped
+		last = 0
+	}
+	return resume.Cursor{ID: id, Last: last}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/envelope"
+	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/rpc"
+	"go.uber.org/zap"
+)
+
+var errEnvelopeIsOff = errors.New("the envelope protocol is turned off ")
+
+// RegisterMethod register the method can be called by client , the handler can be rpc.Handler ,
+// or the typed function func(context.Context, conn.Connect, Request) (Response, error) , the
+// request and response of typed function are encoded by JSON . The method works when the
+// envelope protocol is turned on
+func (s *Server) RegisterMethod(method string, handler interface{}) error {
+	return s.router.Register(method, handler)
+}
+
+// Call call the method of client and wait for the response , the call is failed when the ctx is
+// done , the client replied error or the connection closed , if the ctx has no deadline , the
+// rpc.DefaultTimeout is used
+func (s *Server) Call(ctx context.Context, cli conn.Connect, method string, payload []byte) ([]byte, error) {
+	if s.opt.Envelope == nil {
+		return nil, errEnvelopeIsOff
+	}
+	return s.calls.Call(ctx, cli, func(id string) error {
+		e := envelope.New(envelope.TypeRequest, id, payload)
+		e.Method = method
+		data, err := s.opt.Envelope.Encode(e)
+		if err != nil {
+			return err
+		}
+		return cli.Send(data)
+	})
+}
+
+// serveRequest call the method requested by client and reply the response , the request is
+// served in its own goroutine , so the handler can call the client too . The ctx is canceled
+// when the connection closed , and the panic of handler is replied as rpc.ErrInternal
+func (s *Server) serveRequest(ctx context.Context, release func(), cli conn.Connect, e *envelope.Envelope) {
+	defer release()
+	defer func() {
+		if err := recover(); err != nil {
+			logging.Log.Error("serveRequest", zap.String("ID", cli.Identification()), zap.String("METHOD", e.Method),
+				zap.Any("PANIC", err), zap.Stack("STACK"))
+			s.reply(cli, envelope.Error(e.ID, rpc.ErrInternal))
+		}
+	}()
+	result, err := s.router.Serve(ctx, cli, e.Method, e.Payload)
+	if err != nil {
+		s.reply(cli, envelope.Error(e.ID, err))
+		return
+	}
+	s.reply(cli, envelope.New(envelope.TypeResponse, e.ID, result))
+}
@@ -0,0 +1,160 @@
+package sim
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/envelope"
+	"github.com/mongofs/sim/pkg/rpc"
+)
+
+func TestServer_RPC(t *testing.T) {
+	codec := envelope.JSONCodec{}
+	s, err := NewServer(&hook{}, WithEnvelope(codec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	type greeting struct {
+		Name string `json:"name"`
+	}
+	if err := s.RegisterMethod("greet", func(ctx context.Context, cli conn.Connect, req greeting) (string, error) {
+		return "hello " + req.Name + " from " + cli.Identification(), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cli := &MockConn{id: "steven"}
+	if _, _, err := s.bucket(cli.id).Register(cli); err != nil {
+		t.Fatal(err)
+	}
+	// wait for the envelope sent to the connection
+	next := func(n int) *envelope.Envelope {
+		for deadline := time.Now().Add(time.Second); len(cli.messages()) < n; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("the connection received %v messages , want %v", len(cli.messages()), n)
+			}
+		}
+		e, err := codec.Decode(cli.messages()[n-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	send := func(e *envelope.Envelope) {
+		data, err := codec.Encode(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.handleReceive(cli, data)
+	}
+
+	// the client call the server
+	send(&envelope.Envelope{Type: envelope.TypeRequest, ID: "1", Method: "greet", Payload: []byte(`{"name":"mike"}`)})
+	if e := next(1); e.Type != envelope.TypeResponse || e.ID != "1" || string(e.Payload) != `"hello mike from steven"` {
+		t.Fatalf("response = %+v", e)
+	}
+	send(&envelope.Envelope{Type: envelope.TypeRequest, ID: "2", Method: "unknown"})
+	if e := next(2); e.Type != envelope.TypeError || e.ID != "2" {
+		t.Fatalf("response of unknown method = %+v", e)
+	}
+
+	// the server call the client
+	result := make(chan error, 1)
+	go func() {
+		payload, err := s.Call(context.Background(), cli, "view.report", nil)
+		if err == nil && string(payload) != "home" {
+			t.Errorf("Call() = %s , want home", payload)
+		}
+		result <- err
+	}()
+	request := next(3)
+	if request.Type != envelope.TypeRequest || request.Method != "view.report" {
+		t.Fatalf("request = %+v", request)
+	}
+	send(envelope.New(envelope.TypeResponse, request.ID, []byte("home")))
+	if err := <-result; err != nil {
+		t.Fatal(err)
+	}
+
+	// the pending call is canceled when the connection is offline
+	go func() {
+		_, err := s.Call(context.Background(), cli, "view.report", nil)
+		result <- err
+	}()
+	next(4)
+	s.bucket(cli.id).Offline(cli.id, "")
+	if err := <-result; err != rpc.ErrConnectionClosed {
+		t.Fatalf("Call() error = '%v', wantErr '%v'", err, rpc.ErrConnectionClosed)
+	}
+}
+
+func TestServer_RPCInflight(t *testing.T) {
+	codec := envelope.JSONCodec{}
+	s, err := NewServer(&hook{}, WithEnvelope(codec), WithMaxInflightRequests(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	s.RegisterMethod("panic", func(ctx context.Context, cli conn.Connect, req struct{}) (string, error) {
+		panic("method panic")
+	})
+	canceled := make(chan struct{})
+	s.RegisterMethod("wait", func(ctx context.Context, cli conn.Connect, req struct{}) (string, error) {
+		<-ctx.Done()
+		close(canceled)
+		return "", ctx.Err()
+	})
+	cli := &MockConn{id: "steven"}
+	if _, _, err := s.bucket(cli.id).Register(cli); err != nil {
+		t.Fatal(err)
+	}
+	next := func(n int) *envelope.Envelope {
+		for deadline := time.Now().Add(time.Second); len(cli.messages()) < n; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("the connection received %v messages , want %v", len(cli.messages()), n)
+			}
+		}
+		e, err := codec.Decode(cli.messages()[n-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	send := func(e *envelope.Envelope) {
+		data, err := codec.Encode(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.handleReceive(cli, data)
+	}
+
+	send(&envelope.Envelope{Type: envelope.TypeRequest, ID: "1", Method: "wait"})
+	send(&envelope.Envelope{Type: envelope.TypeRequest, ID: "2", Method: "wait"})
+	if e := next(1); e.Type != envelope.TypeError || e.ID != "2" || string(e.Payload) != rpc.ErrTooManyRequests.Error() {
+		t.Fatalf("response of the request exceeds the limit = %+v", e)
+	}
+	s.bucket(cli.id).Offline(cli.id, "")
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the context of request should be canceled when the connection offline")
+	}
+
+	// the panic of method is replied as error , and the connection is still alive
+	cli = &MockConn{id: "mike"}
+	if _, _, err := s.bucket(cli.id).Register(cli); err != nil {
+		t.Fatal(err)
+	}
+	send(&envelope.Envelope{Type: envelope.TypeRequest, ID: "3", Method: "panic"})
+	if e := next(1); e.Type != envelope.TypeError || e.ID != "3" || string(e.Payload) != rpc.ErrInternal.Error() {
+		t.Fatalf("response of panic method = %+v", e)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"net/http"
+
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// SessionPolicy decide what to do when a user who is online upgrade again , for example the
+// user login on phone and desktop at the same time
+type SessionPolicy int
+
+const (
+	// SessionPolicySqueezeOut the old connections will be squeezed out , a user can only have
+	// one connection , this is the default policy
+	SessionPolicySqueezeOut SessionPolicy = iota
+
+	// SessionPolicyMultiple a user can have MaxSessionConnections connections at the same time ,
+	// the oldest connection will be squeezed out when the limit is exceeded
+	SessionPolicyMultiple
+
+	// SessionPolicyDeviceExclusive a user can have one connection on each device , the old
+	// connection of the same device will be squeezed out , the device is returned by
+	// DeviceIdentificationHooker
+	SessionPolicyDeviceExclusive
+)
+
+const DefaultMaxSessionConnections = 1 << 3 // 8
+
+// DeviceIdentificationHooker is optional , if the Hooker implement it , it will be used
+// instead of IdentificationHook , the device is used by SessionPolicyDeviceExclusive and
+// SendToDevice
+type DeviceIdentificationHooker interface {
+	DeviceIdentificationHook(w http.ResponseWriter, r *http.Request) (identification, device string, err error)
+}
+
+// apply return the connections should be kept and squeezed out before the cli register
+func (p SessionPolicy) apply(session []conn.Connect, cli conn.Connect, max int) (kept, squeezed []conn.Connect) {
+	switch p {
+	case SessionPolicyMultiple:
+		kept = append(kept, session...)
+	case SessionPolicyDeviceExclusive:
+		for _, c := range session {
+			if c.Device() == cli.Device() {
+				squeezed = append(squeezed, c)
+				continue
+			}
+			kept = append(kept, c)
+		}
+	default:
+		return nil, session
+	}
+	// the oldest connections are squeezed out when the limit is exceeded
+	if max > 0 && len(kept) >= max {
+		over := len(kept) - max + 1
+		squeezed = append(squeezed, kept[:over]...)
+		kept = kept[over:]
+	}
+	return kept, squeezed
+}
@@ -16,36 +16,38 @@ package sim
 import (
 	"context"
 	"fmt"
-	"github.com/mongofs/sim/pkg/conn"
 	"github.com/mongofs/sim/pkg/logging"
 	"github.com/zhenjl/cityhash"
 	"go.uber.org/zap"
+	"net/http"
+	"net/http/pprof"
 	"time"
 )
 
 //  init the bucket
-func (s *sim) initBucket() {
+func (s *Server) initBucket() {
 	// prepare buckets
 	s.bs = make([]bucketInterface, s.opt.ServerBucketNumber)
-	s.ctx, s.cancel = context.WithCancel(context.Background())
 
 	for i := 0; i < s.opt.ServerBucketNumber; i++ {
-		s.bs[i] = NewBucket(s.opt, i, s.ctx)
+		bt := NewBucket(s.opt, i, s.ctx)
+		bt.callback = s.removed
+		bt.offlineCounter = s.metrics.offline
+		bt.wheel = s.wheel
+		s.bs[i] = bt
 	}
 
 	logging.Log.Info("initBucket", zap.Int("BUCKET_NUMBER", s.opt.ServerBucketNumber))
 	logging.Log.Info("initBucket", zap.Int("BUCKET_SIZE", s.opt.BucketSize))
 }
 
-func (s *sim) bucket(token string) bucketInterface {
+func (s *Server) bucket(token string) bucketInterface {
 	idx := s.routeBucket(token, uint32(s.opt.ServerBucketNumber))
 	return s.bs[idx]
 }
 
-func (s *sim) monitorBucket(ctx context.Context) (string, error) {
+func (s *Server) monitorBucket(ctx context.Context) (string, error) {
 	var interval = 10
-	var dataMonitorInterval = 60
-	dataMonitorTimer := time.NewTimer(time.Duration(dataMonitorInterval) * time.Second)
 	timer := time.NewTicker(time.Duration(interval) * time.Second)
 	logging.Log.Info("monitorBucket ", zap.Int("MONITOR_ONLINE_INTERVAL", interval))
 	for {
@@ -58,7 +60,7 @@ func (s *sim) monitorBucket(ctx context.Context) (string, error) {
 				sum += int64(v.Count())
 			}
 			s.num.Store(sum)
-			if s.opt.debug == true {
+			if s.pprofEnabled() {
 				// you get get the pprof ,
 				pprof := fmt.Sprintf("http://127.0.0.1%v/debug/pprof", s.opt.PProfPort)
 
@@ -66,18 +68,42 @@ func (s *sim) monitorBucket(ctx context.Context) (string, error) {
 			} else {
 				logging.Log.Info("monitorBucket ", zap.Int64("ONLINE", s.num.Load()))
 			}
-		case <-dataMonitorTimer.C:
-			content, loseContent, contentLength := conn.SwapSendData()
-			logging.Log.Info("monitorBucket",
-				zap.Int64("COUNT_LOSE_CONTENT", loseContent),
-				zap.Int64("COUNT_CONTENT", content),
-				zap.Int64("COUNT_CONTENT_LEN(Byte)", contentLength),
-				zap.Int64("COUNT_CONTENT_LEN(KB)", contentLength/1024),
-				zap.Int64("COUNT_CONTENT_LEN(MB)", contentLength/1024/1024))
 		}
 	}
 }
 
-func (s *sim) routeBucket(token string, size uint32) uint32 {
+// runWheel advance the timers of heartbeat and ack until the server is closed
+func (s *Server) runWheel(ctx context.Context) (string, error) {
+	s.wheel.Run(ctx.Done())
+	return "timeWheel", nil
+}
+
+// pprofEnabled judge the pprof is served or not , it is served in debug mode
+func (s *Server) pprofEnabled() bool {
+	return s.opt.debug && s.opt.PProfPort != ""
+}
+
+// servePprof serve the pprof on Options.PProfPort until the server is closed , the handlers
+// are registered on the mux of Server rather than http.DefaultServeMux , so the Servers in the
+// same process can serve pprof on different address
+func (s *Server) servePprof(ctx context.Context) (string, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Addr: s.opt.PProfPort, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return "pprof", err
+	}
+	return "pprof", nil
+}
+
+func (s *Server) routeBucket(token string, size uint32) uint32 {
 	return cityhash.CityHash32([]byte(token), uint32(len(token))) % size
 }
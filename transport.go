@@ -0,0 +1,154 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"bytes"
+	"errors"
+	"net"
+
+	"github.com/mongofs/sim/pkg/auth"
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"github.com/mongofs/sim/pkg/resume"
+	"go.uber.org/zap"
+)
+
+// the name of transports
+const (
+	TransportWebSocket = "websocket"
+	TransportTCP       = "tcp"
+)
+
+var (
+	// the hooker can not identify the connection of transport
+	errHookNotSupportTransport = errors.New("the hook not implement TransportIdentificationHooker ")
+	// the Authenticator can not validate the token of transport handshake
+	errAuthNotSupportTransport = errors.New("the authenticator not implement auth.Validator , the transport can't be authenticated ")
+)
+
+// Transport accept the connections of a network protocol , the connection is handed to Server
+// as conn.Wire after the handshake , so the connections of different transports share the
+// buckets , heartbeat , hooks and metrics . The WebSocket transport is driven by the http
+// server of user through Upgrade , other transports are served by Server.Serve
+type Transport interface {
+	// Name is the name of transport , it is used in log and metrics
+	Name() string
+
+	// Serve accept connections until Close is called , handle is called in a new goroutine
+	// for each connection after the handshake frame is read
+	Serve(handle HandleWire) error
+
+	// Close stop accepting connections , the connections accepted are not affected
+	Close() error
+}
+
+// HandleWire register the wire to Server , handshake is the first frame sent by client , it
+// carry the information to identify the user , for example the token . When the
+// Options.Authenticator is set , the handshake is the token validated by it
+type HandleWire func(wire conn.Wire, handshake []byte, remote net.Addr)
+
+// TransportIdentificationHooker is optional , the Hooker must implement it to serve the
+// transport which is not based on http , for example tcp
+type TransportIdentificationHooker interface {
+	TransportIdentificationHook(transport string, remote net.Addr, handshake []byte) (identification, device string, err error)
+}
+
+// Serve accept the connections of transport and register them to Server , it blocks until
+// the transport is closed , the transport will be closed when the Server shutdown . When the
+// Options.Authenticator is set , it must implement auth.Validator , the handshake frame is
+// validated as the token , so the transport is authenticated the same as websocket
+func (s *Server) Serve(t Transport) error {
+	if s.running.Load() != RunStatusRunning {
+		// that is mean the sim not run
+		return errServerIsNotRunning
+	}
+	identify, err := s.transportIdentification()
+	if err != nil {
+		return err
+	}
+	s.transportLock.Lock()
+	s.transports = append(s.transports, t)
+	s.transportLock.Unlock()
+
+	name := t.Name()
+	return t.Serve(func(wire conn.Wire, handshake []byte, remote net.Addr) {
+		if s.running.Load() != RunStatusRunning {
+			wire.Close()
+			return
+		}
+		identification, device, principal, err := identify(name, remote, handshake)
+		if err != nil {
+			s.metrics.upgrades.With(name, upgradeFailure).Inc()
+			s.metrics.observeUnauthorized(name, err)
+			logging.Log.Warn("Serve", zap.String("TRANSPORT", name), zap.String("REMOTE", remote.String()), zap.Error(err))
+			wire.Close()
+			return
+		}
+		sig := s.bucket(identification).SignalChannel()
+		cli := conn.NewWireConn(identification, device, wire, sig, s.handleReceive, s.opt.Connection)
+		cli.SetAttribute(conn.AttrTransport, name)
+		if host, _, err := net.SplitHostPort(remote.String()); err == nil {
+			cli.SetAttribute(conn.AttrRemoteAddr, host)
+		}
+		if device != "" {
+			cli.SetAttribute(conn.AttrDevice, device)
+		}
+		if _, err := s.register(name, cli, resume.Cursor{}, principal); err != nil {
+			logging.Log.Warn("Serve", zap.String("TRANSPORT", name), zap.String("ID", identification), zap.Error(err))
+		}
+	})
+}
+
+// transportIdentification return the function identify the connection by the handshake , the
+// handshake is validated by the Authenticator if it is set , otherwise it is identified by the
+// hooker
+func (s *Server) transportIdentification() (func(transport string, remote net.Addr, handshake []byte) (string, string, *auth.Principal, error), error) {
+	if s.opt.Authenticator != nil {
+		validator, ok := s.opt.Authenticator.(auth.Validator)
+		if !ok {
+			return nil, errAuthNotSupportTransport
+		}
+		return func(transport string, remote net.Addr, handshake []byte) (string, string, *auth.Principal, error) {
+			token := string(bytes.TrimSpace(handshake))
+			if token == "" {
+				return "", "", nil, auth.ErrTokenMissing
+			}
+			principal, err := validator.Validate(token)
+			if err != nil {
+				return "", "", nil, err
+			}
+			return principal.Subject, principal.Device, principal, nil
+		}, nil
+	}
+	hooker, ok := s.hooker.(TransportIdentificationHooker)
+	if !ok {
+		return nil, errHookNotSupportTransport
+	}
+	return func(transport string, remote net.Addr, handshake []byte) (string, string, *auth.Principal, error) {
+		identification, device, err := hooker.TransportIdentificationHook(transport, remote, handshake)
+		return identification, device, nil, err
+	}, nil
+}
+
+func (s *Server) closeTransports() {
+	s.transportLock.Lock()
+	defer s.transportLock.Unlock()
+	for _, t := range s.transports {
+		if err := t.Close(); err != nil {
+			logging.Log.Error("closeTransports", zap.String("TRANSPORT", t.Name()), zap.Error(err))
+		}
+	}
+	s.transports = nil
+}
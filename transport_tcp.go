@@ -0,0 +1,110 @@
+/*
+ * Copyright 2022 steven
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sim
+
+import (
+	"bufio"
+	"net"
+	"time"
+
+	"github.com/mongofs/sim/pkg/conn"
+	"github.com/mongofs/sim/pkg/logging"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// tcp 传输层使用长度前缀的帧格式（见 conn.ReadFrame），客户端建立连接后发送的第一帧是握手帧，
+// 握手帧的内容交给 TransportIdentificationHooker 识别用户，之后的每一帧都是一条消息
+
+const (
+	DefaultTCPHandshakeTimeout = 5 * time.Second
+)
+
+type TCPOption struct {
+	MaxFrameSize     int           // MaxFrameSize the max length of the frame sent by client
+	HandshakeTimeout time.Duration // HandshakeTimeout the time limit of reading handshake frame
+}
+
+func DefaultTCPOption() *TCPOption {
+	return &TCPOption{
+		MaxFrameSize:     conn.DefaultMaxFrameSize,
+		HandshakeTimeout: DefaultTCPHandshakeTimeout,
+	}
+}
+
+// TCPTransport accept the raw tcp connections which use length-prefixed frame
+type TCPTransport struct {
+	listener net.Listener
+	opt      *TCPOption
+	closed   atomic.Bool
+}
+
+// NewTCPTransport create the tcp transport on listener , if the option is nil , the
+// DefaultTCPOption will be used
+func NewTCPTransport(listener net.Listener, option *TCPOption) *TCPTransport {
+	if option == nil {
+		option = DefaultTCPOption()
+	}
+	return &TCPTransport{listener: listener, opt: option}
+}
+
+func (t *TCPTransport) Name() string {
+	return TransportTCP
+}
+
+func (t *TCPTransport) Serve(handle HandleWire) error {
+	var delay time.Duration
+	for {
+		c, err := t.listener.Accept()
+		if err != nil {
+			if t.closed.Load() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				// the same as net/http , wait a moment and retry
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				logging.Log.Warn("TCPTransport accept", zap.Duration("RETRY_IN", delay), zap.Error(err))
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go t.handshake(c, handle)
+	}
+}
+
+func (t *TCPTransport) Close() error {
+	t.closed.Store(true)
+	return t.listener.Close()
+}
+
+func (t *TCPTransport) handshake(c net.Conn, handle HandleWire) {
+	if t.opt.HandshakeTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(t.opt.HandshakeTimeout))
+	}
+	reader := bufio.NewReader(c)
+	data, err := conn.ReadFrame(reader, t.opt.MaxFrameSize)
+	if err != nil {
+		logging.Log.Warn("TCPTransport handshake", zap.String("REMOTE", c.RemoteAddr().String()), zap.Error(err))
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	handle(conn.NewTCPWire(c, reader, t.opt.MaxFrameSize), data, c.RemoteAddr())
+}
@@ -0,0 +1,181 @@
+package sim
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mongofs/sim/pkg/auth"
+	"github.com/mongofs/sim/pkg/conn"
+)
+
+// tcpHook identify the tcp connection by the handshake frame , the handshake "bad" is refused
+type tcpHook struct {
+	hook
+	received chan string
+}
+
+func (h *tcpHook) Validate(token string) error {
+	return nil
+}
+
+func (h *tcpHook) ValidateSuccess(cli conn.Connect) {}
+
+func (h *tcpHook) HandleReceive(cli conn.Connect, data []byte) {
+	h.received <- cli.Identification() + ":" + string(data)
+}
+
+func (h *tcpHook) TransportIdentificationHook(transport string, remote net.Addr, handshake []byte) (string, string, error) {
+	if string(handshake) == "bad" {
+		return "", "", errors.New("bad handshake")
+	}
+	return string(handshake), transport, nil
+}
+
+func TestServer_ServeTCP(t *testing.T) {
+	h := &tcpHook{received: make(chan string, 1)}
+	s, err := NewServer(h, WithServerBucketNumber(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(NewTCPTransport(nil, nil)); err != errServerIsNotRunning {
+		t.Fatalf("Serve() error = '%v', wantErr '%v'", err, errServerIsNotRunning)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(NewTCPTransport(listener, nil)) }()
+
+	dial := func(handshake string) net.Conn {
+		c, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteFrame(c, []byte(handshake)); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	bad := dial("bad")
+	defer bad.Close()
+	bad.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.ReadFrame(bad, 0); err == nil {
+		t.Fatal("the connection of bad handshake should be closed")
+	}
+
+	client := dial("steven")
+	defer client.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if clients, ok := s.bucket("steven").Get("steven"); ok {
+			if clients[0].Device() != TransportTCP {
+				t.Fatalf("the device got %v", clients[0].Device())
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("steven is not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.SendMessage([]byte("hello steven"), []string{"steven"}); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if data, err := conn.ReadFrame(client, 0); err != nil || string(data) != "hello steven" {
+		t.Fatalf("client received %q , err %v", data, err)
+	}
+	if err := conn.WriteFrame(client, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-h.received:
+		if got != "steven:ping" {
+			t.Fatalf("HandleReceive got %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("HandleReceive is not called")
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve() should return nil after shutdown , got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve() is not returned after shutdown")
+	}
+}
+
+// requestAuthenticator can only authenticate the http request
+type requestAuthenticator struct{}
+
+func (requestAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	return &auth.Principal{Subject: r.URL.Query().Get("id")}, nil
+}
+
+func TestServer_ServeTCPAuthenticated(t *testing.T) {
+	s, err := NewServer(&echoHook{}, WithServerBucketNumber(2), WithAuthenticator(requestAuthenticator{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Serve(NewTCPTransport(nil, nil)); err != errAuthNotSupportTransport {
+		t.Fatalf("Serve() error = '%v', wantErr '%v'", err, errAuthNotSupportTransport)
+	}
+	s.Stop()
+
+	authenticator, err := auth.NewJWT(&auth.Option{Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewServer(&echoHook{}, WithServerBucketNumber(2), WithAuthenticator(authenticator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Run(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(NewTCPTransport(listener, nil))
+	dial := func(handshake string) net.Conn {
+		c, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteFrame(c, []byte(handshake)); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	bad := dial("steven")
+	defer bad.Close()
+	bad.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.ReadFrame(bad, 0); err == nil {
+		t.Fatal("the connection of invalid token should be closed")
+	}
+
+	client := dial(signHS256(t, map[string]interface{}{"sub": "steven"}))
+	defer client.Close()
+	waitOnline(t, s, "steven")
+	clients, _ := s.bucket("steven").Get("steven")
+	if principal, ok := s.Principal(clients[0]); !ok || principal.Subject != "steven" {
+		t.Fatalf("Principal() = %+v , %v", principal, ok)
+	}
+}